@@ -1,6 +1,7 @@
 module github.com/dmacvicar/terraform-provider-libvirt
 
 require (
+	github.com/beevik/etree v1.5.0
 	github.com/community-terraform-providers/terraform-provider-ignition/v2 v2.1.2
 	github.com/davecgh/go-spew v1.1.1
 	github.com/digitalocean/go-libvirt v0.0.0-20240916165608-bff44a349d9d
@@ -8,10 +9,13 @@ require (
 	github.com/hashicorp/terraform-plugin-sdk/v2 v2.34.0
 	github.com/hooklift/iso9660 v1.0.0
 	github.com/kevinburke/ssh_config v1.2.0
+	github.com/klauspost/compress v1.17.11
 	github.com/mattn/goveralls v0.0.12
 	github.com/stretchr/testify v1.9.0
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/crypto v0.36.0
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
+	golang.org/x/sys v0.31.0
 	libvirt.org/go/libvirtxml v1.10007.0
 )
 
@@ -69,7 +73,6 @@ require (
 	golang.org/x/mod v0.21.0 // indirect
 	golang.org/x/net v0.37.0 // indirect
 	golang.org/x/sync v0.12.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	golang.org/x/tools v0.25.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
@@ -13,20 +13,32 @@ import (
 // Config struct for the libvirt-provider.
 type Config struct {
 	URI string
+	// directory where the images of http sources are cached, if any
+	DownloadCacheDir string
+	// default settings to download http sources
+	SourceHTTP map[string]interface{}
 }
 
 // Client libvirt.
 type Client struct {
-	libvirt     *libvirt.Libvirt
+	libvirt *libvirt.Libvirt
+	// connection URI of libvirt
+	uri         string
 	poolMutexKV *mutexkv.MutexKV
 	// define only one network at a time
 	// https://gitlab.com/libvirt/libvirt/-/issues/78
 	networkMutex sync.Mutex
+	// shared subscription to domain events, used to wait for domains
+	domainEvents *domainEventHub
+	// cache of the images of http sources, nil when disabled
+	downloadCache *downloadCache
+	// default settings to download http sources, from the provider
+	sourceHTTP map[string]interface{}
 }
 
-// Client libvirt, returns a libvirt client for a config.
-func (c *Config) Client() (*Client, error) {
-	u, err := uri.Parse(c.URI)
+// connectURI opens a connection to the libvirt daemon of a connection URI.
+func connectURI(uriStr string) (*libvirt.Libvirt, error) {
+	u, err := uri.Parse(uriStr)
 	if err != nil {
 		return nil, err
 	}
@@ -36,6 +48,15 @@ func (c *Config) Client() (*Client, error) {
 	if err := l.ConnectToURI(libvirt.ConnectURI(u.RemoteName())); err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
+	return l, nil
+}
+
+// Client libvirt, returns a libvirt client for a config.
+func (c *Config) Client() (*Client, error) {
+	l, err := connectURI(c.URI)
+	if err != nil {
+		return nil, err
+	}
 
 	v, err := l.ConnectGetLibVersion()
 	if err != nil {
@@ -44,9 +65,29 @@ func (c *Config) Client() (*Client, error) {
 	log.Printf("[INFO] libvirt client libvirt version: %v\n", v)
 
 	client := &Client{
-		libvirt:     l,
-		poolMutexKV: mutexkv.NewMutexKV(),
+		libvirt:      l,
+		uri:          c.URI,
+		poolMutexKV:  mutexkv.NewMutexKV(),
+		domainEvents: newDomainEventHub(l),
+	}
+
+	// catch wrong settings, like a missing CA bundle, before they are used
+	if _, err := newHTTPOptions(c.SourceHTTP); err != nil {
+		return nil, fmt.Errorf("invalid source_http settings: %w", err)
+	}
+	client.sourceHTTP = c.SourceHTTP
+
+	if c.DownloadCacheDir != "" {
+		if client.downloadCache, err = newDownloadCache(c.DownloadCacheDir); err != nil {
+			return nil, err
+		}
 	}
 
 	return client, nil
 }
+
+// sourceHTTPOptions returns the options to download the http sources of a resource,
+// from its source_http block key and the defaults of the provider.
+func (c *Client) sourceHTTPOptions(d resourceConfig, key string) (*httpOptions, error) {
+	return newHTTPOptions(mergeSourceHTTPSettings(c.sourceHTTP, sourceHTTPSettings(d, key)))
+}
@@ -0,0 +1,79 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a libvirt domain console output datasource
+//
+// Datasource example:
+//
+//	data "libvirt_domain_console_output" "boot" {
+//	  domain_id = libvirt_domain.vm.id
+//	  duration  = 10
+//	}
+//
+//	output "boot_log" {
+//	  value = data.libvirt_domain_console_output.boot.output
+//	}
+func datasourceLibvirtDomainConsoleOutput() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainConsoleOutputRead,
+		Schema: map[string]*schema.Schema{
+			"domain_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"device": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"duration": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  5, //nolint:mnd
+			},
+			"output": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainConsoleOutputRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Read data source libvirt_domain_console_output")
+
+	client := meta.(*Client)
+	virConn := client.libvirt
+
+	domainID := d.Get("domain_id").(string)
+	domain, err := virConn.DomainLookupByUUID(parseUUID(domainID))
+	if err != nil {
+		return fmt.Errorf("failed to lookup domain %s: %w", domainID, err)
+	}
+
+	capture, done, err := startConsoleCapture(client.uri, domain, d.Get("device").(string))
+	if err != nil {
+		return fmt.Errorf("failed to connect to the console of domain %s: %w", domainID, err)
+	}
+
+	select {
+	case <-time.After(time.Duration(d.Get("duration").(int)) * time.Second):
+	case err := <-done:
+		if err != nil && !errors.Is(err, errConsoleCaptureStopped) {
+			capture.Stop()
+			return fmt.Errorf("failed to open the console of domain %s: %w", domainID, err)
+		}
+	}
+
+	d.Set("output", capture.Stop())
+	d.SetId(domainID)
+
+	return nil
+}
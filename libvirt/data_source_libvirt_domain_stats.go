@@ -0,0 +1,169 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/hashcode"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func computedIntSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeInt,
+		Computed: true,
+	}
+}
+
+func computedStringSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeString,
+		Computed: true,
+	}
+}
+
+// a libvirt domain statistics datasource
+//
+// Datasource example:
+//
+//	data "libvirt_domain_stats" "running" {
+//	  state = "running"
+//	}
+//
+//	output "cpu_time" {
+//	  value = { for d in data.libvirt_domain_stats.running.domains : d.name => d.cpu_time }
+//	}
+func datasourceLibvirtDomainStats() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainStatsRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"tags": tagsSchema(),
+			"domains": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id":              computedStringSchema(),
+						"name":            computedStringSchema(),
+						"state":           computedStringSchema(),
+						"cpu_time":        computedIntSchema(),
+						"balloon_current": computedIntSchema(),
+						"balloon_maximum": computedIntSchema(),
+						"vcpu_current":    computedIntSchema(),
+						"vcpu_maximum":    computedIntSchema(),
+						"vcpus": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"state": computedStringSchema(),
+									"time":  computedIntSchema(),
+								},
+							},
+						},
+						"interfaces": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name":       computedStringSchema(),
+									"rx_bytes":   computedIntSchema(),
+									"rx_packets": computedIntSchema(),
+									"rx_errors":  computedIntSchema(),
+									"rx_drops":   computedIntSchema(),
+									"tx_bytes":   computedIntSchema(),
+									"tx_packets": computedIntSchema(),
+									"tx_errors":  computedIntSchema(),
+									"tx_drops":   computedIntSchema(),
+								},
+							},
+						},
+						"disks": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name":           computedStringSchema(),
+									"path":           computedStringSchema(),
+									"read_bytes":     computedIntSchema(),
+									"read_requests":  computedIntSchema(),
+									"write_bytes":    computedIntSchema(),
+									"write_requests": computedIntSchema(),
+									"allocation":     computedIntSchema(),
+									"capacity":       computedIntSchema(),
+									"physical":       computedIntSchema(),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainStatsRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Read data source libvirt_domain_stats")
+
+	virConn := meta.(*Client).libvirt
+
+	var flags libvirt.ConnectGetAllDomainStatsFlags
+	if state, ok := d.GetOk("state"); ok {
+		stateFlag, ok := domainStatsStateFlags[state.(string)]
+		if !ok {
+			return fmt.Errorf("invalid state '%s', must be one of 'running', 'paused', 'shutoff' or 'other'", state)
+		}
+		flags |= stateFlag
+	}
+
+	records, err := virConn.ConnectGetAllDomainStats(nil, uint32(domainStatsTypes), uint32(flags))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve domain statistics: %w", err)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Dom.Name < records[j].Dom.Name })
+
+	name := d.Get("name").(string)
+	filter := d.Get("tags").(map[string]interface{})
+
+	domains := make([]map[string]interface{}, 0, len(records))
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		if name != "" && record.Dom.Name != name {
+			continue
+		}
+		if len(filter) > 0 {
+			tags, err := getDomainTags(virConn, record.Dom)
+			if err != nil {
+				return fmt.Errorf("domain %s: %w", record.Dom.Name, err)
+			}
+			if !tagsMatch(tags, filter) {
+				continue
+			}
+		}
+
+		stats := flattenDomainStats(record.Params)
+		stats["id"] = uuidString(record.Dom.UUID)
+		stats["name"] = record.Dom.Name
+		domains = append(domains, stats)
+		ids = append(ids, stats["id"].(string))
+	}
+
+	if err := d.Set("domains", domains); err != nil {
+		return fmt.Errorf("failed to set domain statistics: %w", err)
+	}
+	d.SetId(strconv.Itoa(hashcode.String(fmt.Sprintf("%v", ids))))
+
+	return nil
+}
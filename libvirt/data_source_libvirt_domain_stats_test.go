@@ -0,0 +1,45 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func TestAccLibvirtDomainStatsDataSource(t *testing.T) {
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	dataSourceName := "data.libvirt_domain_stats." + randomDomainName
+
+	config := fmt.Sprintf(`
+	resource "libvirt_domain" "%[1]s" {
+		name   = "%[1]s"
+		memory = 512
+		vcpu   = 2
+	}
+
+	data "libvirt_domain_stats" "%[1]s" {
+		name       = libvirt_domain.%[1]s.name
+		state      = "running"
+		depends_on = [libvirt_domain.%[1]s]
+	}`, randomDomainName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(dataSourceName, "domains.#", "1"),
+					resource.TestCheckResourceAttr(dataSourceName, "domains.0.name", randomDomainName),
+					resource.TestCheckResourceAttr(dataSourceName, "domains.0.state", "running"),
+					resource.TestCheckResourceAttr(dataSourceName, "domains.0.vcpu_maximum", "2"),
+					resource.TestCheckResourceAttr(dataSourceName, "domains.0.vcpus.#", "2"),
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/hashcode"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a libvirt domains datasource, to find domains by their tags
+//
+// Datasource example:
+//
+//	data "libvirt_domains" "team" {
+//	  tags = {
+//	    owner = "alice"
+//	  }
+//	}
+//
+//	output "names" {
+//	  value = data.libvirt_domains.team.names
+//	}
+func datasourceLibvirtDomains() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtDomainsRead,
+		Schema: map[string]*schema.Schema{
+			"tags": tagsSchema(),
+			"ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"names": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func resourceLibvirtDomainsRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Read data source libvirt_domains")
+
+	virConn := meta.(*Client).libvirt
+
+	filter := d.Get("tags").(map[string]interface{})
+
+	domains, _, err := virConn.ConnectListAllDomains(1, 0)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve list of domains: %w", err)
+	}
+
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Name < domains[j].Name })
+
+	ids := []string{}
+	names := []string{}
+	for _, domain := range domains {
+		tags, err := getDomainTags(virConn, domain)
+		if err != nil {
+			return fmt.Errorf("domain %s: %w", domain.Name, err)
+		}
+		if !tagsMatch(tags, filter) {
+			continue
+		}
+		ids = append(ids, uuidString(domain.UUID))
+		names = append(names, domain.Name)
+	}
+
+	d.Set("ids", ids)
+	d.Set("names", names)
+	d.SetId(strconv.Itoa(hashcode.String(fmt.Sprintf("%v", ids))))
+
+	return nil
+}
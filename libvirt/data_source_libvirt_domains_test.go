@@ -0,0 +1,59 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func testAccDataSourceDomainsConfig(name, owner string) string {
+	return fmt.Sprintf(`
+	resource "libvirt_domain" "%[1]s" {
+		name = "%[1]s"
+		tags = {
+			owner       = "%[2]s"
+			cost-center = "%[1]s"
+		}
+	}
+
+	data "libvirt_domains" "%[1]s" {
+		tags = {
+			cost-center = "%[1]s"
+			owner       = "alice"
+		}
+		depends_on = [libvirt_domain.%[1]s]
+	}`, name, owner)
+}
+
+func TestAccLibvirtDomainsDataSource_Tags(t *testing.T) {
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	resourceName := "libvirt_domain." + randomDomainName
+	dataSourceName := "data.libvirt_domains." + randomDomainName
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourceDomainsConfig(randomDomainName, "alice"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "tags.owner", "alice"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.#", "1"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.0", randomDomainName),
+					resource.TestCheckResourceAttrPair(dataSourceName, "ids.0", resourceName, "id"),
+				),
+			},
+			{
+				// the tags change without recreating the domain
+				Config: testAccDataSourceDomainsConfig(randomDomainName, "bob"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "tags.owner", "bob"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.#", "0"),
+				),
+			},
+		},
+	})
+}
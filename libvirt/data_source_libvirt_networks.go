@@ -0,0 +1,82 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/dmacvicar/terraform-provider-libvirt/libvirt/helper/hashcode"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// a libvirt networks datasource, to find networks by their tags
+//
+// Datasource example:
+//
+//	data "libvirt_networks" "lab" {
+//	  tags = {
+//	    environment = "lab"
+//	  }
+//	}
+//
+//	output "names" {
+//	  value = data.libvirt_networks.lab.names
+//	}
+func datasourceLibvirtNetworks() *schema.Resource {
+	return &schema.Resource{
+		Read: resourceLibvirtNetworksRead,
+		Schema: map[string]*schema.Schema{
+			"tags": tagsSchema(),
+			"ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"names": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func resourceLibvirtNetworksRead(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[DEBUG] Read data source libvirt_networks")
+
+	virConn := meta.(*Client).libvirt
+
+	filter := d.Get("tags").(map[string]interface{})
+
+	networks, _, err := virConn.ConnectListAllNetworks(1, 0)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve list of networks: %w", err)
+	}
+
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+
+	ids := []string{}
+	names := []string{}
+	for _, network := range networks {
+		tags, err := getNetworkTags(virConn, network)
+		if err != nil {
+			return fmt.Errorf("network %s: %w", network.Name, err)
+		}
+		if !tagsMatch(tags, filter) {
+			continue
+		}
+		ids = append(ids, uuidString(network.UUID))
+		names = append(names, network.Name)
+	}
+
+	d.Set("ids", ids)
+	d.Set("names", names)
+	d.SetId(strconv.Itoa(hashcode.String(fmt.Sprintf("%v", ids))))
+
+	return nil
+}
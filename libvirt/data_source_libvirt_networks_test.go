@@ -0,0 +1,63 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+func testAccDataSourceNetworksConfig(name, owner string) string {
+	return fmt.Sprintf(`
+	resource "libvirt_network" "%[1]s" {
+		name      = "%[1]s"
+		mode      = "none"
+		addresses = ["10.17.7.0/24"]
+		tags = {
+			owner       = "%[2]s"
+			cost-center = "%[1]s"
+		}
+	}
+
+	data "libvirt_networks" "%[1]s" {
+		tags = {
+			cost-center = "%[1]s"
+			owner       = "alice"
+		}
+		depends_on = [libvirt_network.%[1]s]
+	}`, name, owner)
+}
+
+func TestAccLibvirtNetworksDataSource_Tags(t *testing.T) {
+	skipIfPrivilegedDisabled(t)
+
+	randomNetworkName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	resourceName := "libvirt_network." + randomNetworkName
+	dataSourceName := "data.libvirt_networks." + randomNetworkName
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtNetworkDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSourceNetworksConfig(randomNetworkName, "alice"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "tags.owner", "alice"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.#", "1"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.0", randomNetworkName),
+					resource.TestCheckResourceAttrPair(dataSourceName, "ids.0", resourceName, "id"),
+				),
+			},
+			{
+				// the tags change without recreating the network
+				Config: testAccDataSourceNetworksConfig(randomNetworkName, "bob"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr(resourceName, "tags.owner", "bob"),
+					resource.TestCheckResourceAttr(dataSourceName, "names.#", "0"),
+				),
+			},
+		},
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"libvirt.org/go/libvirtxml"
@@ -65,19 +66,16 @@ func domainLeaseStateRefreshFunc(_ context.Context,
 }
 
 func waitForStateDomainLeaseDone(ctx context.Context,
-	virConn *libvirt.Libvirt, domain libvirt.Domain,
+	client *Client, domain libvirt.Domain,
 	waitForLeases []*libvirtxml.DomainInterface, rd *schema.ResourceData,
 ) error {
-	stateConf := &retry.StateChangeConf{
-		Pending:    []string{domainStateConfLeaseWaiting},
-		Target:     []string{domainStateConfLeaseDone},
-		Refresh:    domainLeaseStateRefreshFunc(ctx, virConn, domain, waitForLeases, rd),
-		Timeout:    rd.Timeout(schema.TimeoutCreate),
-		MinTimeout: resourceStateMinTimeout,
-		Delay:      resourceStateDelay,
-	}
+	// libvirt has no events for DHCP leases, but the domain starting or its
+	// guest agent connecting are good hints to look for addresses again
+	events, stopWatching := client.domainEvents.watch(domain)
+	defer stopWatching()
 
-	if _, err := stateConf.WaitForStateContext(ctx); err != nil {
+	refresh := domainLeaseStateRefreshFunc(ctx, client.libvirt, domain, waitForLeases, rd)
+	if err := waitForDomainEvents(ctx, rd.Timeout(schema.TimeoutCreate), events, refresh, domainStateConfLeaseDone); err != nil {
 		ipNotFoundMsg := "Please check following: \n" +
 			"1) is the domain running properly? \n" +
 			"2) has the network interface an IP address? \n" +
@@ -106,7 +104,7 @@ func domainIfaceHasAddress(virConn *libvirt.Libvirt, domain libvirt.Domain,
 	}
 
 	log.Printf("[DEBUG] waiting for network address for iface=%s\n", mac)
-	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, rd)
+	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, rd, []string{mac})
 	if err != nil {
 		if strings.Contains(err.Error(), "Guest agent is not responding: QEMU guest agent is not connected") {
 			log.Print("[DEBUG] could not retrieve interface addresses: domain qemu guest agent is not yet ready")
@@ -117,7 +115,8 @@ func domainIfaceHasAddress(virConn *libvirt.Libvirt, domain libvirt.Domain,
 	log.Printf("[DEBUG] ifaces with addresses: %+v\n", ifacesWithAddr)
 
 	for _, ifaceWithAddr := range ifacesWithAddr {
-		if len(ifaceWithAddr.Hwaddr) > 0 && (mac == strings.ToUpper(ifaceWithAddr.Hwaddr[0])) && len(ifaceWithAddr.Addrs) > 0 {
+		if len(ifaceWithAddr.Hwaddr) > 0 && (mac == strings.ToUpper(ifaceWithAddr.Hwaddr[0])) &&
+			addressesMatchFamily(ifaceWithAddr.Addrs, rd.Get("wait_for_address_family").(string)) {
 			log.Printf("[DEBUG] found IPs for MAC=%+v: %+v\n", mac, ifaceWithAddr.Addrs)
 			return true, false, nil
 		}
@@ -168,7 +167,12 @@ func domainIsRunning(virConn *libvirt.Libvirt, domain libvirt.Domain) (bool, err
 	return libvirt.DomainState(state) == libvirt.DomainRunning, nil
 }
 
-func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *schema.ResourceData) ([]libvirt.DomainInterface, error) {
+// domainGetIfacesInfo looks up the interface addresses from the configured sources in turn.
+// A failing source is skipped, and the remaining ones are not queried once all the given
+// MAC addresses have an address.
+func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *schema.ResourceData,
+	macs []string,
+) ([]libvirt.DomainInterface, error) {
 	domainRunningNow, err := domainIsRunning(virConn, domain)
 	if err != nil {
 		return []libvirt.DomainInterface{}, err
@@ -178,26 +182,127 @@ func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *sc
 		return []libvirt.DomainInterface{}, nil
 	}
 
-	// setup source of interface address information
-	var addrsrc uint32
-	qemuAgentEnabled := rd.Get("qemu_agent").(bool)
-	if qemuAgentEnabled {
-		addrsrc = uint32(libvirt.DomainInterfaceAddressesSrcAgent)
+	sources, err := domainAddressSources(rd)
+	if err != nil {
+		return []libvirt.DomainInterface{}, err
+	}
+
+	excludedRanges, err := domainExcludedAddressRanges(rd)
+	if err != nil {
+		return []libvirt.DomainInterface{}, err
+	}
+
+	// try the sources in turn, the first one knowing addresses for a MAC wins
+	var interfaces []libvirt.DomainInterface
+	var lastErr error
+	failed := 0
+	for _, addrsrc := range sources {
+		sourceInterfaces, err := domainGetIfacesInfoFromSource(virConn, domain, addrsrc)
+		if err != nil {
+			log.Printf("[WARN] %s, trying the next address source", err)
+			lastErr = err
+			failed++
+			continue
+		}
+		sourceInterfaces = filterInterfaceAddresses(sourceInterfaces, excludedRanges)
+		interfaces = mergeInterfaceAddresses(interfaces, sourceInterfaces)
+
+		if interfacesHaveAddresses(interfaces, macs) {
+			break
+		}
+	}
+
+	if failed == len(sources) && lastErr != nil {
+		return interfaces, lastErr
+	}
+	return interfaces, nil
+}
+
+// interfacesHaveAddresses tells whether every one of the MAC addresses has an address.
+func interfacesHaveAddresses(interfaces []libvirt.DomainInterface, macs []string) bool {
+	for _, mac := range macs {
+		found := false
+		for _, iface := range interfaces {
+			if len(iface.Hwaddr) > 0 && strings.EqualFold(iface.Hwaddr[0], mac) && len(iface.Addrs) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+var domainAddressSourceNames = map[string]libvirt.DomainInterfaceAddressesSource{
+	"lease": libvirt.DomainInterfaceAddressesSrcLease,
+	"agent": libvirt.DomainInterfaceAddressesSrcAgent,
+	"arp":   libvirt.DomainInterfaceAddressesSrcArp,
+}
+
+// domainAddressSources returns the sources to look up interface addresses from. When not
+// configured, the guest agent is used if enabled, otherwise the DHCP leases.
+func domainAddressSources(rd *schema.ResourceData) ([]uint32, error) {
+	names := []string{"lease"}
+	if rd.Get("qemu_agent").(bool) {
+		names = []string{"agent"}
+	}
+	if configured, ok := rd.GetOk("address_sources"); ok {
+		names = nil
+		for _, name := range configured.([]interface{}) {
+			names = append(names, name.(string))
+		}
+	}
+
+	sources := make([]uint32, 0, len(names))
+	for _, name := range names {
+		source, ok := domainAddressSourceNames[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid address source '%s', must be 'lease', 'agent' or 'arp'", name)
+		}
+		sources = append(sources, uint32(source))
+	}
+	return sources, nil
+}
+
+func domainExcludedAddressRanges(rd *schema.ResourceData) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	if rd.Get("exclude_link_local_addresses").(bool) {
+		for _, cidr := range []string{"169.254.0.0/16", "fe80::/10"} {
+			_, ipNet, _ := net.ParseCIDR(cidr)
+			ranges = append(ranges, ipNet)
+		}
+	}
+	for _, cidr := range rd.Get("exclude_address_ranges").([]interface{}) {
+		_, ipNet, err := net.ParseCIDR(cidr.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range in exclude_address_ranges: %w", err)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+func domainGetIfacesInfoFromSource(virConn *libvirt.Libvirt, domain libvirt.Domain, addrsrc uint32) ([]libvirt.DomainInterface, error) {
+	switch addrsrc {
+	case uint32(libvirt.DomainInterfaceAddressesSrcAgent):
 		log.Printf("[DEBUG] qemu-agent used to query interface info")
-	} else {
-		addrsrc = uint32(libvirt.DomainInterfaceAddressesSrcLease)
+	case uint32(libvirt.DomainInterfaceAddressesSrcArp):
+		log.Printf("[DEBUG] Obtain interface info from the host ARP table")
+	default:
 		log.Printf("[DEBUG] Obtain interface info from dhcp lease file")
 	}
 
 	// get all the interfaces attached to libvirt networks
-	var interfaces []libvirt.DomainInterface
-	interfaces, err = virConn.DomainInterfaceAddresses(domain, addrsrc, 0)
+	interfaces, err := virConn.DomainInterfaceAddresses(domain, addrsrc, 0)
 	if err != nil {
 		var virErr libvirt.Error
 		if errors.As(err, &virErr) {
 			// Agent can be unresponsive if being installed/setup
 			if addrsrc == uint32(libvirt.DomainInterfaceAddressesSrcLease) && virErr.Code != uint32(libvirt.ErrOperationInvalid) ||
-				addrsrc == uint32(libvirt.DomainInterfaceAddressesSrcAgent) && virErr.Code != uint32(libvirt.ErrAgentUnresponsive) {
+				addrsrc == uint32(libvirt.DomainInterfaceAddressesSrcAgent) && virErr.Code != uint32(libvirt.ErrAgentUnresponsive) ||
+				addrsrc == uint32(libvirt.DomainInterfaceAddressesSrcArp) {
 				return interfaces, fmt.Errorf("error retrieving interface addresses: %w", err)
 			}
 			// If it is ErrAgentUnresponsive, continue trying
@@ -208,6 +313,76 @@ func domainGetIfacesInfo(virConn *libvirt.Libvirt, domain libvirt.Domain, rd *sc
 	return interfaces, nil
 }
 
+// filterInterfaceAddresses drops the addresses within any of the excluded ranges.
+func filterInterfaceAddresses(interfaces []libvirt.DomainInterface, excluded []*net.IPNet) []libvirt.DomainInterface {
+	if len(excluded) == 0 {
+		return interfaces
+	}
+
+	filtered := make([]libvirt.DomainInterface, 0, len(interfaces))
+	for _, iface := range interfaces {
+		var addrs []libvirt.DomainIPAddr
+	addrLoop:
+		for _, addr := range iface.Addrs {
+			ip := net.ParseIP(addr.Addr)
+			for _, ipNet := range excluded {
+				if ip != nil && ipNet.Contains(ip) {
+					log.Printf("[DEBUG] ignoring address %s of interface %s", addr.Addr, iface.Name)
+					continue addrLoop
+				}
+			}
+			addrs = append(addrs, addr)
+		}
+		iface.Addrs = addrs
+		filtered = append(filtered, iface)
+	}
+	return filtered
+}
+
+// mergeInterfaceAddresses adds to interfaces the ones from more that have addresses for a
+// MAC address interfaces has none for.
+func mergeInterfaceAddresses(interfaces []libvirt.DomainInterface, more []libvirt.DomainInterface) []libvirt.DomainInterface {
+	known := make(map[string]bool)
+	for _, iface := range interfaces {
+		if len(iface.Hwaddr) > 0 && len(iface.Addrs) > 0 {
+			known[strings.ToUpper(iface.Hwaddr[0])] = true
+		}
+	}
+
+	for _, iface := range more {
+		if len(iface.Hwaddr) > 0 && known[strings.ToUpper(iface.Hwaddr[0])] {
+			continue
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+// addressesMatchFamily tells whether the addresses are enough for the wanted family:
+// "any", "ipv4", "ipv6" or "both".
+func addressesMatchFamily(addrs []libvirt.DomainIPAddr, family string) bool {
+	var hasIPv4, hasIPv6 bool
+	for _, addr := range addrs {
+		switch libvirt.IPAddrType(addr.Type) {
+		case libvirt.IPAddrTypeIpv4:
+			hasIPv4 = true
+		case libvirt.IPAddrTypeIpv6:
+			hasIPv6 = true
+		}
+	}
+
+	switch family {
+	case "ipv4":
+		return hasIPv4
+	case "ipv6":
+		return hasIPv6
+	case "both":
+		return hasIPv4 && hasIPv6
+	default:
+		return len(addrs) > 0
+	}
+}
+
 func newDiskForCloudInit(virConn *libvirt.Libvirt, volumeKey string) (libvirtxml.DomainDisk, error) {
 	disk := libvirtxml.DomainDisk{
 		// HACK mark the disk as belonging to the cloudinit
@@ -376,6 +551,15 @@ func setGraphics(d *schema.ResourceData, domainDef *libvirtxml.Domain, arch stri
 }
 
 func setCmdlineArgs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	if args, ok := d.GetOk("cmdline_args"); ok {
+		var cmdlineArgs []string
+		for _, arg := range args.([]interface{}) {
+			cmdlineArgs = append(cmdlineArgs, arg.(string))
+		}
+		domainDef.OS.Cmdline = strings.Join(cmdlineArgs, " ")
+		return
+	}
+
 	var cmdlineArgs []string
 	for i := 0; i < d.Get("cmdline.#").(int); i++ {
 		for k, v := range d.Get(fmt.Sprintf("cmdline.%d", i)).(map[string]interface{}) {
@@ -393,6 +577,66 @@ func setCmdlineArgs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	domainDef.OS.Cmdline = strings.Join(cmdlineArgs, " ")
 }
 
+// resolveBootFile returns the path of a file used to boot the domain, like the
+// kernel, given either as a path on the host or as the id of a volume.
+func resolveBootFile(virConn *libvirt.Libvirt, file string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+
+	volume, err := virConn.StorageVolLookupByKey(file)
+	if err != nil {
+		if isError(err, libvirt.ErrNoStorageVol) {
+			return file, nil
+		}
+		return "", fmt.Errorf("can't retrieve volume %s: %w", file, err)
+	}
+
+	path, err := virConn.StorageVolGetPath(volume)
+	if err != nil {
+		return "", fmt.Errorf("can't retrieve path of volume %s: %w", file, err)
+	}
+	return path, nil
+}
+
+// readBootFile returns the value to keep in the state for a boot file: the volume
+// id or path already there if it still resolves to the path in the definition,
+// the path otherwise.
+func readBootFile(virConn *libvirt.Libvirt, current string, path string) string {
+	if current == path {
+		return path
+	}
+	if resolved, err := resolveBootFile(virConn, current); err == nil && resolved == path {
+		return current
+	}
+	return path
+}
+
+func setBootFiles(d *schema.ResourceData, domainDef *libvirtxml.Domain, virConn *libvirt.Libvirt) error {
+	var err error
+	for key, file := range map[string]*string{
+		"kernel": &domainDef.OS.Kernel,
+		"initrd": &domainDef.OS.Initrd,
+		"dtb":    &domainDef.OS.DTB,
+	} {
+		if *file, err = resolveBootFile(virConn, d.Get(key).(string)); err != nil {
+			return err
+		}
+	}
+
+	if uki, ok := d.GetOk("uki"); ok {
+		// unified kernel images are EFI binaries bundling the kernel, initrd
+		// and command line, so they need the firmware to be EFI
+		if domainDef.OS.Kernel, err = resolveBootFile(virConn, uki.(string)); err != nil {
+			return err
+		}
+		if _, ok := d.GetOk("firmware"); !ok {
+			domainDef.OS.Firmware = "efi"
+		}
+	}
+	return nil
+}
+
 func setFirmware(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	if firmware, ok := d.GetOk("firmware"); ok {
 		firmwareFile := firmware.(string)
@@ -487,6 +731,15 @@ func setConsoles(d *schema.ResourceData, domainDef *libvirtxml.Domain) error {
 				}
 			}
 		}
+		if logFile, ok := d.GetOk(prefix + ".log_file"); ok {
+			console.Log = &libvirtxml.DomainChardevLog{
+				File: logFile.(string),
+			}
+			if d.Get(prefix + ".log_append").(bool) {
+				console.Log.Append = "on"
+			}
+		}
+
 		domainDef.Devices.Consoles = append(domainDef.Devices.Consoles, console)
 	}
 	return nil
@@ -535,18 +788,11 @@ func setDisks(d *schema.ResourceData, domainDef *libvirtxml.Domain, virConn *lib
 				return err
 			}
 			if volumeDef.Target != nil && volumeDef.Target.Format != nil && volumeDef.Target.Format.Type != "" {
-				if volumeDef.Target.Format.Type == "qcow2" {
-					log.Print("[DEBUG] Setting disk driver to 'qcow2' to match disk volume format")
+				if isLibvirtVolumeFormat(volumeDef.Target.Format.Type) {
+					log.Printf("[DEBUG] Setting disk driver to '%s' to match disk volume format", volumeDef.Target.Format.Type)
 					disk.Driver = &libvirtxml.DomainDiskDriver{
 						Name: "qemu",
-						Type: "qcow2",
-					}
-				}
-				if volumeDef.Target.Format.Type == "raw" {
-					log.Print("[DEBUG] Setting disk driver to 'raw' to match disk volume format")
-					disk.Driver = &libvirtxml.DomainDiskDriver{
-						Name: "qemu",
-						Type: "raw",
+						Type: volumeDef.Target.Format.Type,
 					}
 				}
 			} else {
@@ -891,6 +1137,496 @@ func setTPMs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
 	}
 }
 
+func setLifecycleActions(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	if onPoweroff, ok := d.GetOk("on_poweroff"); ok {
+		domainDef.OnPoweroff = onPoweroff.(string)
+	}
+	if onReboot, ok := d.GetOk("on_reboot"); ok {
+		domainDef.OnReboot = onReboot.(string)
+	}
+	if onCrash, ok := d.GetOk("on_crash"); ok {
+		domainDef.OnCrash = onCrash.(string)
+	}
+}
+
+func setRNG(d *schema.ResourceData, domainDef *libvirtxml.Domain) error {
+	prefix := "rng.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return nil
+	}
+
+	rng := libvirtxml.DomainRNG{
+		Model:   d.Get(prefix + ".model").(string),
+		Backend: &libvirtxml.DomainRNGBackend{},
+	}
+
+	switch backend := d.Get(prefix + ".backend").(string); backend {
+	case "random":
+		device := d.Get(prefix + ".device").(string)
+		if device == "" {
+			device = defaultRNGDevice()
+		}
+		rng.Backend.Random = &libvirtxml.DomainRNGBackendRandom{Device: device}
+	case "builtin":
+		rng.Backend.BuiltIn = &libvirtxml.DomainRNGBackendBuiltIn{}
+	default:
+		return fmt.Errorf("invalid rng backend '%s', must be 'random' or 'builtin'", backend)
+	}
+
+	if rateBytes, ok := d.GetOk(prefix + ".rate_bytes"); ok {
+		rng.Rate = &libvirtxml.DomainRNGRate{
+			Bytes:  uint(rateBytes.(int)),
+			Period: uint(d.Get(prefix + ".rate_period").(int)),
+		}
+	}
+
+	domainDef.Devices.RNGs = []libvirtxml.DomainRNG{rng}
+	return nil
+}
+
+func setWatchdog(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	prefix := "watchdog.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return
+	}
+
+	domainDef.Devices.Watchdogs = []libvirtxml.DomainWatchdog{
+		{
+			Model:  d.Get(prefix + ".model").(string),
+			Action: d.Get(prefix + ".action").(string),
+		},
+	}
+}
+
+func setMemBalloon(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	prefix := "memballoon.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return
+	}
+
+	domainDef.Devices.MemBalloon = &libvirtxml.DomainMemBalloon{
+		Model: d.Get(prefix + ".model").(string),
+	}
+	if period, ok := d.GetOk(prefix + ".stats_period"); ok {
+		domainDef.Devices.MemBalloon.Stats = &libvirtxml.DomainMemBalloonStats{
+			Period: uint(period.(int)),
+		}
+	}
+}
+
+func setVSock(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	if _, ok := d.GetOk("vsock"); !ok {
+		return
+	}
+
+	// an empty block still defines the device, with an automatically assigned CID
+	domainDef.Devices.VSock = &libvirtxml.DomainVSock{
+		Model: "virtio",
+		CID:   &libvirtxml.DomainVSockCID{Auto: "yes"},
+	}
+	if cid, ok := d.GetOk("vsock.0.cid"); ok {
+		domainDef.Devices.VSock.CID = &libvirtxml.DomainVSockCID{
+			Auto:    "no",
+			Address: strconv.Itoa(cid.(int)),
+		}
+	}
+}
+
+func setInputs(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	for i := 0; i < d.Get("input.#").(int); i++ {
+		prefix := fmt.Sprintf("input.%d", i)
+		domainDef.Devices.Inputs = append(domainDef.Devices.Inputs, libvirtxml.DomainInput{
+			Type: d.Get(prefix + ".type").(string),
+			Bus:  d.Get(prefix + ".bus").(string),
+		})
+	}
+}
+
+func setSounds(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	for i := 0; i < d.Get("sound.#").(int); i++ {
+		domainDef.Devices.Sounds = append(domainDef.Devices.Sounds, libvirtxml.DomainSound{
+			Model: d.Get(fmt.Sprintf("sound.%d.model", i)).(string),
+		})
+	}
+}
+
+// setQEMUCommandline adds the arguments, environment variables and capabilities of the
+// qemu_commandline block, after the ones needed by the other settings, like the ignition
+// fw_cfg arguments.
+func setQEMUCommandline(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	prefix := "qemu_commandline.0"
+	if _, ok := d.GetOk("qemu_commandline"); !ok {
+		return
+	}
+
+	var args []libvirtxml.DomainQEMUCommandlineArg
+	for _, arg := range d.Get(prefix + ".args").([]interface{}) {
+		args = append(args, libvirtxml.DomainQEMUCommandlineArg{Value: arg.(string)})
+	}
+
+	var envs []libvirtxml.DomainQEMUCommandlineEnv
+	for i := 0; i < d.Get(prefix+".env.#").(int); i++ {
+		envPrefix := fmt.Sprintf("%s.env.%d", prefix, i)
+		envs = append(envs, libvirtxml.DomainQEMUCommandlineEnv{
+			Name:  d.Get(envPrefix + ".name").(string),
+			Value: d.Get(envPrefix + ".value").(string),
+		})
+	}
+
+	if len(args) > 0 || len(envs) > 0 {
+		if domainDef.QEMUCommandline == nil {
+			domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
+		}
+		domainDef.QEMUCommandline.Args = append(domainDef.QEMUCommandline.Args, args...)
+		domainDef.QEMUCommandline.Envs = append(domainDef.QEMUCommandline.Envs, envs...)
+	}
+
+	capabilities := &libvirtxml.DomainQEMUCapabilities{}
+	for _, capability := range d.Get(prefix + ".capabilities.0.add").([]interface{}) {
+		capabilities.Add = append(capabilities.Add, libvirtxml.DomainQEMUCapabilitiesEntry{Name: capability.(string)})
+	}
+	for _, capability := range d.Get(prefix + ".capabilities.0.del").([]interface{}) {
+		capabilities.Del = append(capabilities.Del, libvirtxml.DomainQEMUCapabilitiesEntry{Name: capability.(string)})
+	}
+	if len(capabilities.Add) > 0 || len(capabilities.Del) > 0 {
+		domainDef.QEMUCapabilities = capabilities
+	}
+}
+
+// qemuCommandlineWarnings warns that libvirt does not support domains using the
+// qemu_commandline block.
+func qemuCommandlineWarnings(d *schema.ResourceData) diag.Diagnostics {
+	if _, ok := d.GetOk("qemu_commandline"); !ok {
+		return nil
+	}
+	log.Printf("[WARN] domain %s uses QEMU command line passthrough, libvirt marks it as tainted", d.Get("name"))
+	return diag.Diagnostics{
+		{
+			Severity: diag.Warning,
+			Summary:  "Domain tainted by QEMU command line passthrough",
+			Detail: "The qemu_commandline block passes arguments, environment variables or capabilities " +
+				"straight to QEMU. libvirt marks such domains as tainted, does not know about the devices " +
+				"added this way and does not support them.",
+		},
+	}
+}
+
+// setChannels adds the user defined channels after the default guest agent one.
+func setChannels(d *schema.ResourceData, domainDef *libvirtxml.Domain) error {
+	for i := 0; i < d.Get("channel.#").(int); i++ {
+		prefix := fmt.Sprintf("channel.%d", i)
+		targetName := d.Get(prefix + ".target_name").(string)
+		channel := libvirtxml.DomainChannel{
+			Source: &libvirtxml.DomainChardevSource{},
+		}
+
+		switch channelType := d.Get(prefix + ".type").(string); channelType {
+		case "spicevmc":
+			channel.Source.SpiceVMC = &libvirtxml.DomainChardevSourceSpiceVMC{}
+			if targetName == "" {
+				targetName = "com.redhat.spice.0"
+			}
+		case "unix":
+			channel.Source.UNIX = &libvirtxml.DomainChardevSourceUNIX{
+				Path: d.Get(prefix + ".source_path").(string),
+			}
+			if channel.Source.UNIX.Path != "" {
+				channel.Source.UNIX.Mode = "bind"
+			}
+		case "pty":
+			channel.Source.Pty = &libvirtxml.DomainChardevSourcePty{}
+		default:
+			return fmt.Errorf("invalid channel type '%s', must be 'spicevmc', 'unix' or 'pty'", channelType)
+		}
+
+		if targetName == "" {
+			return fmt.Errorf("channel %d of type '%s' requires a target_name", i, d.Get(prefix+".type"))
+		}
+		channel.Target = &libvirtxml.DomainChannelTarget{
+			VirtIO: &libvirtxml.DomainChannelTargetVirtIO{Name: targetName},
+		}
+
+		domainDef.Devices.Channels = append(domainDef.Devices.Channels, channel)
+	}
+	return nil
+}
+
+func setClock(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	prefix := "clock.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return
+	}
+
+	domainDef.Clock = &libvirtxml.DomainClock{
+		Offset:     d.Get(prefix + ".offset").(string),
+		TimeZone:   d.Get(prefix + ".timezone").(string),
+		Adjustment: d.Get(prefix + ".adjustment").(string),
+	}
+
+	for i := 0; i < d.Get(prefix+".timer.#").(int); i++ {
+		timerPrefix := fmt.Sprintf("%s.timer.%d", prefix, i)
+		domainDef.Clock.Timer = append(domainDef.Clock.Timer, libvirtxml.DomainTimer{
+			Name:       d.Get(timerPrefix + ".name").(string),
+			TickPolicy: d.Get(timerPrefix + ".tickpolicy").(string),
+			Track:      d.Get(timerPrefix + ".track").(string),
+			Present:    formatBoolYesNo(d.Get(timerPrefix + ".present").(bool)),
+		})
+	}
+}
+
+// hypervFeatureStates maps the schema name of every on/off Hyper-V
+// enlightenment to the field holding it in the libvirt definition.
+func hypervFeatureStates(hyperv *libvirtxml.DomainFeatureHyperV) map[string]**libvirtxml.DomainFeatureState {
+	return map[string]**libvirtxml.DomainFeatureState{
+		"relaxed":         &hyperv.Relaxed,
+		"vapic":           &hyperv.VAPIC,
+		"vpindex":         &hyperv.VPIndex,
+		"runtime":         &hyperv.Runtime,
+		"synic":           &hyperv.Synic,
+		"reset":           &hyperv.Reset,
+		"frequencies":     &hyperv.Frequencies,
+		"reenlightenment": &hyperv.ReEnlightenment,
+		"tlbflush":        &hyperv.TLBFlush,
+		"ipi":             &hyperv.IPI,
+		"evmcs":           &hyperv.EVMCS,
+	}
+}
+
+func setFeatures(d *schema.ResourceData, domainDef *libvirtxml.Domain) {
+	prefix := "features.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return
+	}
+
+	features := &libvirtxml.DomainFeatureList{}
+	if d.Get(prefix + ".acpi").(bool) {
+		features.ACPI = &libvirtxml.DomainFeature{}
+	}
+	if d.Get(prefix + ".apic").(bool) {
+		features.APIC = &libvirtxml.DomainFeatureAPIC{}
+	}
+	if d.Get(prefix + ".pae").(bool) {
+		features.PAE = &libvirtxml.DomainFeature{}
+	}
+	if d.Get(prefix + ".smm").(bool) {
+		features.SMM = &libvirtxml.DomainFeatureSMM{State: "on"}
+	}
+	if vmport, ok := d.GetOk(prefix + ".vmport"); ok {
+		features.VMPort = &libvirtxml.DomainFeatureState{State: vmport.(string)}
+	}
+	if driver, ok := d.GetOk(prefix + ".ioapic"); ok {
+		features.IOAPIC = &libvirtxml.DomainFeatureIOAPIC{Driver: driver.(string)}
+	}
+
+	hypervPrefix := prefix + ".hyperv.0"
+	if d.Get(prefix+".hyperv.#").(int) > 0 {
+		hyperv := &libvirtxml.DomainFeatureHyperV{}
+		// the enlightenments explicitly set to false are turned off, instead of being
+		// left to the defaults of libvirt
+		for name, state := range hypervFeatureStates(hyperv) {
+			if enabled, ok := d.GetOkExists(hypervPrefix + "." + name); ok {
+				*state = &libvirtxml.DomainFeatureState{State: formatBoolOnOff(enabled.(bool))}
+			}
+		}
+		if enabled, ok := d.GetOkExists(hypervPrefix + ".stimer"); ok {
+			hyperv.STimer = &libvirtxml.DomainFeatureHyperVSTimer{
+				DomainFeatureState: libvirtxml.DomainFeatureState{State: formatBoolOnOff(enabled.(bool))},
+			}
+		}
+		if retries, ok := d.GetOk(hypervPrefix + ".spinlocks"); ok {
+			hyperv.Spinlocks = &libvirtxml.DomainFeatureHyperVSpinlocks{
+				DomainFeatureState: libvirtxml.DomainFeatureState{State: "on"},
+				Retries:            uint(retries.(int)),
+			}
+		}
+		if vendorID, ok := d.GetOk(hypervPrefix + ".vendor_id"); ok {
+			hyperv.VendorId = &libvirtxml.DomainFeatureHyperVVendorId{
+				DomainFeatureState: libvirtxml.DomainFeatureState{State: "on"},
+				Value:              vendorID.(string),
+			}
+		}
+		features.HyperV = hyperv
+	}
+
+	domainDef.Features = features
+}
+
+// readFeatures converts the features of a libvirt definition to the
+// representation used by the features block.
+func readFeatures(features *libvirtxml.DomainFeatureList) map[string]interface{} {
+	block := map[string]interface{}{
+		"acpi": features.ACPI != nil,
+		"apic": features.APIC != nil,
+		"pae":  features.PAE != nil,
+		"smm":  features.SMM != nil && features.SMM.State != "off",
+	}
+	if features.VMPort != nil {
+		block["vmport"] = features.VMPort.State
+	}
+	if features.IOAPIC != nil {
+		block["ioapic"] = features.IOAPIC.Driver
+	}
+
+	if features.HyperV != nil {
+		hyperv := map[string]interface{}{}
+		for name, state := range hypervFeatureStates(features.HyperV) {
+			hyperv[name] = *state != nil && (*state).State == "on"
+		}
+		hyperv["stimer"] = features.HyperV.STimer != nil && features.HyperV.STimer.State == "on"
+		if features.HyperV.Spinlocks != nil && features.HyperV.Spinlocks.State == "on" {
+			hyperv["spinlocks"] = features.HyperV.Spinlocks.Retries
+		}
+		if features.HyperV.VendorId != nil && features.HyperV.VendorId.State == "on" {
+			hyperv["vendor_id"] = features.HyperV.VendorId.Value
+		}
+		block["hyperv"] = []map[string]interface{}{hyperv}
+	}
+
+	return block
+}
+
+func readRNG(rngs []libvirtxml.DomainRNG) []map[string]interface{} {
+	if len(rngs) == 0 || rngs[0].Backend == nil {
+		return nil
+	}
+
+	rng := rngs[0]
+	block := map[string]interface{}{"model": rng.Model}
+	switch {
+	case rng.Backend.Random != nil:
+		block["backend"] = "random"
+		block["device"] = rng.Backend.Random.Device
+	case rng.Backend.BuiltIn != nil:
+		block["backend"] = "builtin"
+	default:
+		// backends the provider can't define, like egd
+		return nil
+	}
+	if rng.Rate != nil {
+		block["rate_bytes"] = int(rng.Rate.Bytes)
+		block["rate_period"] = int(rng.Rate.Period)
+	}
+	return []map[string]interface{}{block}
+}
+
+func readWatchdog(watchdogs []libvirtxml.DomainWatchdog) []map[string]interface{} {
+	if len(watchdogs) == 0 {
+		return nil
+	}
+	return []map[string]interface{}{
+		{
+			"model":  watchdogs[0].Model,
+			"action": watchdogs[0].Action,
+		},
+	}
+}
+
+func readMemBalloon(memBalloon *libvirtxml.DomainMemBalloon) []map[string]interface{} {
+	if memBalloon == nil {
+		return nil
+	}
+	block := map[string]interface{}{"model": memBalloon.Model}
+	if memBalloon.Stats != nil {
+		block["stats_period"] = int(memBalloon.Stats.Period)
+	}
+	return []map[string]interface{}{block}
+}
+
+func readVSock(vsock *libvirtxml.DomainVSock) []map[string]interface{} {
+	if vsock == nil || vsock.CID == nil {
+		return nil
+	}
+	block := map[string]interface{}{}
+	if cid, err := strconv.Atoi(vsock.CID.Address); err == nil {
+		block["cid"] = cid
+	}
+	return []map[string]interface{}{block}
+}
+
+// readInputs returns the input devices, without the ps2 mouse and keyboard libvirt
+// adds to x86 domains, unless they are in the configured ones.
+func readInputs(inputs []libvirtxml.DomainInput, configured []interface{}) []map[string]interface{} {
+	isConfigured := func(input libvirtxml.DomainInput) bool {
+		for _, c := range configured {
+			if block, ok := c.(map[string]interface{}); ok && block["type"] == input.Type && block["bus"] == input.Bus {
+				return true
+			}
+		}
+		return false
+	}
+
+	var blocks []map[string]interface{}
+	for _, input := range inputs {
+		implicit := input.Bus == "ps2" && (input.Type == "mouse" || input.Type == "keyboard")
+		if implicit && !isConfigured(input) {
+			continue
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type": input.Type,
+			"bus":  input.Bus,
+		})
+	}
+	return blocks
+}
+
+func readSounds(sounds []libvirtxml.DomainSound) []map[string]interface{} {
+	var blocks []map[string]interface{}
+	for _, sound := range sounds {
+		blocks = append(blocks, map[string]interface{}{"model": sound.Model})
+	}
+	return blocks
+}
+
+// readChannels returns the channels, without the guest agent one the provider adds
+// before them.
+func readChannels(channels []libvirtxml.DomainChannel) []map[string]interface{} {
+	var blocks []map[string]interface{}
+	agentSkipped := false
+	for _, channel := range channels {
+		if channel.Source == nil || channel.Target == nil || channel.Target.VirtIO == nil {
+			continue
+		}
+		targetName := channel.Target.VirtIO.Name
+		if !agentSkipped && targetName == "org.qemu.guest_agent.0" {
+			agentSkipped = true
+			continue
+		}
+
+		block := map[string]interface{}{"target_name": targetName}
+		switch {
+		case channel.Source.SpiceVMC != nil:
+			block["type"] = "spicevmc"
+		case channel.Source.UNIX != nil:
+			block["type"] = "unix"
+			block["source_path"] = channel.Source.UNIX.Path
+		case channel.Source.Pty != nil:
+			block["type"] = "pty"
+		default:
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// undefineDomain removes the definition of the domain, together with its NVRAM,
+// managed save image, snapshots and checkpoints metadata.
+func undefineDomain(virConn *libvirt.Libvirt, domain libvirt.Domain) error {
+	if err := virConn.DomainUndefineFlags(domain, libvirt.DomainUndefineNvram|
+		libvirt.DomainUndefineSnapshotsMetadata|libvirt.DomainUndefineManagedSave|
+		libvirt.DomainUndefineCheckpointsMetadata); err != nil {
+		if isError(err, libvirt.ErrNoSupport) || isError(err, libvirt.ErrInvalidArg) {
+			log.Printf("libvirt does not support undefine flags: will try again without flags")
+			if err := virConn.DomainUndefine(domain); err != nil {
+				return fmt.Errorf("couldn't undefine libvirt domain: %w", err)
+			}
+		} else {
+			return fmt.Errorf("couldn't undefine libvirt domain with flags: %w", err)
+		}
+	}
+	return nil
+}
+
 func destroyDomainByUserRequest(virConn *libvirt.Libvirt, d *schema.ResourceData, domain libvirt.Domain) error {
 	if d.Get("running").(bool) {
 		return nil
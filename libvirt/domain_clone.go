@@ -0,0 +1,484 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func cloneFromSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"domain_id": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: true,
+				},
+				"pool": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"full_copy": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+					ForceNew: true,
+				},
+				"volume_ids": {
+					Type:     schema.TypeList,
+					Computed: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				"disk_targets": {
+					Type:     schema.TypeList,
+					Computed: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				"macs": {
+					Type:     schema.TypeList,
+					Computed: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+			},
+		},
+	}
+}
+
+// isSetInConfig tells whether the attribute or block is present in the configuration,
+// even if it has the value of its default.
+func isSetInConfig(d *schema.ResourceData, key string) bool {
+	config := d.GetRawConfig()
+	if config.IsNull() || !config.IsKnown() {
+		return false
+	}
+	value := config.GetAttr(key)
+	switch {
+	case value.IsNull():
+		return false
+	case !value.IsKnown():
+		return true
+	case value.Type().IsListType() || value.Type().IsSetType() || value.Type().IsTupleType():
+		return value.LengthInt() > 0
+	}
+	return true
+}
+
+// the settings of the source domain replaced by the ones of the resource when any of
+// the keys is set. The ones read back from libvirt without being computed, like memory,
+// are always taken from the resource.
+var domainCloneOverrides = []struct {
+	keys  []string
+	apply func(clone, generated *libvirtxml.Domain)
+}{
+	{[]string{"type"}, func(c, g *libvirtxml.Domain) { c.Type = g.Type }},
+	{[]string{"arch", "machine"}, func(c, g *libvirtxml.Domain) { c.OS.Type = g.OS.Type }},
+	{[]string{"cpu"}, func(c, g *libvirtxml.Domain) { c.CPU = g.CPU }},
+	{[]string{"emulator"}, func(c, g *libvirtxml.Domain) { c.Devices.Emulator = g.Devices.Emulator }},
+	{[]string{"clock"}, func(c, g *libvirtxml.Domain) { c.Clock = g.Clock }},
+	{[]string{"features"}, func(c, g *libvirtxml.Domain) { c.Features = g.Features }},
+	{[]string{"on_poweroff"}, func(c, g *libvirtxml.Domain) { c.OnPoweroff = g.OnPoweroff }},
+	{[]string{"on_reboot"}, func(c, g *libvirtxml.Domain) { c.OnReboot = g.OnReboot }},
+	{[]string{"on_crash"}, func(c, g *libvirtxml.Domain) { c.OnCrash = g.OnCrash }},
+	{[]string{"boot_device"}, func(c, g *libvirtxml.Domain) { c.OS.BootDevices = g.OS.BootDevices }},
+	{[]string{"coreos_ignition", "qemu_commandline"}, func(c, g *libvirtxml.Domain) { c.QEMUCommandline = g.QEMUCommandline }},
+	{[]string{"qemu_commandline"}, func(c, g *libvirtxml.Domain) { c.QEMUCapabilities = g.QEMUCapabilities }},
+	{[]string{"graphics"}, func(c, g *libvirtxml.Domain) { c.Devices.Graphics = g.Devices.Graphics }},
+	{[]string{"video"}, func(c, g *libvirtxml.Domain) { c.Devices.Videos = g.Devices.Videos }},
+	{[]string{"console"}, func(c, g *libvirtxml.Domain) {
+		c.Devices.Consoles = g.Devices.Consoles
+		c.Devices.Serials = g.Devices.Serials
+	}},
+	{[]string{"tpm"}, func(c, g *libvirtxml.Domain) { c.Devices.TPMs = g.Devices.TPMs }},
+	{[]string{"rng"}, func(c, g *libvirtxml.Domain) { c.Devices.RNGs = g.Devices.RNGs }},
+	{[]string{"watchdog"}, func(c, g *libvirtxml.Domain) { c.Devices.Watchdogs = g.Devices.Watchdogs }},
+	{[]string{"memballoon"}, func(c, g *libvirtxml.Domain) { c.Devices.MemBalloon = g.Devices.MemBalloon }},
+	{[]string{"vsock"}, func(c, g *libvirtxml.Domain) { c.Devices.VSock = g.Devices.VSock }},
+	{[]string{"input"}, func(c, g *libvirtxml.Domain) { c.Devices.Inputs = g.Devices.Inputs }},
+	{[]string{"sound"}, func(c, g *libvirtxml.Domain) { c.Devices.Sounds = g.Devices.Sounds }},
+	{[]string{"channel", "qemu_agent"}, func(c, g *libvirtxml.Domain) { c.Devices.Channels = g.Devices.Channels }},
+}
+
+// cloneDomainDef merges the definition of the source domain with the one generated
+// from the resource.
+func cloneDomainDef(d *schema.ResourceData, source, generated libvirtxml.Domain) (libvirtxml.Domain, error) {
+	clone := source
+	clone.UUID = ""
+	clone.ID = nil
+
+	clone.Name = generated.Name
+	clone.Description = generated.Description
+	clone.Memory = generated.Memory
+	clone.CurrentMemory = nil
+	clone.VCPU = generated.VCPU
+
+	// the overrides must not change the source definition
+	clone.OS = &libvirtxml.DomainOS{}
+	if source.OS != nil {
+		*clone.OS = *source.OS
+	}
+	clone.OS.Kernel = generated.OS.Kernel
+	clone.OS.Initrd = generated.OS.Initrd
+	clone.OS.Cmdline = generated.OS.Cmdline
+	clone.OS.DTB = generated.OS.DTB
+	if generated.OS.Firmware != "" || generated.OS.Loader != nil {
+		clone.OS.Firmware = generated.OS.Firmware
+		clone.OS.Loader = generated.OS.Loader
+		clone.OS.NVRam = generated.OS.NVRam
+	} else if source.OS != nil && source.OS.NVRam != nil {
+		// the firmware of the source is kept, but the clone gets its own variables,
+		// created by libvirt from the template or the variables of the source
+		nvram := *source.OS.NVRam
+		if nvram.Template == "" {
+			nvram.Template = nvram.NVRam
+		}
+		nvram.NVRam = ""
+		nvram.Source = nil
+		clone.OS.NVRam = &nvram
+	}
+
+	clone.Devices = &libvirtxml.DomainDeviceList{}
+	if source.Devices != nil {
+		*clone.Devices = *source.Devices
+	}
+	clone.Devices.Filesystems = generated.Devices.Filesystems
+	clone.Devices.Controllers = mergeControllers(clone.Devices.Controllers, generated.Devices.Controllers)
+
+	// the tags of the source domain are not copied, but the metadata of other applications is
+	var metadataXML string
+	if source.Metadata != nil {
+		metadataXML = source.Metadata.XML
+	}
+	metadataXML, err := setTagsMetadata(metadataXML, d.Get("tags").(map[string]interface{}))
+	if err != nil {
+		return clone, err
+	}
+	clone.Metadata = &libvirtxml.DomainMetadata{XML: metadataXML}
+
+	for _, override := range domainCloneOverrides {
+		for _, key := range override.keys {
+			if isSetInConfig(d, key) {
+				override.apply(&clone, &generated)
+				break
+			}
+		}
+	}
+
+	return clone, nil
+}
+
+// mergeControllers adds the generated controllers to the ones of the source domain,
+// except the ones with the type and index of a controller of the source.
+func mergeControllers(source, generated []libvirtxml.DomainController) []libvirtxml.DomainController {
+	controllerKey := func(controller libvirtxml.DomainController) string {
+		if controller.Index == nil {
+			return controller.Type
+		}
+		return fmt.Sprintf("%s/%d", controller.Type, *controller.Index)
+	}
+
+	merged := append([]libvirtxml.DomainController{}, source...)
+	present := make(map[string]bool)
+	for _, controller := range source {
+		present[controllerKey(controller)] = true
+	}
+	for _, controller := range generated {
+		if present[controllerKey(controller)] {
+			log.Printf("[DEBUG] not adding controller %s, the source domain has it", controllerKey(controller))
+			continue
+		}
+		present[controllerKey(controller)] = true
+		merged = append(merged, controller)
+	}
+	return merged
+}
+
+// diskTargetPrefix returns the bus prefix of a disk target device, like vd for vda,
+// or an empty string if it has none.
+func diskTargetPrefix(dev string) string {
+	for _, prefix := range []string{"xvd", "vd", "sd", "hd", "ubd"} {
+		if strings.HasPrefix(dev, prefix) && len(dev) > len(prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// renameConflictingDiskTargets gives the disks generated from the resource a target
+// device not used by the disks cloned from the source domain.
+func renameConflictingDiskTargets(clonedDisks []libvirtxml.DomainDisk, disks []libvirtxml.DomainDisk) {
+	used := make(map[string]bool)
+	for _, disk := range clonedDisks {
+		if disk.Target != nil {
+			used[disk.Target.Dev] = true
+		}
+	}
+
+	for i := range disks {
+		target := disks[i].Target
+		if target == nil || !used[target.Dev] {
+			if target != nil {
+				used[target.Dev] = true
+			}
+			continue
+		}
+		prefix := diskTargetPrefix(target.Dev)
+		if prefix == "" {
+			log.Printf("[WARN] disk target %s is used by the source domain, and can't be renamed", target.Dev)
+			continue
+		}
+		for j := 0; ; j++ {
+			dev := prefix + diskLetterForIndex(j)
+			if !used[dev] {
+				log.Printf("[DEBUG] renaming disk target %s to %s, it is used by the source domain", target.Dev, dev)
+				target.Dev = dev
+				used[dev] = true
+				break
+			}
+		}
+	}
+}
+
+// cloneDiskVolume creates a copy of the volume, or a volume backed by it, in the pool.
+func cloneDiskVolume(virConn *libvirt.Libvirt, pool libvirt.StoragePool, sourceVolume libvirt.StorageVol, name string, fullCopy bool) (libvirt.StorageVol, string, error) {
+	sourceDef, err := newDefVolumeFromLibvirt(virConn, sourceVolume)
+	if err != nil {
+		return libvirt.StorageVol{}, "", err
+	}
+
+	volumeDef := newDefVolume()
+	volumeDef.Name = name
+	volumeDef.Capacity = sourceDef.Capacity
+
+	var volume libvirt.StorageVol
+	if fullCopy {
+		if sourceDef.Target != nil && sourceDef.Target.Format != nil {
+			volumeDef.Target.Format.Type = sourceDef.Target.Format.Type
+		}
+		data, err := xmlMarshallIndented(volumeDef)
+		if err != nil {
+			return volume, "", fmt.Errorf("error serializing libvirt volume: %w", err)
+		}
+		log.Printf("[DEBUG] copying volume %s to:\n%s", sourceVolume.Name, data)
+		volume, err = virConn.StorageVolCreateXMLFrom(pool, data, sourceVolume, 0)
+		if err != nil {
+			return volume, "", fmt.Errorf("error copying volume %s: %w", sourceVolume.Name, err)
+		}
+	} else {
+		backingStoreDef, err := newDefBackingStoreFromLibvirt(virConn, sourceVolume)
+		if err != nil {
+			return volume, "", err
+		}
+		volumeDef.BackingStore = &backingStoreDef
+		data, err := xmlMarshallIndented(volumeDef)
+		if err != nil {
+			return volume, "", fmt.Errorf("error serializing libvirt volume: %w", err)
+		}
+		log.Printf("[DEBUG] creating volume backed by %s:\n%s", sourceVolume.Name, data)
+		volume, err = virConn.StorageVolCreateXML(pool, data, 0)
+		if err != nil {
+			return volume, "", fmt.Errorf("error creating volume backed by %s: %w", sourceVolume.Name, err)
+		}
+	}
+
+	return volume, volumeDef.Target.Format.Type, nil
+}
+
+// lookupDiskVolume returns the volume used by the disk, if it uses one.
+func lookupDiskVolume(virConn *libvirt.Libvirt, disk libvirtxml.DomainDisk) (libvirt.StorageVol, bool, error) {
+	switch {
+	case disk.Source == nil:
+		return libvirt.StorageVol{}, false, nil
+	case disk.Source.File != nil:
+		volume, err := virConn.StorageVolLookupByPath(disk.Source.File.File)
+		if err != nil {
+			if isError(err, libvirt.ErrNoStorageVol) {
+				return volume, false, nil
+			}
+			return volume, false, fmt.Errorf("can't retrieve volume %s: %w", disk.Source.File.File, err)
+		}
+		return volume, true, nil
+	case disk.Source.Volume != nil:
+		pool, err := virConn.StoragePoolLookupByName(disk.Source.Volume.Pool)
+		if err != nil {
+			return libvirt.StorageVol{}, false, fmt.Errorf("can't retrieve pool %s: %w", disk.Source.Volume.Pool, err)
+		}
+		volume, err := virConn.StorageVolLookupByName(pool, disk.Source.Volume.Volume)
+		if err != nil {
+			return volume, false, fmt.Errorf("can't retrieve volume %s: %w", disk.Source.Volume.Volume, err)
+		}
+		return volume, true, nil
+	}
+	return libvirt.StorageVol{}, false, nil
+}
+
+func deleteClonedVolumes(ctx context.Context, client *Client, keys []string) {
+	for _, key := range keys {
+		if err := volumeDelete(ctx, client, key); err != nil {
+			log.Printf("[WARN] could not delete cloned volume %s: %s", key, err)
+		}
+	}
+}
+
+// cloneDomain creates the definition of the domain from the one of the domain in
+// clone_from, with the disks of the source copied or backed by new volumes and new
+// MAC addresses for its network interfaces.
+func cloneDomain(ctx context.Context, d *schema.ResourceData, client *Client, generated libvirtxml.Domain) (libvirtxml.Domain, error) {
+	virConn := client.libvirt
+
+	sourceID := d.Get("clone_from.0.domain_id").(string)
+	sourceDomain, err := virConn.DomainLookupByUUID(parseUUID(sourceID))
+	if err != nil {
+		return generated, fmt.Errorf("can't retrieve domain %s to clone: %w", sourceID, err)
+	}
+
+	running, err := domainIsRunning(virConn, sourceDomain)
+	if err != nil {
+		return generated, err
+	}
+	if running {
+		return generated, fmt.Errorf("domain %s must be shut off to clone its disks", sourceDomain.Name)
+	}
+
+	sourceXML, err := virConn.DomainGetXMLDesc(sourceDomain, libvirt.DomainXMLInactive)
+	if err != nil {
+		return generated, fmt.Errorf("error retrieving libvirt domain XML description: %w", err)
+	}
+	var source libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(sourceXML), &source); err != nil {
+		return generated, fmt.Errorf("error reading libvirt domain XML description: %w", err)
+	}
+
+	clone, err := cloneDomainDef(d, source, generated)
+	if err != nil {
+		return generated, err
+	}
+
+	var volumeKeys, diskTargets, macs []string
+	fullCopy := d.Get("clone_from.0.full_copy").(bool)
+
+	var clonedDisks []libvirtxml.DomainDisk
+	for _, disk := range clone.Devices.Disks {
+		if disk.Target != nil {
+			diskTargets = append(diskTargets, disk.Target.Dev)
+		}
+
+		// only the disks are cloned, cdroms and other devices are shared with the source
+		sourceVolume, ok, err := lookupDiskVolume(virConn, disk)
+		if err != nil {
+			deleteClonedVolumes(ctx, client, volumeKeys)
+			return generated, err
+		}
+		if disk.Device != "disk" || !ok {
+			clonedDisks = append(clonedDisks, disk)
+			continue
+		}
+
+		pool, err := virConn.StoragePoolLookupByVolume(sourceVolume)
+		if err != nil {
+			deleteClonedVolumes(ctx, client, volumeKeys)
+			return generated, fmt.Errorf("can't retrieve pool of volume %s: %w", sourceVolume.Name, err)
+		}
+		if poolName, ok := d.GetOk("clone_from.0.pool"); ok {
+			if pool, err = virConn.StoragePoolLookupByName(poolName.(string)); err != nil {
+				deleteClonedVolumes(ctx, client, volumeKeys)
+				return generated, fmt.Errorf("can't retrieve pool %s: %w", poolName, err)
+			}
+		}
+
+		volume, format, err := func() (libvirt.StorageVol, string, error) {
+			client.poolMutexKV.Lock(pool.Name)
+			defer client.poolMutexKV.Unlock(pool.Name)
+			return cloneDiskVolume(virConn, pool, sourceVolume, clone.Name+"-"+sourceVolume.Name, fullCopy)
+		}()
+		if err != nil {
+			deleteClonedVolumes(ctx, client, volumeKeys)
+			return generated, err
+		}
+		volumeKeys = append(volumeKeys, volume.Key)
+
+		disk.Source = &libvirtxml.DomainDiskSource{
+			Volume: &libvirtxml.DomainDiskSourceVolume{
+				Pool:   pool.Name,
+				Volume: volume.Name,
+			},
+		}
+		disk.BackingStore = nil
+		driver := libvirtxml.DomainDiskDriver{Name: "qemu"}
+		if disk.Driver != nil {
+			driver = *disk.Driver
+		}
+		driver.Type = format
+		disk.Driver = &driver
+		clonedDisks = append(clonedDisks, disk)
+	}
+
+	renameConflictingDiskTargets(clonedDisks, generated.Devices.Disks)
+	clone.Devices.Disks = append(clonedDisks, generated.Devices.Disks...)
+
+	if isSetInConfig(d, "network_interface") {
+		clone.Devices.Interfaces = generated.Devices.Interfaces
+	} else {
+		sourceInterfaces := clone.Devices.Interfaces
+		clone.Devices.Interfaces = nil
+		for _, iface := range sourceInterfaces {
+			mac, err := randomMACAddress()
+			if err != nil {
+				deleteClonedVolumes(ctx, client, volumeKeys)
+				return generated, fmt.Errorf("error generating mac address: %w", err)
+			}
+			iface.MAC = &libvirtxml.DomainInterfaceMAC{Address: mac}
+			iface.Target = nil
+			clone.Devices.Interfaces = append(clone.Devices.Interfaces, iface)
+			macs = append(macs, strings.ToUpper(mac))
+		}
+	}
+
+	cloneFrom := d.Get("clone_from.0").(map[string]interface{})
+	cloneFrom["volume_ids"] = volumeKeys
+	cloneFrom["disk_targets"] = diskTargets
+	cloneFrom["macs"] = macs
+	if err := d.Set("clone_from", []interface{}{cloneFrom}); err != nil {
+		deleteClonedVolumes(ctx, client, volumeKeys)
+		return generated, err
+	}
+
+	return clone, nil
+}
+
+// clonedVolumeIDs returns the volumes created by cloneDomain.
+func clonedVolumeIDs(d *schema.ResourceData) []string {
+	var keys []string
+	for _, key := range d.Get("clone_from.0.volume_ids").([]interface{}) {
+		keys = append(keys, key.(string))
+	}
+	return keys
+}
+
+// isClonedDevice tells whether the value, a disk target or a MAC address, is in the
+// list of the devices cloned from the source domain.
+func isClonedDevice(d *schema.ResourceData, list string, value string) bool {
+	for _, cloned := range d.Get("clone_from.0." + list).([]interface{}) {
+		if strings.EqualFold(cloned.(string), value) {
+			return true
+		}
+	}
+	return false
+}
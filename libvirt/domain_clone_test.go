@@ -0,0 +1,147 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestRenameConflictingDiskTargets(t *testing.T) {
+	cloned := []libvirtxml.DomainDisk{
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "vda"}},
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "vdb"}},
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "hda"}},
+	}
+	disks := []libvirtxml.DomainDisk{
+		newDefDisk(0),
+		newDefDisk(1),
+		newDefDisk(2),
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "hda"}},
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "x"}},
+	}
+	cloned = append(cloned, libvirtxml.DomainDisk{Target: &libvirtxml.DomainDiskTarget{Dev: "x"}})
+
+	renameConflictingDiskTargets(cloned, disks)
+
+	// targets without a known prefix are left as they are
+	expected := []string{"vdc", "vdd", "vde", "hdb", "x"}
+	for i, disk := range disks {
+		if disk.Target.Dev != expected[i] {
+			t.Errorf("expected disk %d to have target %s, got %s", i, expected[i], disk.Target.Dev)
+		}
+	}
+}
+
+func TestCloneDomainDef(t *testing.T) {
+	source := newDomainDef()
+	source.Name = "golden"
+	source.UUID = "a3b5b0a4-5c3c-4e0c-9bb2-1e8a8f0e5b1d"
+	source.Memory = &libvirtxml.DomainMemory{Value: 4096, Unit: "MiB"}
+	source.Devices.Graphics = []libvirtxml.DomainGraphic{{VNC: &libvirtxml.DomainGraphicVNC{}}}
+	source.Devices.Hostdevs = []libvirtxml.DomainHostdev{{}}
+	source.Metadata = &libvirtxml.DomainMetadata{
+		XML: `<tf:tags xmlns:tf="` + tagsMetadataNamespace + `"><tf:tag name="role">golden</tf:tag></tf:tags>`,
+	}
+
+	generated := newDomainDef()
+	generated.Name = "clone"
+
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "clone",
+		"tags": map[string]interface{}{"owner": "alice"},
+	})
+
+	clone, err := cloneDomainDef(d, source, generated)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if clone.Name != "clone" || clone.UUID != "" {
+		t.Errorf("expected the name of the resource and no UUID, got %s and %s", clone.Name, clone.UUID)
+	}
+	if clone.Memory.Value != defaultDomainMemoryMiB {
+		t.Errorf("expected the memory of the resource, got %d", clone.Memory.Value)
+	}
+	if len(clone.Devices.Hostdevs) != 1 || clone.Devices.Graphics[0].VNC == nil {
+		t.Errorf("expected the devices of the source domain to be kept")
+	}
+
+	tags, err := tagsFromMetadata(clone.Metadata.XML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tags) != 1 || tags["owner"] != "alice" {
+		t.Errorf("expected only the tags of the resource, got %v", tags)
+	}
+
+	// the source definition is left untouched
+	if source.Devices.Graphics[0].VNC == nil || source.Name != "golden" {
+		t.Errorf("the source definition was modified")
+	}
+}
+
+func TestCloneDomainDefFirmware(t *testing.T) {
+	index := uint(0)
+	source := newDomainDef()
+	source.OS.Firmware = "efi"
+	source.OS.Loader = &libvirtxml.DomainLoader{Path: "/usr/share/qemu/ovmf-x86_64-code.bin", Type: "pflash"}
+	source.OS.NVRam = &libvirtxml.DomainNVRam{
+		NVRam:    "/var/lib/libvirt/qemu/nvram/golden_VARS.fd",
+		Template: "/usr/share/qemu/ovmf-x86_64-vars.bin",
+	}
+	source.Devices.Controllers = []libvirtxml.DomainController{{Type: "usb", Index: &index, Model: "qemu-xhci"}}
+
+	generated := newDomainDef()
+	generated.Name = "clone"
+	generated.Devices.Controllers = []libvirtxml.DomainController{
+		{Type: "usb", Index: &index, Model: "none"},
+		{Type: "virtio-serial", Index: &index},
+	}
+
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "clone",
+	})
+
+	clone, err := cloneDomainDef(d, source, generated)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if clone.OS.Firmware != "efi" || clone.OS.Loader == nil || clone.OS.Loader.Path != source.OS.Loader.Path {
+		t.Errorf("expected the firmware of the source domain, got %+v", clone.OS)
+	}
+	if clone.OS.NVRam == nil || clone.OS.NVRam.NVRam != "" || clone.OS.NVRam.Template != source.OS.NVRam.Template {
+		t.Errorf("expected new variables from the template of the source, got %+v", clone.OS.NVRam)
+	}
+	if source.OS.NVRam.NVRam == "" {
+		t.Errorf("the source definition was modified")
+	}
+
+	if len(clone.Devices.Controllers) != 2 || clone.Devices.Controllers[0].Model != "qemu-xhci" ||
+		clone.Devices.Controllers[1].Type != "virtio-serial" {
+		t.Errorf("expected the controllers of the source and the missing generated ones, got %+v", clone.Devices.Controllers)
+	}
+
+	// without a template, the variables of the source are copied
+	source.OS.NVRam.Template = ""
+	if clone, err = cloneDomainDef(d, source, generated); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if clone.OS.NVRam.Template != "/var/lib/libvirt/qemu/nvram/golden_VARS.fd" {
+		t.Errorf("expected the variables of the source as template, got %+v", clone.OS.NVRam)
+	}
+
+	// the firmware of the resource replaces the one of the source
+	d = schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":     "clone",
+		"firmware": "/usr/share/qemu/bios.bin",
+	})
+	generated.OS.Loader = &libvirtxml.DomainLoader{Path: "/usr/share/qemu/bios.bin"}
+	if clone, err = cloneDomainDef(d, source, generated); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if clone.OS.Firmware != "" || clone.OS.Loader.Path != "/usr/share/qemu/bios.bin" || clone.OS.NVRam != nil {
+		t.Errorf("expected the firmware of the resource, got %+v", clone.OS)
+	}
+}
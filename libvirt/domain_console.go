@@ -0,0 +1,107 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// how much of the console output we keep around for diagnostics.
+const consoleOutputMaxBytes = 16 * 1024
+
+var errConsoleCaptureStopped = errors.New("console capture stopped")
+
+// consoleCapture keeps the tail of the output written to a domain console.
+//
+// The libvirt console stream never ends on its own, and go-libvirt only gives
+// control back when the writer fails or the connection is closed. So the capture
+// runs on a connection of its own, closed when it is stopped, which ends the
+// console session on the libvirt side too.
+type consoleCapture struct {
+	mutex    sync.Mutex
+	buf      []byte
+	maxBytes int
+	stopped  bool
+	// connection of the console stream, if any
+	virConn *libvirt.Libvirt
+}
+
+func newConsoleCapture(maxBytes int) *consoleCapture {
+	return &consoleCapture{maxBytes: maxBytes}
+}
+
+func (c *consoleCapture) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stopped {
+		return 0, errConsoleCaptureStopped
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) > c.maxBytes {
+		c.buf = c.buf[len(c.buf)-c.maxBytes:]
+	}
+	return len(p), nil
+}
+
+// Stop ends the capture, closing its console session, and returns what was
+// captured so far.
+func (c *consoleCapture) Stop() string {
+	c.mutex.Lock()
+	wasStopped := c.stopped
+	c.stopped = true
+	output := strings.ToValidUTF8(string(c.buf), "")
+	c.mutex.Unlock()
+
+	if !wasStopped && c.virConn != nil {
+		if err := c.virConn.Disconnect(); err != nil {
+			log.Printf("[DEBUG] error closing the console connection: %s", err)
+		}
+	}
+	return output
+}
+
+// startConsoleCapture starts copying the output of the domain console named devName
+// (or the first console if empty) in the background, on a new connection to uri.
+// The returned channel gets the error the stream ended with.
+func startConsoleCapture(uri string, domain libvirt.Domain, devName string) (*consoleCapture, <-chan error, error) {
+	virConn, err := connectURI(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capture := newConsoleCapture(consoleOutputMaxBytes)
+	capture.virConn = virConn
+	done := make(chan error, 1)
+
+	var dev libvirt.OptString
+	if devName != "" {
+		dev = libvirt.OptString{devName}
+	}
+
+	go func() {
+		err := virConn.DomainOpenConsole(domain, dev, capture, uint32(libvirt.DomainConsoleSafe))
+		if err != nil && !errors.Is(err, errConsoleCaptureStopped) {
+			log.Printf("[DEBUG] console capture of domain %s ended: %s", domain.Name, err)
+		}
+		done <- err
+	}()
+
+	return capture, done, nil
+}
+
+// isConsoleLogged returns whether any console of the domain has a log file.
+func isConsoleLogged(d *schema.ResourceData) bool {
+	for i := 0; i < d.Get("console.#").(int); i++ {
+		if d.Get(fmt.Sprintf("console.%d.log_file", i)).(string) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package libvirt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestConsoleCapture(t *testing.T) {
+	capture := newConsoleCapture(8)
+
+	for _, chunk := range []string{"boot", "ing the", " kernel"} {
+		if _, err := capture.Write([]byte(chunk)); err != nil {
+			t.Fatalf("unexpected error writing %q: %s", chunk, err)
+		}
+	}
+
+	if output := capture.Stop(); output != "e kernel" {
+		t.Errorf("expected the last 8 bytes of output, got %q", output)
+	}
+
+	if _, err := capture.Write([]byte("more")); !errors.Is(err, errConsoleCaptureStopped) {
+		t.Errorf("expected writes to fail once stopped, got %v", err)
+	}
+}
+
+func TestIsConsoleLogged(t *testing.T) {
+	console := map[string]interface{}{"type": "pty", "target_port": "0"}
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":    "test",
+		"console": []interface{}{console},
+	})
+	if isConsoleLogged(d) {
+		t.Errorf("expected a console without log file not to be captured")
+	}
+
+	logged := map[string]interface{}{"type": "pty", "target_port": "1", "log_file": "/var/log/console.log"}
+	d = schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":    "test",
+		"console": []interface{}{console, logged},
+	})
+	if !isConsoleLogged(d) {
+		t.Errorf("expected a console with a log file to be captured")
+	}
+}
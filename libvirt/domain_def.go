@@ -4,13 +4,17 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/beevik/etree"
 	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"libvirt.org/go/libvirtxml"
 )
 
 const (
+	qemuXMLNamespace = "http://libvirt.org/schemas/domain/qemu/1.0"
+
 	defaultDomainMemoryMiB = 512
 )
 
@@ -30,6 +34,105 @@ func getXMLDomainDefFromLibvirt(virConn *libvirt.Libvirt, domain libvirt.Domain)
 	return domainDef, nil
 }
 
+// libvirtxml does not model <on_lockfailure>, so it is read and written
+// separately from the rest of the domain definition.
+type domainLockFailure struct {
+	XMLName       xml.Name `xml:"domain"`
+	OnLockFailure string   `xml:"on_lockfailure"`
+}
+
+// getOnLockFailure returns the lock failure action of a domain XML description.
+func getOnLockFailure(xmlDesc string) (string, error) {
+	var lockFailure domainLockFailure
+	if err := xml.Unmarshal([]byte(xmlDesc), &lockFailure); err != nil {
+		return "", fmt.Errorf("error reading libvirt domain XML description: %w", err)
+	}
+	return lockFailure.OnLockFailure, nil
+}
+
+// setOnLockFailure adds the lock failure action to a domain XML description, after
+// the other lifecycle actions like libvirt stores it.
+func setOnLockFailure(xmlDesc string, action string) (string, error) {
+	if action == "" {
+		return xmlDesc, nil
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlDesc); err != nil {
+		return "", fmt.Errorf("failed to parse domain XML: %w", err)
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "domain" {
+		return "", fmt.Errorf("could not find the domain definition")
+	}
+
+	element := etree.NewElement("on_lockfailure")
+	element.SetText(action)
+
+	children := root.ChildElements()
+	if len(children) == 0 {
+		root.AddChild(element)
+		return doc.WriteToString()
+	}
+
+	previous := children[len(children)-1]
+	for _, child := range children {
+		switch child.Tag {
+		case "on_poweroff", "on_reboot", "on_crash":
+			previous = child
+		}
+	}
+
+	// indented like the element it follows
+	index := previous.Index()
+	root.InsertChildAt(index+1, element)
+	if index > 0 {
+		if indent, ok := root.Child[index-1].(*etree.CharData); ok && indent.IsWhitespace() {
+			root.InsertChildAt(index+1, etree.NewText(indent.Data))
+		}
+	}
+
+	return doc.WriteToString()
+}
+
+// setQEMUNamespacePrefix declares the QEMU namespace with the qemu prefix on the domain
+// element, as libvirt does, instead of on each element of the namespace. That way the
+// xslt stylesheets and the patch operations of the xml block can match qemu:commandline.
+func setQEMUNamespacePrefix(xmlDesc string) (string, error) {
+	if !strings.Contains(xmlDesc, qemuXMLNamespace) {
+		return xmlDesc, nil
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlDesc); err != nil {
+		return "", fmt.Errorf("failed to parse domain XML: %w", err)
+	}
+	root := doc.Root()
+
+	found := false
+	for _, child := range root.ChildElements() {
+		if child.Space != "" || child.SelectAttrValue("xmlns", "") != qemuXMLNamespace {
+			continue
+		}
+		found = true
+		child.RemoveAttr("xmlns")
+		setXMLElementSpace(child, "qemu")
+	}
+	if !found {
+		return xmlDesc, nil
+	}
+	root.CreateAttr("xmlns:qemu", qemuXMLNamespace)
+
+	return doc.WriteToString()
+}
+
+func setXMLElementSpace(element *etree.Element, space string) {
+	element.Space = space
+	for _, child := range element.ChildElements() {
+		setXMLElementSpace(child, space)
+	}
+}
+
 // note source and target are not initialized.
 func newFilesystemDef() libvirtxml.DomainFilesystem {
 	return libvirtxml.DomainFilesystem{
@@ -84,17 +187,12 @@ func newDomainDef() libvirtxml.Domain {
 		},
 	}
 
-	// FIXME: We should allow setting this from configuration as well.
-	rngDev := os.Getenv("TF_LIBVIRT_RNG_DEV")
-	if rngDev == "" {
-		rngDev = "/dev/urandom"
-	}
-
+	// can be overridden with the rng block of the domain resource
 	domainDef.Devices.RNGs = []libvirtxml.DomainRNG{
 		{
 			Model: "virtio",
 			Backend: &libvirtxml.DomainRNGBackend{
-				Random: &libvirtxml.DomainRNGBackendRandom{Device: rngDev},
+				Random: &libvirtxml.DomainRNGBackendRandom{Device: defaultRNGDevice()},
 			},
 		},
 	}
@@ -102,7 +200,20 @@ func newDomainDef() libvirtxml.Domain {
 	return domainDef
 }
 
+// defaultRNGDevice returns the host device of random rng backends, TF_LIBVIRT_RNG_DEV
+// or /dev/urandom.
+func defaultRNGDevice() string {
+	if rngDev := os.Getenv("TF_LIBVIRT_RNG_DEV"); rngDev != "" {
+		return rngDev
+	}
+	return "/dev/urandom"
+}
+
 func newDomainDefForConnection(virConn *libvirt.Libvirt, rd *schema.ResourceData) (libvirtxml.Domain, error) {
+	if isLXCDomain(rd) {
+		return newLXCDomainDefForConnection(virConn, rd)
+	}
+
 	d := newDomainDef()
 
 	if arch, ok := rd.GetOk("arch"); ok {
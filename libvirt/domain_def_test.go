@@ -3,9 +3,11 @@ package libvirt
 import (
 	"bytes"
 	"encoding/xml"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"libvirt.org/go/libvirtxml"
 )
 
 func init() {
@@ -21,3 +23,121 @@ func TestDefaultDomainMarshall(t *testing.T) {
 		t.Fatalf("could not marshall this:\n%s", spew.Sdump(b))
 	}
 }
+
+func TestOnLockFailure(t *testing.T) {
+	data, err := xmlMarshallIndented(newDomainDef())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged, err := setOnLockFailure(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != data {
+		t.Errorf("expected an empty action to leave the definition untouched")
+	}
+
+	data, err = setOnLockFailure(data, "poweroff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action, err := getOnLockFailure(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != "poweroff" {
+		t.Errorf("expected on_lockfailure to be 'poweroff', got '%s'", action)
+	}
+
+	// inserted after the lifecycle actions, keeping the indentation
+	data, err = setOnLockFailure(`<domain type="kvm">
+  <name>test</name>
+  <on_crash>destroy</on_crash>
+  <devices>
+    <emulator>/usr/bin/qemu-system-x86_64</emulator>
+  </devices>
+</domain>`, "pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<domain type="kvm">
+  <name>test</name>
+  <on_crash>destroy</on_crash>
+  <on_lockfailure>pause</on_lockfailure>
+  <devices>
+    <emulator>/usr/bin/qemu-system-x86_64</emulator>
+  </devices>
+</domain>`
+	if data != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	// appended when there are none
+	data, err = setOnLockFailure(`<domain type="kvm">
+  <name>test</name>
+</domain>`, "ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `<domain type="kvm">
+  <name>test</name>
+  <on_lockfailure>ignore</on_lockfailure>
+</domain>`
+	if data != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	if _, err := setOnLockFailure("<network/>", "poweroff"); err == nil {
+		t.Errorf("expected an error when the definition is not a domain")
+	}
+}
+
+func TestQEMUNamespacePrefix(t *testing.T) {
+	data, err := xmlMarshallIndented(newDomainDef())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged, err := setQEMUNamespacePrefix(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != data {
+		t.Errorf("expected a definition without QEMU elements to be left untouched")
+	}
+
+	domainDef := newDomainDef()
+	domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{
+		Args: []libvirtxml.DomainQEMUCommandlineArg{{Value: "-fw_cfg"}},
+	}
+	domainDef.QEMUCapabilities = &libvirtxml.DomainQEMUCapabilities{
+		Del: []libvirtxml.DomainQEMUCapabilitiesEntry{{Name: "blockdev"}},
+	}
+	data, err = xmlMarshallIndented(domainDef)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = setQEMUNamespacePrefix(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`xmlns:qemu="` + qemuXMLNamespace + `"`, "<qemu:commandline>",
+		`<qemu:arg value="-fw_cfg"`, `<qemu:del capability="blockdev"`} {
+		if !strings.Contains(data, expected) {
+			t.Errorf("expected %s in:\n%s", expected, data)
+		}
+	}
+
+	// and it reads back the same
+	var parsed libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(data), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.QEMUCommandline == nil || len(parsed.QEMUCommandline.Args) != 1 ||
+		parsed.QEMUCapabilities == nil || len(parsed.QEMUCapabilities.Del) != 1 {
+		t.Errorf("expected the QEMU elements to be parsed back, got %s", spew.Sdump(parsed.QEMUCommandline))
+	}
+}
@@ -0,0 +1,172 @@
+package libvirt
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+)
+
+// upper bound for the polling interval used while waiting for a domain.
+const domainWaitMaxPollInterval = 10 * time.Second
+
+// domainEventHub shares a single subscription to the libvirt domain events of a
+// connection between all the resources waiting for a domain, and wakes up only
+// the waiters of the domain an event is about.
+type domainEventHub struct {
+	virConn *libvirt.Libvirt
+
+	mutex      sync.Mutex
+	subscribed bool
+	waiters    map[string]map[chan struct{}]struct{}
+}
+
+func newDomainEventHub(virConn *libvirt.Libvirt) *domainEventHub {
+	return &domainEventHub{
+		virConn: virConn,
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// watch returns a channel which receives a value whenever the lifecycle of the
+// domain or the state of its guest agent changes, and a function to stop watching.
+//
+// If the events can't be subscribed to, the returned channel never fires and the
+// caller is left with polling.
+func (h *domainEventHub) watch(domain libvirt.Domain) (<-chan struct{}, func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.subscribed {
+		if err := h.subscribe(); err != nil {
+			log.Printf("[WARN] unable to subscribe to domain events, falling back to polling: %s", err)
+			return nil, func() {}
+		}
+	}
+
+	uuid := uuidString(domain.UUID)
+	ch := make(chan struct{}, 1)
+	if h.waiters[uuid] == nil {
+		h.waiters[uuid] = make(map[chan struct{}]struct{})
+	}
+	h.waiters[uuid][ch] = struct{}{}
+
+	return ch, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		delete(h.waiters[uuid], ch)
+		if len(h.waiters[uuid]) == 0 {
+			delete(h.waiters, uuid)
+		}
+	}
+}
+
+// subscribe must be called with the mutex held.
+func (h *domainEventHub) subscribe() error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var streams []<-chan interface{}
+	for _, eventID := range []libvirt.DomainEventID{libvirt.DomainEventIDLifecycle, libvirt.DomainEventIDAgentLifecycle} {
+		events, err := h.virConn.SubscribeEvents(ctx, eventID, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		streams = append(streams, events)
+	}
+
+	var wg sync.WaitGroup
+	for _, events := range streams {
+		wg.Add(1)
+		go func(events <-chan interface{}) {
+			defer wg.Done()
+			for event := range events {
+				if domain, ok := domainFromEvent(event); ok {
+					h.notify(uuidString(domain.UUID))
+				}
+			}
+			// the connection is gone or one of the streams failed, start over on next watch
+			cancel()
+		}(events)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.subscribed = false
+	}()
+
+	h.subscribed = true
+	return nil
+}
+
+func (h *domainEventHub) notify(uuid string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.waiters[uuid] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// a wake up is already pending
+		}
+	}
+}
+
+func domainFromEvent(event interface{}) (libvirt.Domain, bool) {
+	switch e := event.(type) {
+	case *libvirt.DomainEventCallbackLifecycleMsg:
+		return e.Msg.Dom, true
+	case *libvirt.DomainEventCallbackAgentLifecycleMsg:
+		return e.Dom, true
+	}
+	return libvirt.Domain{}, false
+}
+
+// waitForDomainEvents calls refresh until it returns the target state, an error, or
+// the timeout expires. It polls with an exponential backoff, and looks again right
+// away whenever something is received on events.
+func waitForDomainEvents(ctx context.Context, timeout time.Duration, events <-chan struct{},
+	refresh retry.StateRefreshFunc, target string,
+) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	interval := resourceStateMinTimeout
+	var lastState string
+	for {
+		_, state, err := refresh()
+		if err != nil {
+			return err
+		}
+		if state == target {
+			return nil
+		}
+		lastState = state
+
+		poll := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			poll.Stop()
+			return ctx.Err()
+		case <-deadline.C:
+			poll.Stop()
+			return &retry.TimeoutError{
+				LastState:     lastState,
+				ExpectedState: []string{target},
+				Timeout:       timeout,
+			}
+		case <-events:
+			poll.Stop()
+			interval = resourceStateMinTimeout
+		case <-poll.C:
+			interval = min(interval*2, domainWaitMaxPollInterval) //nolint:mnd
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
+)
+
+func TestWaitForDomainEventsWakesUpOnEvent(t *testing.T) {
+	events := make(chan struct{}, 1)
+	events <- struct{}{}
+
+	calls := 0
+	refresh := func() (interface{}, string, error) {
+		calls++
+		if calls > 1 {
+			return true, resourceStateConfDone, nil
+		}
+		return false, resourceStateConfPending, nil
+	}
+
+	start := time.Now()
+	if err := waitForDomainEvents(context.Background(), time.Minute, events, refresh, resourceStateConfDone); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed >= resourceStateMinTimeout {
+		t.Errorf("expected the event to wake up the waiter before the next poll, took %s", elapsed)
+	}
+}
+
+func TestWaitForDomainEventsTimeout(t *testing.T) {
+	refresh := func() (interface{}, string, error) {
+		return false, resourceStateConfPending, nil
+	}
+
+	err := waitForDomainEvents(context.Background(), 50*time.Millisecond, nil, refresh, resourceStateConfDone)
+	var timeoutErr *retry.TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if timeoutErr.LastState != resourceStateConfPending {
+		t.Errorf("expected last state %s, got %s", resourceStateConfPending, timeoutErr.LastState)
+	}
+}
+
+func TestDomainEventHubNotify(t *testing.T) {
+	hub := newDomainEventHub(nil)
+	// pretend we are subscribed so watch does not talk to libvirt
+	hub.subscribed = true
+
+	domain := libvirt.Domain{UUID: parseUUID("a3d6f2b4-6cb0-4b6f-8e43-0c5b1c1b5e59")}
+	other := libvirt.Domain{UUID: parseUUID("f1f4c7d2-5d4a-4b43-9a50-1a7f4e0c3b21")}
+
+	events, stop := hub.watch(domain)
+	otherEvents, stopOther := hub.watch(other)
+	defer stopOther()
+
+	ev, ok := domainFromEvent(&libvirt.DomainEventCallbackAgentLifecycleMsg{Dom: domain})
+	if !ok {
+		t.Fatal("expected the agent lifecycle event to carry a domain")
+	}
+	hub.notify(uuidString(ev.UUID))
+	hub.notify(uuidString(ev.UUID))
+
+	select {
+	case <-events:
+	default:
+		t.Error("expected the waiter of the domain to be woken up")
+	}
+	select {
+	case <-otherEvents:
+		t.Error("did not expect the waiter of another domain to be woken up")
+	default:
+	}
+
+	stop()
+	if _, ok := hub.waiters[uuidString(domain.UUID)]; ok {
+		t.Error("expected the waiter to be removed")
+	}
+}
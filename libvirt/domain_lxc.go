@@ -0,0 +1,267 @@
+package libvirt
+
+import (
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// the init used by the lxc driver when none is given.
+const lxcDefaultInit = "/sbin/init"
+
+// the root filesystem of a container is the filesystem mounted on this target.
+const lxcRootTarget = "/"
+
+func isLXCDomain(d *schema.ResourceData) bool {
+	return d.Get("type").(string) == "lxc"
+}
+
+func rootFilesystemSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"source": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"volume_id": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"readonly": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
+
+func idmapRangeSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"start": {
+					Type:     schema.TypeInt,
+					Required: true,
+					ForceNew: true,
+				},
+				"target": {
+					Type:     schema.TypeInt,
+					Required: true,
+					ForceNew: true,
+				},
+				"count": {
+					Type:     schema.TypeInt,
+					Required: true,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
+
+func idmapSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"uid": idmapRangeSchema(),
+				"gid": idmapRangeSchema(),
+			},
+		},
+	}
+}
+
+// Creates a container definition with the defaults
+// the provider uses. The lxc driver has no machine, firmware or
+// emulator, and only knows about a small set of devices.
+func newLXCDomainDef() libvirtxml.Domain {
+	return libvirtxml.Domain{
+		Type: "lxc",
+		OS: &libvirtxml.DomainOS{
+			Type: &libvirtxml.DomainOSType{
+				Type: "exe",
+			},
+			Init: lxcDefaultInit,
+		},
+		Memory: &libvirtxml.DomainMemory{
+			Unit:  "MiB",
+			Value: 512,
+		},
+		VCPU: &libvirtxml.DomainVCPU{
+			Placement: "static",
+			Value:     1,
+		},
+		Devices: &libvirtxml.DomainDeviceList{},
+	}
+}
+
+func newLXCDomainDefForConnection(virConn *libvirt.Libvirt, rd *schema.ResourceData) (libvirtxml.Domain, error) {
+	d := newLXCDomainDef()
+
+	if arch, ok := rd.GetOk("arch"); ok {
+		d.OS.Type.Arch = arch.(string)
+	} else {
+		arch, err := getHostArchitecture(virConn)
+		if err != nil {
+			return d, err
+		}
+		d.OS.Type.Arch = arch
+	}
+
+	if emulator, ok := rd.GetOk("emulator"); ok {
+		d.Devices.Emulator = emulator.(string)
+	}
+	return d, nil
+}
+
+func newRootFilesystemDef(d *schema.ResourceData, virConn *libvirt.Libvirt) (*libvirtxml.DomainFilesystem, error) {
+	prefix := "root_filesystem.0"
+	if _, ok := d.GetOk(prefix); !ok {
+		return nil, nil
+	}
+
+	fs := libvirtxml.DomainFilesystem{
+		AccessMode: "passthrough",
+		Target: &libvirtxml.DomainFilesystemTarget{
+			Dir: lxcRootTarget,
+		},
+	}
+	if d.Get(prefix + ".readonly").(bool) {
+		fs.ReadOnly = &libvirtxml.DomainFilesystemReadOnly{}
+	}
+
+	source, hasSource := d.GetOk(prefix + ".source")
+	volumeKey, hasVolume := d.GetOk(prefix + ".volume_id")
+	switch {
+	case hasSource && hasVolume:
+		return nil, fmt.Errorf("root_filesystem can't have both 'source' and 'volume_id' set")
+	case hasSource:
+		fs.Source = &libvirtxml.DomainFilesystemSource{
+			Mount: &libvirtxml.DomainFilesystemSourceMount{
+				Dir: source.(string),
+			},
+		}
+	case hasVolume:
+		volume, err := virConn.StorageVolLookupByKey(volumeKey.(string))
+		if err != nil {
+			return nil, fmt.Errorf("can't retrieve volume %s: %w", volumeKey.(string), err)
+		}
+		volumeDef, err := newDefVolumeFromLibvirt(virConn, volume)
+		if err != nil {
+			return nil, err
+		}
+
+		if volumeDef.Target == nil || volumeDef.Target.Path == "" {
+			return nil, fmt.Errorf("volume %s has no path", volumeKey.(string))
+		}
+
+		// raw images are mounted through a loop device, anything else is exported
+		// with qemu-nbd
+		fs.Driver = &libvirtxml.DomainFilesystemDriver{Type: "loop", Format: "raw"}
+		if volumeDef.Target.Format != nil && volumeDef.Target.Format.Type != "" && volumeDef.Target.Format.Type != "raw" {
+			fs.Driver = &libvirtxml.DomainFilesystemDriver{Type: "nbd", Format: volumeDef.Target.Format.Type}
+		}
+		fs.Source = &libvirtxml.DomainFilesystemSource{
+			File: &libvirtxml.DomainFilesystemSourceFile{
+				File: volumeDef.Target.Path,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("root_filesystem must have either 'source' or 'volume_id' set")
+	}
+
+	return &fs, nil
+}
+
+func newIDMapRanges(d *schema.ResourceData, key string) []libvirtxml.DomainIDMapRange {
+	var ranges []libvirtxml.DomainIDMapRange
+	for i := 0; i < d.Get(key+".#").(int); i++ {
+		prefix := fmt.Sprintf("%s.%d", key, i)
+		ranges = append(ranges, libvirtxml.DomainIDMapRange{
+			Start:  uint(d.Get(prefix + ".start").(int)),
+			Target: uint(d.Get(prefix + ".target").(int)),
+			Count:  uint(d.Get(prefix + ".count").(int)),
+		})
+	}
+	return ranges
+}
+
+// the settings of virtual machines the lxc driver does not support.
+var lxcUnsupportedKeys = []string{
+	"disk", "cloudinit", "coreos_ignition", "kernel", "initrd", "uki", "dtb", "firmware", "nvram",
+	"boot_device", "graphics", "video", "tpm", "rng", "watchdog", "memballoon", "vsock", "input",
+	"sound", "channel", "qemu_agent", "qemu_commandline",
+}
+
+// checkLXCDomain fails when settings of virtual machines are used for a container,
+// before any of them is added to its definition.
+func checkLXCDomain(d *schema.ResourceData) error {
+	for _, key := range lxcUnsupportedKeys {
+		if _, ok := d.GetOk(key); ok {
+			return fmt.Errorf("'%s' is not supported by lxc domains", key)
+		}
+	}
+	return nil
+}
+
+// setLXC sets the parts of the definition specific to containers: the init, the
+// root filesystem, the user namespace mappings and a console if none was given.
+func setLXC(d *schema.ResourceData, domainDef *libvirtxml.Domain, virConn *libvirt.Libvirt) error {
+	if initPath, ok := d.GetOk("init"); ok {
+		domainDef.OS.Init = initPath.(string)
+	}
+	for _, arg := range d.Get("init_args").([]interface{}) {
+		domainDef.OS.InitArgs = append(domainDef.OS.InitArgs, arg.(string))
+	}
+
+	rootFS, err := newRootFilesystemDef(d, virConn)
+	if err != nil {
+		return err
+	}
+	if rootFS != nil {
+		domainDef.Devices.Filesystems = append([]libvirtxml.DomainFilesystem{*rootFS}, domainDef.Devices.Filesystems...)
+	}
+
+	if _, ok := d.GetOk("idmap.0"); ok {
+		domainDef.IDMap = &libvirtxml.DomainIDMap{
+			UIDs: newIDMapRanges(d, "idmap.0.uid"),
+			GIDs: newIDMapRanges(d, "idmap.0.gid"),
+		}
+		if len(domainDef.IDMap.UIDs) == 0 || len(domainDef.IDMap.GIDs) == 0 {
+			return fmt.Errorf("idmap must have both 'uid' and 'gid' mappings")
+		}
+	}
+
+	if len(domainDef.Devices.Consoles) == 0 {
+		port := uint(0)
+		domainDef.Devices.Consoles = append(domainDef.Devices.Consoles, libvirtxml.DomainConsole{
+			Source: &libvirtxml.DomainChardevSource{
+				Pty: &libvirtxml.DomainChardevSourcePty{},
+			},
+			Target: &libvirtxml.DomainConsoleTarget{
+				Type: "lxc",
+				Port: &port,
+			},
+		})
+	}
+
+	return nil
+}
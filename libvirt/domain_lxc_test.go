@@ -0,0 +1,79 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestSetLXC(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":      "test",
+		"type":      "lxc",
+		"init":      "/bin/sh",
+		"init_args": []interface{}{"-c", "sleep infinity"},
+		"root_filesystem": []interface{}{
+			map[string]interface{}{"source": "/var/lib/containers/test"},
+		},
+		"idmap": []interface{}{
+			map[string]interface{}{
+				"uid": []interface{}{map[string]interface{}{"start": 0, "target": 100000, "count": 65536}},
+				"gid": []interface{}{map[string]interface{}{"start": 0, "target": 100000, "count": 65536}},
+			},
+		},
+	})
+
+	domainDef := newLXCDomainDef()
+	domainDef.Devices.Filesystems = []libvirtxml.DomainFilesystem{
+		{
+			Source: &libvirtxml.DomainFilesystemSource{Mount: &libvirtxml.DomainFilesystemSourceMount{Dir: "/srv"}},
+			Target: &libvirtxml.DomainFilesystemTarget{Dir: "/srv"},
+		},
+	}
+
+	if err := setLXC(d, &domainDef, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if domainDef.OS.Type.Type != "exe" || domainDef.OS.Init != "/bin/sh" || len(domainDef.OS.InitArgs) != 2 {
+		t.Errorf("unexpected os %+v", domainDef.OS)
+	}
+
+	filesystems := domainDef.Devices.Filesystems
+	if len(filesystems) != 2 || filesystems[0].Target.Dir != "/" ||
+		filesystems[0].Source.Mount.Dir != "/var/lib/containers/test" || filesystems[0].AccessMode != "passthrough" {
+		t.Errorf("expected the root filesystem first, got %+v", filesystems)
+	}
+
+	if domainDef.IDMap == nil || len(domainDef.IDMap.UIDs) != 1 || domainDef.IDMap.UIDs[0].Target != 100000 ||
+		len(domainDef.IDMap.GIDs) != 1 || domainDef.IDMap.GIDs[0].Count != 65536 {
+		t.Errorf("unexpected idmap %+v", domainDef.IDMap)
+	}
+
+	consoles := domainDef.Devices.Consoles
+	if len(consoles) != 1 || consoles[0].Target.Type != "lxc" || consoles[0].Source.Pty == nil {
+		t.Errorf("expected a default lxc console, got %+v", consoles)
+	}
+}
+
+func TestSetLXCUnsupported(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name":   "test",
+		"type":   "lxc",
+		"kernel": "/boot/vmlinuz",
+	})
+
+	if err := checkLXCDomain(d); err == nil {
+		t.Error("expected an error for a kernel in a container")
+	}
+
+	d = schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "test",
+		"type": "lxc",
+		"rng":  []interface{}{map[string]interface{}{"backend": "random"}},
+	})
+	if err := checkLXCDomain(d); err == nil {
+		t.Error("expected an error for a rng in a container")
+	}
+}
@@ -0,0 +1,136 @@
+package libvirt
+
+import (
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// statistics requested to ConnectGetAllDomainStats.
+const domainStatsTypes = libvirt.DomainStatsState | libvirt.DomainStatsCPUTotal |
+	libvirt.DomainStatsBalloon | libvirt.DomainStatsVCPU | libvirt.DomainStatsInterface |
+	libvirt.DomainStatsBlock
+
+var domainStateNames = map[libvirt.DomainState]string{
+	libvirt.DomainNostate:     "nostate",
+	libvirt.DomainRunning:     "running",
+	libvirt.DomainBlocked:     "blocked",
+	libvirt.DomainPaused:      "paused",
+	libvirt.DomainShutdown:    "shutdown",
+	libvirt.DomainShutoff:     "shutoff",
+	libvirt.DomainCrashed:     "crashed",
+	libvirt.DomainPmsuspended: "pmsuspended",
+}
+
+// the states the domains can be filtered by, done by libvirt.
+var domainStatsStateFlags = map[string]libvirt.ConnectGetAllDomainStatsFlags{
+	"running": libvirt.ConnectGetAllDomainsStatsRunning,
+	"paused":  libvirt.ConnectGetAllDomainsStatsPaused,
+	"shutoff": libvirt.ConnectGetAllDomainsStatsShutoff,
+	"other":   libvirt.ConnectGetAllDomainsStatsOther,
+}
+
+var vcpuStateNames = map[int]string{
+	0: "offline",
+	1: "running",
+	2: "blocked",
+}
+
+// domainStatsParams gives access to the typed parameters of a domain stats record by
+// their field name, like "net.0.rx.bytes".
+type domainStatsParams map[string]interface{}
+
+func newDomainStatsParams(params []libvirt.TypedParam) domainStatsParams {
+	p := make(domainStatsParams, len(params))
+	for _, param := range params {
+		p[param.Field] = param.Value.I
+	}
+	return p
+}
+
+// Int returns the numeric parameter, or 0 if libvirt did not report it.
+func (p domainStatsParams) Int(field string, args ...interface{}) int {
+	switch v := p[fmt.Sprintf(field, args...)].(type) {
+	case int32:
+		return int(v)
+	case uint32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// String returns the string parameter, or an empty string if libvirt did not report it.
+func (p domainStatsParams) String(field string, args ...interface{}) string {
+	if v, ok := p[fmt.Sprintf(field, args...)].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// flattenDomainStats converts the stats of a domain, as documented in
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virConnectGetAllDomainStats,
+// to the schema of the libvirt_domain_stats data source.
+func flattenDomainStats(params []libvirt.TypedParam) map[string]interface{} {
+	p := newDomainStatsParams(params)
+
+	state, ok := domainStateNames[libvirt.DomainState(p.Int("state.state"))]
+	if !ok {
+		state = "nostate"
+	}
+
+	vcpus := make([]map[string]interface{}, 0, p.Int("vcpu.maximum"))
+	for i := 0; i < p.Int("vcpu.maximum"); i++ {
+		vcpus = append(vcpus, map[string]interface{}{
+			"state": vcpuStateNames[p.Int("vcpu.%d.state", i)],
+			"time":  p.Int("vcpu.%d.time", i),
+		})
+	}
+
+	interfaces := make([]map[string]interface{}, 0, p.Int("net.count"))
+	for i := 0; i < p.Int("net.count"); i++ {
+		interfaces = append(interfaces, map[string]interface{}{
+			"name":       p.String("net.%d.name", i),
+			"rx_bytes":   p.Int("net.%d.rx.bytes", i),
+			"rx_packets": p.Int("net.%d.rx.pkts", i),
+			"rx_errors":  p.Int("net.%d.rx.errs", i),
+			"rx_drops":   p.Int("net.%d.rx.drop", i),
+			"tx_bytes":   p.Int("net.%d.tx.bytes", i),
+			"tx_packets": p.Int("net.%d.tx.pkts", i),
+			"tx_errors":  p.Int("net.%d.tx.errs", i),
+			"tx_drops":   p.Int("net.%d.tx.drop", i),
+		})
+	}
+
+	disks := make([]map[string]interface{}, 0, p.Int("block.count"))
+	for i := 0; i < p.Int("block.count"); i++ {
+		disks = append(disks, map[string]interface{}{
+			"name":           p.String("block.%d.name", i),
+			"path":           p.String("block.%d.path", i),
+			"read_bytes":     p.Int("block.%d.rd.bytes", i),
+			"read_requests":  p.Int("block.%d.rd.reqs", i),
+			"write_bytes":    p.Int("block.%d.wr.bytes", i),
+			"write_requests": p.Int("block.%d.wr.reqs", i),
+			"allocation":     p.Int("block.%d.allocation", i),
+			"capacity":       p.Int("block.%d.capacity", i),
+			"physical":       p.Int("block.%d.physical", i),
+		})
+	}
+
+	return map[string]interface{}{
+		"state":           state,
+		"cpu_time":        p.Int("cpu.time"),
+		"balloon_current": p.Int("balloon.current"),
+		"balloon_maximum": p.Int("balloon.maximum"),
+		"vcpu_current":    p.Int("vcpu.current"),
+		"vcpu_maximum":    p.Int("vcpu.maximum"),
+		"vcpus":           vcpus,
+		"interfaces":      interfaces,
+		"disks":           disks,
+	}
+}
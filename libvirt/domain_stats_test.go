@@ -0,0 +1,78 @@
+package libvirt
+
+import (
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func testDomainStatsParam(field string, value interface{}) libvirt.TypedParam {
+	return libvirt.TypedParam{Field: field, Value: libvirt.TypedParamValue{I: value}}
+}
+
+func TestFlattenDomainStats(t *testing.T) {
+	params := []libvirt.TypedParam{
+		testDomainStatsParam("state.state", int32(libvirt.DomainRunning)),
+		testDomainStatsParam("cpu.time", uint64(123456789)),
+		testDomainStatsParam("balloon.current", uint64(524288)),
+		testDomainStatsParam("balloon.maximum", uint64(1048576)),
+		testDomainStatsParam("vcpu.current", uint32(2)),
+		testDomainStatsParam("vcpu.maximum", uint32(2)),
+		testDomainStatsParam("vcpu.0.state", int32(1)),
+		testDomainStatsParam("vcpu.0.time", uint64(1000)),
+		testDomainStatsParam("vcpu.1.state", int32(0)),
+		testDomainStatsParam("net.count", uint32(1)),
+		testDomainStatsParam("net.0.name", "vnet0"),
+		testDomainStatsParam("net.0.rx.bytes", uint64(2048)),
+		testDomainStatsParam("net.0.tx.bytes", uint64(1024)),
+		testDomainStatsParam("block.count", uint32(1)),
+		testDomainStatsParam("block.0.name", "vda"),
+		testDomainStatsParam("block.0.path", "/var/lib/libvirt/images/disk.qcow2"),
+		testDomainStatsParam("block.0.rd.bytes", uint64(4096)),
+		testDomainStatsParam("block.0.wr.reqs", uint64(7)),
+		testDomainStatsParam("block.0.allocation", uint64(200704)),
+	}
+
+	stats := flattenDomainStats(params)
+
+	if stats["state"] != "running" || stats["cpu_time"] != 123456789 || stats["balloon_current"] != 524288 {
+		t.Errorf("unexpected domain stats %v", stats)
+	}
+
+	vcpus := stats["vcpus"].([]map[string]interface{})
+	if len(vcpus) != 2 || vcpus[0]["state"] != "running" || vcpus[0]["time"] != 1000 || vcpus[1]["state"] != "offline" {
+		t.Errorf("unexpected vcpu stats %v", vcpus)
+	}
+
+	interfaces := stats["interfaces"].([]map[string]interface{})
+	if len(interfaces) != 1 || interfaces[0]["name"] != "vnet0" || interfaces[0]["rx_bytes"] != 2048 || interfaces[0]["rx_errors"] != 0 {
+		t.Errorf("unexpected interface stats %v", interfaces)
+	}
+
+	disks := stats["disks"].([]map[string]interface{})
+	if len(disks) != 1 || disks[0]["name"] != "vda" || disks[0]["write_requests"] != 7 || disks[0]["allocation"] != 200704 {
+		t.Errorf("unexpected disk stats %v", disks)
+	}
+
+	// the flattened stats fit the schema of the data source
+	d := schema.TestResourceDataRaw(t, datasourceLibvirtDomainStats().Schema, map[string]interface{}{})
+	stats["id"] = "id"
+	stats["name"] = "name"
+	if err := d.Set("domains", []map[string]interface{}{stats}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Get("domains.0.disks.0.path") != "/var/lib/libvirt/images/disk.qcow2" {
+		t.Errorf("unexpected disk path %v", d.Get("domains.0.disks.0.path"))
+	}
+}
+
+func TestFlattenDomainStatsInactive(t *testing.T) {
+	stats := flattenDomainStats([]libvirt.TypedParam{
+		testDomainStatsParam("state.state", int32(libvirt.DomainShutoff)),
+	})
+
+	if stats["state"] != "shutoff" || stats["cpu_time"] != 0 || len(stats["disks"].([]map[string]interface{})) != 0 {
+		t.Errorf("unexpected domain stats %v", stats)
+	}
+}
@@ -0,0 +1,313 @@
+package libvirt
+
+import (
+	"net"
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestFilterInterfaceAddresses(t *testing.T) {
+	var excluded []*net.IPNet
+	for _, cidr := range []string{"fe80::/10", "172.17.0.0/16"} {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		excluded = append(excluded, ipNet)
+	}
+
+	interfaces := []libvirt.DomainInterface{
+		{
+			Name:   "eth0",
+			Hwaddr: libvirt.OptString{"52:54:00:aa:bb:cc"},
+			Addrs: []libvirt.DomainIPAddr{
+				{Type: int32(libvirt.IPAddrTypeIpv4), Addr: "192.168.122.10", Prefix: 24},
+				{Type: int32(libvirt.IPAddrTypeIpv6), Addr: "fe80::5054:ff:feaa:bbcc", Prefix: 64},
+			},
+		},
+		{
+			Name:   "docker0",
+			Hwaddr: libvirt.OptString{"02:42:ac:11:00:01"},
+			Addrs: []libvirt.DomainIPAddr{
+				{Type: int32(libvirt.IPAddrTypeIpv4), Addr: "172.17.0.1", Prefix: 16},
+			},
+		},
+	}
+
+	filtered := filterInterfaceAddresses(interfaces, excluded)
+	if len(filtered) != 2 {
+		t.Fatalf("expected the interfaces to be kept, got %d", len(filtered))
+	}
+	if len(filtered[0].Addrs) != 1 || filtered[0].Addrs[0].Addr != "192.168.122.10" {
+		t.Errorf("expected only the IPv4 address of eth0 to be kept, got %+v", filtered[0].Addrs)
+	}
+	if len(filtered[1].Addrs) != 0 {
+		t.Errorf("expected the docker bridge address to be dropped, got %+v", filtered[1].Addrs)
+	}
+	if len(interfaces[0].Addrs) != 2 {
+		t.Errorf("expected the original interfaces to be left untouched")
+	}
+}
+
+func TestMergeInterfaceAddresses(t *testing.T) {
+	fromLease := []libvirt.DomainInterface{
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:aa:bb:cc"},
+			Addrs:  []libvirt.DomainIPAddr{{Addr: "192.168.122.10"}},
+		},
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:dd:ee:ff"},
+		},
+	}
+	fromArp := []libvirt.DomainInterface{
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:AA:BB:CC"},
+			Addrs:  []libvirt.DomainIPAddr{{Addr: "192.168.122.99"}},
+		},
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:dd:ee:ff"},
+			Addrs:  []libvirt.DomainIPAddr{{Addr: "10.0.0.5"}},
+		},
+	}
+
+	merged := mergeInterfaceAddresses(fromLease, fromArp)
+	var addrs []string
+	for _, iface := range merged {
+		for _, addr := range iface.Addrs {
+			addrs = append(addrs, addr.Addr)
+		}
+	}
+
+	if len(addrs) != 2 || addrs[0] != "192.168.122.10" || addrs[1] != "10.0.0.5" {
+		t.Errorf("expected the first source to win for each MAC address, got %v", addrs)
+	}
+}
+
+func TestInterfacesHaveAddresses(t *testing.T) {
+	interfaces := []libvirt.DomainInterface{
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:aa:bb:cc"},
+			Addrs:  []libvirt.DomainIPAddr{{Addr: "192.168.122.10"}},
+		},
+		{
+			Hwaddr: libvirt.OptString{"52:54:00:dd:ee:ff"},
+		},
+	}
+
+	if !interfacesHaveAddresses(interfaces, []string{"52:54:00:AA:BB:CC"}) {
+		t.Errorf("expected the interface with an address to be found")
+	}
+	if interfacesHaveAddresses(interfaces, []string{"52:54:00:AA:BB:CC", "52:54:00:DD:EE:FF"}) {
+		t.Errorf("expected the interface without an address to keep the lookup going")
+	}
+	if interfacesHaveAddresses(interfaces, []string{"52:54:00:11:22:33"}) {
+		t.Errorf("expected an unknown interface to keep the lookup going")
+	}
+}
+
+func TestAddressesMatchFamily(t *testing.T) {
+	ipv4 := libvirt.DomainIPAddr{Type: int32(libvirt.IPAddrTypeIpv4), Addr: "192.168.122.10"}
+	ipv6 := libvirt.DomainIPAddr{Type: int32(libvirt.IPAddrTypeIpv6), Addr: "2001:db8::10"}
+
+	cases := []struct {
+		addrs    []libvirt.DomainIPAddr
+		family   string
+		expected bool
+	}{
+		{nil, "any", false},
+		{[]libvirt.DomainIPAddr{ipv6}, "any", true},
+		{[]libvirt.DomainIPAddr{ipv6}, "ipv4", false},
+		{[]libvirt.DomainIPAddr{ipv4}, "ipv4", true},
+		{[]libvirt.DomainIPAddr{ipv4}, "both", false},
+		{[]libvirt.DomainIPAddr{ipv4, ipv6}, "both", true},
+		{[]libvirt.DomainIPAddr{ipv4, ipv6}, "ipv6", true},
+	}
+
+	for _, c := range cases {
+		if got := addressesMatchFamily(c.addrs, c.family); got != c.expected {
+			t.Errorf("addressesMatchFamily(%+v, %s) = %v, expected %v", c.addrs, c.family, got, c.expected)
+		}
+	}
+}
+
+func TestSetQEMUCommandline(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "test",
+		"qemu_commandline": []interface{}{
+			map[string]interface{}{
+				"args": []interface{}{"-device", "virtio-balloon"},
+				"env": []interface{}{
+					map[string]interface{}{"name": "QEMU_DEBUG", "value": "1"},
+				},
+				"capabilities": []interface{}{
+					map[string]interface{}{"del": []interface{}{"blockdev"}},
+				},
+			},
+		},
+	})
+
+	domainDef := newDomainDef()
+	// arguments of other settings are kept
+	domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{
+		Args: []libvirtxml.DomainQEMUCommandlineArg{{Value: "-fw_cfg"}, {Value: "name=opt/ignition"}},
+	}
+
+	setQEMUCommandline(d, &domainDef)
+
+	args := domainDef.QEMUCommandline.Args
+	if len(args) != 4 || args[0].Value != "-fw_cfg" || args[2].Value != "-device" || args[3].Value != "virtio-balloon" {
+		t.Errorf("unexpected arguments %v", args)
+	}
+	envs := domainDef.QEMUCommandline.Envs
+	if len(envs) != 1 || envs[0].Name != "QEMU_DEBUG" || envs[0].Value != "1" {
+		t.Errorf("unexpected environment %v", envs)
+	}
+	if domainDef.QEMUCapabilities == nil || len(domainDef.QEMUCapabilities.Add) != 0 ||
+		len(domainDef.QEMUCapabilities.Del) != 1 || domainDef.QEMUCapabilities.Del[0].Name != "blockdev" {
+		t.Errorf("unexpected capabilities %v", domainDef.QEMUCapabilities)
+	}
+
+	if diags := qemuCommandlineWarnings(d); len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Errorf("expected a tainting warning, got %v", diags)
+	}
+}
+
+func TestSetRNG(t *testing.T) {
+	t.Setenv("TF_LIBVIRT_RNG_DEV", "/dev/hwrng")
+
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "test",
+		"rng":  []interface{}{map[string]interface{}{"backend": "random"}},
+	})
+
+	// definitions without a default rng, like the lxc ones
+	domainDef := libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+	if err := setRNG(d, &domainDef); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(domainDef.Devices.RNGs) != 1 || domainDef.Devices.RNGs[0].Backend.Random.Device != "/dev/hwrng" {
+		t.Errorf("expected the default rng device, got %+v", domainDef.Devices.RNGs)
+	}
+}
+
+func TestReadDevices(t *testing.T) {
+	// as libvirt returns them, with the implicit ps2 inputs and the agent channel
+	xmlDesc := `<domain type="kvm">
+  <name>test</name>
+  <devices>
+    <channel type="unix">
+      <source mode="bind" path="/var/lib/libvirt/qemu/channel/target/domain-1-test/org.qemu.guest_agent.0"/>
+      <target type="virtio" name="org.qemu.guest_agent.0"/>
+    </channel>
+    <channel type="spicevmc">
+      <target type="virtio" name="com.redhat.spice.0"/>
+    </channel>
+    <input type="tablet" bus="usb"/>
+    <input type="mouse" bus="ps2"/>
+    <input type="keyboard" bus="ps2"/>
+    <sound model="ich9"/>
+    <watchdog model="i6300esb" action="poweroff"/>
+    <memballoon model="virtio">
+      <stats period="10"/>
+    </memballoon>
+    <rng model="virtio">
+      <rate bytes="1024" period="1000"/>
+      <backend model="random">/dev/urandom</backend>
+    </rng>
+    <vsock model="virtio">
+      <cid auto="yes" address="3"/>
+    </vsock>
+  </devices>
+</domain>`
+
+	var domainDef libvirtxml.Domain
+	if err := domainDef.Unmarshal(xmlDesc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	devices := domainDef.Devices
+
+	rng := readRNG(devices.RNGs)
+	if len(rng) != 1 || rng[0]["backend"] != "random" || rng[0]["device"] != "/dev/urandom" ||
+		rng[0]["rate_bytes"] != 1024 || rng[0]["rate_period"] != 1000 {
+		t.Errorf("unexpected rng %v", rng)
+	}
+
+	watchdog := readWatchdog(devices.Watchdogs)
+	if len(watchdog) != 1 || watchdog[0]["model"] != "i6300esb" || watchdog[0]["action"] != "poweroff" {
+		t.Errorf("unexpected watchdog %v", watchdog)
+	}
+
+	memballoon := readMemBalloon(devices.MemBalloon)
+	if len(memballoon) != 1 || memballoon[0]["model"] != "virtio" || memballoon[0]["stats_period"] != 10 {
+		t.Errorf("unexpected memballoon %v", memballoon)
+	}
+
+	vsock := readVSock(devices.VSock)
+	if len(vsock) != 1 || vsock[0]["cid"] != 3 {
+		t.Errorf("unexpected vsock %v", vsock)
+	}
+
+	sounds := readSounds(devices.Sounds)
+	if len(sounds) != 1 || sounds[0]["model"] != "ich9" {
+		t.Errorf("unexpected sounds %v", sounds)
+	}
+
+	channels := readChannels(devices.Channels)
+	if len(channels) != 1 || channels[0]["type"] != "spicevmc" || channels[0]["target_name"] != "com.redhat.spice.0" {
+		t.Errorf("expected the channels without the guest agent one, got %v", channels)
+	}
+
+	inputs := readInputs(devices.Inputs, nil)
+	if len(inputs) != 1 || inputs[0]["type"] != "tablet" || inputs[0]["bus"] != "usb" {
+		t.Errorf("expected the inputs without the implicit ones, got %v", inputs)
+	}
+	configured := []interface{}{map[string]interface{}{"type": "mouse", "bus": "ps2"}}
+	if inputs := readInputs(devices.Inputs, configured); len(inputs) != 2 || inputs[1]["type"] != "mouse" {
+		t.Errorf("expected the configured ps2 mouse to be kept, got %v", inputs)
+	}
+}
+
+func TestSetFeaturesHyperV(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "test",
+		"features": []interface{}{
+			map[string]interface{}{
+				"hyperv": []interface{}{
+					map[string]interface{}{
+						"relaxed": true,
+						"vapic":   false,
+						"stimer":  false,
+					},
+				},
+			},
+		},
+	})
+
+	domainDef := newDomainDef()
+	setFeatures(d, &domainDef)
+
+	hyperv := domainDef.Features.HyperV
+	if hyperv == nil {
+		t.Fatalf("expected Hyper-V enlightenments")
+	}
+	if hyperv.Relaxed == nil || hyperv.Relaxed.State != "on" {
+		t.Errorf("expected relaxed to be on, got %+v", hyperv.Relaxed)
+	}
+	if hyperv.VAPIC == nil || hyperv.VAPIC.State != "off" {
+		t.Errorf("expected vapic to be off, got %+v", hyperv.VAPIC)
+	}
+	if hyperv.STimer == nil || hyperv.STimer.State != "off" {
+		t.Errorf("expected stimer to be off, got %+v", hyperv.STimer)
+	}
+	// the ones not set are left to libvirt
+	if hyperv.VPIndex != nil {
+		t.Errorf("expected vpindex not to be set, got %+v", hyperv.VPIndex)
+	}
+
+	block := readFeatures(domainDef.Features)["hyperv"].([]map[string]interface{})[0]
+	if block["relaxed"] != true || block["vapic"] != false || block["stimer"] != false {
+		t.Errorf("unexpected features read back %v", block)
+	}
+}
@@ -0,0 +1,337 @@
+package libvirt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// memory elements of the domain whose value libvirt converts to KiB.
+var domainXMLMemoryElements = []string{"memory", "currentMemory", "maxMemory"}
+
+func parseDomainXMLDocument(xmlS string) (*etree.Document, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlS); err != nil {
+		return nil, fmt.Errorf("failed to parse domain XML: %w", err)
+	}
+	if doc.Root() == nil || doc.Root().Tag != "domain" {
+		return nil, fmt.Errorf("the XML document is not a libvirt domain definition")
+	}
+
+	// libvirt stores memory sizes in KiB, whatever unit was used to define them
+	for _, tag := range domainXMLMemoryElements {
+		element := doc.Root().SelectElement(tag)
+		if element == nil {
+			continue
+		}
+		unit := element.SelectAttrValue("unit", "KiB")
+		if unit == "k" {
+			unit = "K"
+		}
+		multiplier, ok := UnitsMap[unit]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(element.Text()), 10, 64)
+		if err != nil {
+			continue
+		}
+		element.SetText(strconv.FormatUint(value*multiplier/UnitsMap["KiB"], 10))
+		element.CreateAttr("unit", "KiB")
+	}
+
+	return doc, nil
+}
+
+// domainXMLSemanticallyEqual compares two domain definitions ignoring formatting,
+// attribute order and the unit used for memory sizes.
+func domainXMLSemanticallyEqual(a, b string) bool {
+	docA, err := parseDomainXMLDocument(a)
+	if err != nil {
+		return a == b
+	}
+	docB, err := parseDomainXMLDocument(b)
+	if err != nil {
+		return a == b
+	}
+	return canonicalXMLElement(docA.Root()) == canonicalXMLElement(docB.Root())
+}
+
+// domainXMLAttrMatches compares the value of an attribute from the desired definition
+// with the one stored by libvirt, which expands the machine type aliases.
+func domainXMLAttrMatches(element *etree.Element, key, desired, stored string) bool {
+	if desired == stored {
+		return true
+	}
+	if element.Tag == "type" && key == "machine" {
+		return strings.HasPrefix(stored, desired+"-") ||
+			strings.HasPrefix(stored, "pc-"+desired+"-") ||
+			(desired == "pc" && strings.HasPrefix(stored, "pc-i440fx-"))
+	}
+	return false
+}
+
+// xmlElementIsSubset tells whether everything set in desired is also set in stored.
+// Elements and attributes only present in stored, like the PCI addresses, aliases and
+// MAC addresses generated by libvirt, are ignored.
+func xmlElementIsSubset(desired, stored *etree.Element) bool {
+	if desired.FullTag() != stored.FullTag() {
+		return false
+	}
+
+	for _, attr := range desired.Attr {
+		storedAttr := stored.SelectAttr(attr.FullKey())
+		if storedAttr == nil || !domainXMLAttrMatches(desired, attr.FullKey(), attr.Value, storedAttr.Value) {
+			return false
+		}
+	}
+
+	if text := strings.TrimSpace(desired.Text()); text != "" && text != strings.TrimSpace(stored.Text()) {
+		return false
+	}
+
+	storedChildren := stored.ChildElements()
+	used := make([]bool, len(storedChildren))
+	for _, desiredChild := range desired.ChildElements() {
+		found := false
+		for i, storedChild := range storedChildren {
+			if !used[i] && xmlElementIsSubset(desiredChild, storedChild) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// domainXMLIsSubset tells whether the domain definition stored by libvirt still has
+// everything from the desired one.
+func domainXMLIsSubset(desired, stored string) (bool, error) {
+	desiredDoc, err := parseDomainXMLDocument(desired)
+	if err != nil {
+		return false, err
+	}
+	storedDoc, err := parseDomainXMLDocument(stored)
+	if err != nil {
+		return false, err
+	}
+	return xmlElementIsSubset(desiredDoc.Root(), storedDoc.Root()), nil
+}
+
+// domainXMLWithUUID sets the UUID of the domain definition, so that redefining it
+// updates the existing domain.
+func domainXMLWithUUID(xmlS string, uuid string) (string, error) {
+	doc, err := parseDomainXMLDocument(xmlS)
+	if err != nil {
+		return "", err
+	}
+
+	uuidElement := doc.Root().SelectElement("uuid")
+	if uuidElement == nil {
+		uuidElement = doc.Root().CreateElement("uuid")
+	}
+	uuidElement.SetText(uuid)
+
+	return doc.WriteToString()
+}
+
+// domainXMLDeviceKey identifies a device across two definitions of a domain. Devices
+// without a natural identity are identified by their whole definition.
+func domainXMLDeviceKey(device *etree.Element) string {
+	var identity string
+	switch device.Tag {
+	case "disk", "filesystem":
+		if target := device.SelectElement("target"); target != nil {
+			identity = target.SelectAttrValue("dev", target.SelectAttrValue("dir", ""))
+		}
+	case "interface":
+		if mac := device.SelectElement("mac"); mac != nil {
+			identity = strings.ToLower(mac.SelectAttrValue("address", ""))
+		}
+	case "controller":
+		identity = device.SelectAttrValue("type", "") + "/" + device.SelectAttrValue("index", "")
+	}
+
+	if identity == "" {
+		return device.Tag + ":" + canonicalXMLElement(device)
+	}
+	return device.Tag + "=" + identity
+}
+
+type domainXMLDeviceChanges struct {
+	Detach []string
+	Attach []string
+	Update []string
+}
+
+// devices libvirt adds on its own and that can't be unplugged from a running domain.
+var domainXMLImplicitDevices = map[string]bool{
+	"emulator":   true,
+	"controller": true,
+	"memballoon": true,
+	"input":      true,
+	"video":      true,
+	"console":    true,
+	"audio":      true,
+	"panic":      true,
+}
+
+func domainXMLDevices(doc *etree.Document) []*etree.Element {
+	if devicesElement := doc.Root().SelectElement("devices"); devicesElement != nil {
+		return devicesElement.ChildElements()
+	}
+	return nil
+}
+
+func xmlElementString(element *etree.Element) (string, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(element.Copy())
+	return doc.WriteToString()
+}
+
+// diffDomainXMLDevices returns the device definitions to detach, attach and update to go
+// from the old domain definition to the new one.
+//
+// A new device matches an old one if everything it sets is also set in the old device,
+// so details filled in by libvirt, like addresses and generated MAC addresses, do not
+// count as changes.
+func diffDomainXMLDevices(oldXML, newXML string) (domainXMLDeviceChanges, error) {
+	var changes domainXMLDeviceChanges
+
+	oldDoc, err := parseDomainXMLDocument(oldXML)
+	if err != nil {
+		return changes, err
+	}
+	newDoc, err := parseDomainXMLDocument(newXML)
+	if err != nil {
+		return changes, err
+	}
+
+	oldDevices := domainXMLDevices(oldDoc)
+	newDevices := domainXMLDevices(newDoc)
+	matched := make([]bool, len(oldDevices))
+
+	findOldDevice := func(match func(oldDevice *etree.Element) bool) bool {
+		for i, oldDevice := range oldDevices {
+			if !matched[i] && match(oldDevice) {
+				matched[i] = true
+				return true
+			}
+		}
+		return false
+	}
+
+	var updated []*etree.Element
+	var attached []*etree.Element
+	for _, newDevice := range newDevices {
+		if newDevice.Tag == "emulator" {
+			continue
+		}
+		if findOldDevice(func(oldDevice *etree.Element) bool { return xmlElementIsSubset(newDevice, oldDevice) }) {
+			continue
+		}
+
+		key := domainXMLDeviceKey(newDevice)
+		if findOldDevice(func(oldDevice *etree.Element) bool { return domainXMLDeviceKey(oldDevice) == key }) {
+			updated = append(updated, newDevice)
+		} else {
+			attached = append(attached, newDevice)
+		}
+	}
+
+	for i, oldDevice := range oldDevices {
+		if matched[i] || domainXMLImplicitDevices[oldDevice.Tag] {
+			continue
+		}
+		device, err := xmlElementString(oldDevice)
+		if err != nil {
+			return changes, err
+		}
+		changes.Detach = append(changes.Detach, device)
+	}
+
+	for _, newDevice := range attached {
+		device, err := xmlElementString(newDevice)
+		if err != nil {
+			return changes, err
+		}
+		changes.Attach = append(changes.Attach, device)
+	}
+
+	for _, newDevice := range updated {
+		device, err := xmlElementString(newDevice)
+		if err != nil {
+			return changes, err
+		}
+		changes.Update = append(changes.Update, device)
+	}
+
+	return changes, nil
+}
+
+// mergeDomainXMLIdentities copies the MAC addresses and the device addresses generated
+// by libvirt in the stored definition to the devices of the new one not setting them.
+// Redefining the domain then keeps them, and with them the names of the network
+// interfaces in the guest and their DHCP leases.
+//
+// The devices are matched like in diffDomainXMLDevices: unchanged ones first, then
+// the ones with the same identity, and the interfaces without MAC address in order.
+func mergeDomainXMLIdentities(newXML, storedXML string) (string, error) {
+	newDoc, err := parseDomainXMLDocument(newXML)
+	if err != nil {
+		return "", err
+	}
+	storedDoc, err := parseDomainXMLDocument(storedXML)
+	if err != nil {
+		return "", err
+	}
+
+	newDevices := domainXMLDevices(newDoc)
+	storedDevices := domainXMLDevices(storedDoc)
+	matches := make([]*etree.Element, len(newDevices))
+	matched := make([]bool, len(storedDevices))
+
+	match := func(same func(newDevice, storedDevice *etree.Element) bool) {
+		for i, newDevice := range newDevices {
+			if matches[i] != nil || newDevice.Tag == "emulator" {
+				continue
+			}
+			for j, storedDevice := range storedDevices {
+				if !matched[j] && same(newDevice, storedDevice) {
+					matches[i] = storedDevice
+					matched[j] = true
+					break
+				}
+			}
+		}
+	}
+	match(xmlElementIsSubset)
+	match(func(newDevice, storedDevice *etree.Element) bool {
+		return domainXMLDeviceKey(newDevice) == domainXMLDeviceKey(storedDevice)
+	})
+	match(func(newDevice, storedDevice *etree.Element) bool {
+		return newDevice.Tag == "interface" && storedDevice.Tag == "interface" && newDevice.SelectElement("mac") == nil
+	})
+
+	for i, newDevice := range newDevices {
+		if matches[i] == nil {
+			continue
+		}
+		for _, tag := range []string{"mac", "address"} {
+			if newDevice.SelectElement(tag) != nil {
+				continue
+			}
+			if generated := matches[i].SelectElement(tag); generated != nil {
+				newDevice.AddChild(generated.Copy())
+			}
+		}
+	}
+
+	return newDoc.WriteToString()
+}
@@ -0,0 +1,162 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDomainXML = `<domain type="kvm">
+  <name>test</name>
+  <memory unit="MiB">512</memory>
+  <os>
+    <type arch="x86_64" machine="q35">hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <source file="/var/lib/libvirt/images/test.qcow2"/>
+      <target dev="vda" bus="virtio"/>
+    </disk>
+    <interface type="network">
+      <source network="default"/>
+    </interface>
+  </devices>
+</domain>`
+
+const testStoredDomainXML = `<domain type='kvm'>
+  <name>test</name>
+  <uuid>0b6e4b1c-2d6f-4a8e-9d43-6b2b5b2e8f10</uuid>
+  <memory unit='KiB'>524288</memory>
+  <currentMemory unit='KiB'>524288</currentMemory>
+  <os>
+    <type arch='x86_64' machine='pc-q35-8.2'>hvm</type>
+  </os>
+  <devices>
+    <emulator>/usr/bin/qemu-system-x86_64</emulator>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='raw'/>
+      <source file='/var/lib/libvirt/images/test.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+      <address type='pci' domain='0x0000' bus='0x04' slot='0x00' function='0x0'/>
+    </disk>
+    <controller type='usb' index='0' model='qemu-xhci'/>
+    <interface type='network'>
+      <mac address='52:54:00:12:34:56'/>
+      <source network='default'/>
+      <model type='virtio'/>
+    </interface>
+    <input type='mouse' bus='ps2'/>
+    <memballoon model='virtio'/>
+  </devices>
+</domain>`
+
+func TestDomainXMLSemanticallyEqual(t *testing.T) {
+	reformatted := strings.ReplaceAll(testDomainXML, `<memory unit="MiB">512</memory>`, `<memory unit='KiB'>524288</memory>`)
+	reformatted = strings.ReplaceAll(reformatted, `<target dev="vda" bus="virtio"/>`, `<target bus='virtio'   dev='vda'></target>`)
+	if !domainXMLSemanticallyEqual(testDomainXML, reformatted) {
+		t.Errorf("expected formatting and memory units to be ignored")
+	}
+
+	changed := strings.ReplaceAll(testDomainXML, `bus="virtio"`, `bus="sata"`)
+	if domainXMLSemanticallyEqual(testDomainXML, changed) {
+		t.Errorf("expected a different bus to be a change")
+	}
+}
+
+func TestDomainXMLIsSubset(t *testing.T) {
+	isSubset, err := domainXMLIsSubset(testDomainXML, testStoredDomainXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !isSubset {
+		t.Errorf("expected the details added by libvirt to be ignored")
+	}
+
+	drifted := strings.ReplaceAll(testStoredDomainXML, `<source network='default'/>`, `<source network='other'/>`)
+	isSubset, err = domainXMLIsSubset(testDomainXML, drifted)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if isSubset {
+		t.Errorf("expected a changed network to be detected")
+	}
+}
+
+func TestDomainXMLWithUUID(t *testing.T) {
+	withUUID, err := domainXMLWithUUID(testDomainXML, "0b6e4b1c-2d6f-4a8e-9d43-6b2b5b2e8f10")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(withUUID, "<uuid>0b6e4b1c-2d6f-4a8e-9d43-6b2b5b2e8f10</uuid>") {
+		t.Errorf("expected the UUID to be set:\n%s", withUUID)
+	}
+
+	if _, err := domainXMLWithUUID("<network/>", "0b6e4b1c-2d6f-4a8e-9d43-6b2b5b2e8f10"); err == nil {
+		t.Errorf("expected an error for a document which is not a domain")
+	}
+}
+
+func TestDiffDomainXMLDevices(t *testing.T) {
+	newXML := strings.ReplaceAll(testDomainXML, `<target dev="vda" bus="virtio"/>`, `<target dev="vda" bus="virtio"/>
+      <readonly/>`)
+	newXML = strings.ReplaceAll(newXML, `</devices>`, `<watchdog model="i6300esb"/></devices>`)
+
+	// libvirt additions in the stored definition are not changes
+	changes, err := diffDomainXMLDevices(testStoredDomainXML, newXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(changes.Detach) != 0 {
+		t.Errorf("expected nothing to detach, got %v", changes.Detach)
+	}
+	if len(changes.Attach) != 1 || !strings.Contains(changes.Attach[0], "i6300esb") {
+		t.Errorf("expected the watchdog to be attached, got %v", changes.Attach)
+	}
+	if len(changes.Update) != 1 || !strings.Contains(changes.Update[0], "<readonly/>") {
+		t.Errorf("expected the disk to be updated, got %v", changes.Update)
+	}
+
+	withoutInterface := strings.ReplaceAll(testDomainXML, `<interface type="network">
+      <source network="default"/>
+    </interface>`, "")
+	changes, err = diffDomainXMLDevices(testDomainXML, withoutInterface)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes.Detach) != 1 || !strings.Contains(changes.Detach[0], "<interface") {
+		t.Errorf("expected the interface to be detached, got %v", changes.Detach)
+	}
+}
+
+func TestMergeDomainXMLIdentities(t *testing.T) {
+	newXML := strings.ReplaceAll(testDomainXML, `<source network="default"/>`, `<source network="default"/>
+      <model type="e1000"/>`)
+	newXML = strings.ReplaceAll(newXML, `</devices>`, `<interface type="bridge"><source bridge="br0"/></interface></devices>`)
+
+	merged, err := mergeDomainXMLIdentities(newXML, testStoredDomainXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strings.Count(merged, "<mac ") != 1 || !strings.Contains(merged, `<mac address="52:54:00:12:34:56"/>`) {
+		t.Errorf("expected the MAC address to be kept only for the existing interface:\n%s", merged)
+	}
+	if !strings.Contains(merged, `<address type="pci" domain="0x0000" bus="0x04" slot="0x00" function="0x0"/>`) {
+		t.Errorf("expected the address of the disk to be kept:\n%s", merged)
+	}
+
+	// against the live definition, only the changed interface is updated
+	changes, err := diffDomainXMLDevices(testStoredDomainXML, merged)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes.Detach) != 0 {
+		t.Errorf("expected nothing to detach, got %v", changes.Detach)
+	}
+	if len(changes.Update) != 1 || !strings.Contains(changes.Update[0], "e1000") {
+		t.Errorf("expected the interface to be updated, got %v", changes.Update)
+	}
+	if len(changes.Attach) != 1 || !strings.Contains(changes.Attach[0], "br0") {
+		t.Errorf("expected the bridge interface to be attached, got %v", changes.Attach)
+	}
+}
@@ -0,0 +1,260 @@
+package libvirt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"libvirt.org/go/libvirtxml"
+)
+
+// downloadCache keeps the images downloaded from http sources, shared by the volumes
+// using the same source and across runs. The images are stored by the sha256 of their
+// content, and indexed by their url:
+//
+//	<dir>/blobs/sha256-<checksum>
+//	<dir>/urls/<sha256 of the url>.json
+//	<dir>/urls/<sha256 of the url>.lock
+type downloadCache struct {
+	dir string
+}
+
+// downloadCacheEntry is what is known of the last download of an url.
+type downloadCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Digest       string `json:"digest"`
+}
+
+func newDownloadCache(dir string) (*downloadCache, error) {
+	for _, subdir := range []string{"blobs", "urls"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating download cache directory: %w", err)
+		}
+	}
+	return &downloadCache{dir: dir}, nil
+}
+
+func (c *downloadCache) blobPath(digest string) string {
+	return filepath.Join(c.dir, "blobs", strings.Replace(digest, ":", "-", 1))
+}
+
+func (c *downloadCache) urlPath(url string, ext string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, "urls", hex.EncodeToString(sum[:])+ext)
+}
+
+func (c *downloadCache) readEntry(url string) (*downloadCacheEntry, error) {
+	data, err := os.ReadFile(c.urlPath(url, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entry downloadCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("[WARN] Ignoring corrupted download cache entry for %s: %s", url, err)
+		return nil, nil
+	}
+	if _, err := os.Stat(c.blobPath(entry.Digest)); err != nil {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (c *downloadCache) writeEntry(entry downloadCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.urlPath(entry.URL, ".json"), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomic writes a file through a temporary one, so that readers never see
+// it partially written.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get returns the path of the cached content of img, downloading it if it is not
+// cached or changed since. When the sha256 digest of the image is already known, as
+// "sha256:<checksum>", the image is used from the cache without checking the url.
+func (c *downloadCache) Get(img *httpImage, digest string) (string, error) {
+	url := img.url.String()
+
+	if strings.HasPrefix(digest, "sha256:") {
+		if _, err := os.Stat(c.blobPath(digest)); err == nil {
+			log.Printf("[DEBUG] Using cached %s for %s", digest, url)
+			return c.blobPath(digest), nil
+		}
+	}
+
+	// only one download of an url at a time, even from other terraform runs
+	unlock, err := lockFile(c.urlPath(url, ".lock"))
+	if err != nil {
+		return "", fmt.Errorf("error locking download cache for %s: %w", url, err)
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			log.Printf("[WARN] Could not unlock download cache for %s: %s", url, err)
+		}
+	}()
+
+	entry, err := c.readEntry(url)
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{}
+	if entry != nil {
+		if entry.ETag != "" {
+			header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	response, err := img.get(header)
+	if err != nil {
+		if entry != nil {
+			log.Printf("[WARN] Could not check %s, using the cached image: %s", url, err)
+			return c.blobPath(entry.Digest), nil
+		}
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if entry != nil && response.StatusCode == http.StatusNotModified {
+		log.Printf("[DEBUG] Using cached %s for %s", entry.Digest, url)
+		return c.blobPath(entry.Digest), nil
+	}
+
+	log.Printf("[INFO] Downloading %s to the download cache", url)
+	hash := sha256.New()
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "blobs"), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), response.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while downloading %s: %w", url, err)
+	}
+
+	newEntry := downloadCacheEntry{
+		URL:          url,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Digest:       "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+	}
+	if err := os.Rename(tmp.Name(), c.blobPath(newEntry.Digest)); err != nil {
+		return "", err
+	}
+	if err := c.writeEntry(newEntry); err != nil {
+		return "", err
+	}
+	return c.blobPath(newEntry.Digest), nil
+}
+
+// cachedImage is an http image read from the download cache.
+type cachedImage struct {
+	image  *httpImage
+	cache  *downloadCache
+	digest string
+	local  *localImage
+}
+
+func (i *cachedImage) String() string {
+	return i.image.String()
+}
+
+// fetch returns the image in the cache, downloading it on first use.
+func (i *cachedImage) fetch() (*localImage, error) {
+	if i.local == nil {
+		path, err := i.cache.Get(i.image, i.digest)
+		if err != nil {
+			return nil, err
+		}
+		i.local = &localImage{path: path}
+	}
+	return i.local, nil
+}
+
+func (i *cachedImage) Size() (uint64, error) {
+	local, err := i.fetch()
+	if err != nil {
+		return 0, err
+	}
+	return local.Size()
+}
+
+func (i *cachedImage) Format() (string, error) {
+	local, err := i.fetch()
+	if err != nil {
+		return "", err
+	}
+	return local.Format()
+}
+
+func (i *cachedImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	local, err := i.fetch()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(local.path)
+	if err != nil {
+		return fmt.Errorf("error while opening %s: %w", local.path, err)
+	}
+	defer file.Close()
+	return uploader(file)
+}
+
+// newCachedImage reads the http images from the cache. The digest of the image, when
+// known, allows using the cached image without checking its url.
+func newCachedImage(img image, cache *downloadCache, digest string) image {
+	if cache == nil {
+		return img
+	}
+	switch i := img.(type) {
+	case *httpImage:
+		return &cachedImage{image: i, cache: cache, digest: digest}
+	case *compressedImage:
+		if remote, ok := i.image.(*httpImage); ok {
+			return &compressedImage{image: &cachedImage{image: remote, cache: cache, digest: digest}, compression: i.compression}
+		}
+	}
+	return img
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package libvirt
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// wait time between attempts to take a lock.
+const lockRetryWait = 500 * time.Millisecond
+
+// lockFile takes an exclusive lock on path, waiting for other processes holding it.
+// The lock is the existence of the file, which is left behind if the process dies
+// while holding it.
+func lockFile(path string) (func() error, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		time.Sleep(lockRetryWait)
+	}
+}
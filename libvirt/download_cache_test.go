@@ -0,0 +1,115 @@
+package libvirt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCache(t *testing.T) {
+	content := []byte("this is a qcow image... well, it is not")
+	var version, requests, downloads int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		etag := fmt.Sprintf(`"v%d"`, atomic.LoadInt32(&version))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("ETag", etag)
+		w.Write(append(content, byte('0'+atomic.LoadInt32(&version))))
+	}))
+	defer server.Close()
+
+	cache, err := newDownloadCache(t.TempDir())
+	require.NoError(t, err)
+
+	imageURL, err := url.Parse(server.URL + "/image.qcow2")
+	require.NoError(t, err)
+
+	// parallel volumes download the image only once
+	var wg sync.WaitGroup
+	paths := make([]string, 10)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path, err := cache.Get(&httpImage{url: imageURL}, "")
+			assert.NoError(t, err)
+			paths[i] = path
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	for _, path := range paths {
+		assert.Equal(t, paths[0], path)
+	}
+
+	cached, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	assert.Equal(t, append(content, '0'), cached)
+
+	// with a known digest, the url is not checked
+	sum := sha256.Sum256(cached)
+	requestsBefore := atomic.LoadInt32(&requests)
+	path, err := cache.Get(&httpImage{url: imageURL}, "sha256:"+hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	assert.Equal(t, paths[0], path)
+	assert.Equal(t, requestsBefore, atomic.LoadInt32(&requests))
+
+	// the image is downloaded again once it changes
+	atomic.StoreInt32(&version, 1)
+	path, err = cache.Get(&httpImage{url: imageURL}, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, paths[0], path)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&downloads))
+}
+
+func TestCachedImage(t *testing.T) {
+	content, err := os.ReadFile("testdata/test.qcow2")
+	require.NoError(t, err)
+
+	var downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cache, err := newDownloadCache(t.TempDir())
+	require.NoError(t, err)
+
+	img, err := newImage(server.URL + "/image")
+	require.NoError(t, err)
+	img = newCachedImage(img, cache, "")
+
+	format, err := img.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "qcow2", format)
+
+	size, err := img.Size()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(content)), size)
+
+	var imported bytes.Buffer
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume()))
+	assert.Equal(t, content, imported.Bytes())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+}
@@ -34,7 +34,13 @@ func newDefNetworkFromXML(s string) (libvirtxml.Network, error) {
 }
 
 func getXMLNetworkDefFromLibvirt(virConn *libvirt.Libvirt, network libvirt.Network) (libvirtxml.Network, error) {
-	networkXMLDesc, err := virConn.NetworkGetXMLDesc(network, 0)
+	return getXMLNetworkDefFromLibvirtFlags(virConn, network, 0)
+}
+
+// getXMLNetworkDefFromLibvirtFlags is like getXMLNetworkDefFromLibvirt, with flags to
+// get for example the inactive definition.
+func getXMLNetworkDefFromLibvirtFlags(virConn *libvirt.Libvirt, network libvirt.Network, flags uint32) (libvirtxml.Network, error) {
+	networkXMLDesc, err := virConn.NetworkGetXMLDesc(network, flags)
 	if err != nil {
 		return libvirtxml.Network{}, fmt.Errorf("error retrieving libvirt network XML description: %w", err)
 	}
@@ -16,11 +16,20 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("LIBVIRT_DEFAULT_URI", nil),
 				Description: "libvirt connection URI for operations. See https://libvirt.org/uri.html",
 			},
+			"download_cache_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("LIBVIRT_DOWNLOAD_CACHE_DIR", ""),
+				Description: "Directory where the images of http volume sources are cached, shared by the volumes and across runs",
+			},
+			"source_http": sourceHTTPSchema(),
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
 			"libvirt_domain":         resourceLibvirtDomain(),
+			"libvirt_domain_xml":     resourceLibvirtDomainXML(),
 			"libvirt_volume":         resourceLibvirtVolume(),
+			"libvirt_volume_export":  resourceLibvirtVolumeExport(),
 			"libvirt_network":        resourceLibvirtNetwork(),
 			"libvirt_pool":           resourceLibvirtPool(),
 			"libvirt_cloudinit_disk": resourceCloudInitDisk(),
@@ -29,9 +38,13 @@ func Provider() *schema.Provider {
 		},
 
 		DataSourcesMap: map[string]*schema.Resource{
+			"libvirt_domain_console_output":            datasourceLibvirtDomainConsoleOutput(),
+			"libvirt_domain_stats":                     datasourceLibvirtDomainStats(),
+			"libvirt_domains":                          datasourceLibvirtDomains(),
 			"libvirt_network_dns_host_template":        datasourceLibvirtNetworkDNSHostTemplate(),
 			"libvirt_network_dns_srv_template":         datasourceLibvirtNetworkDNSSRVTemplate(),
 			"libvirt_network_dnsmasq_options_template": datasourceLibvirtNetworkDnsmasqOptionsTemplate(),
+			"libvirt_networks":                         datasourceLibvirtNetworks(),
 			"libvirt_node_info":                        datasourceLibvirtNodeInfo(),
 			"libvirt_node_device_info":                 datasourceLibvirtNodeDeviceInfo(),
 			"libvirt_node_devices":                     datasourceLibvirtNodeDevices(),
@@ -59,7 +72,9 @@ func CleanupLibvirtConnections() {
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	config := Config{
-		URI: d.Get("uri").(string),
+		URI:              d.Get("uri").(string),
+		DownloadCacheDir: d.Get("download_cache_dir").(string),
+		SourceHTTP:       sourceHTTPSettings(d, "source_http"),
 	}
 	log.Printf("[DEBUG] Configuring provider for '%s': %v", config.URI, d)
 
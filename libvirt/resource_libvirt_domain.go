@@ -29,6 +29,10 @@ func init() {
 	spew.Config.Indent = "\t"
 }
 
+func resourceLibvirtDomainCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, meta interface{}) error {
+	return customizeXMLDriftDiff(diff)
+}
+
 func resourceLibvirtDomain() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceLibvirtDomainCreate,
@@ -38,6 +42,7 @@ func resourceLibvirtDomain() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
+		CustomizeDiff: resourceLibvirtDomainCustomizeDiff,
 		Timeouts: &schema.ResourceTimeout{
 			//nolint:mnd
 			Create: schema.DefaultTimeout(5 * time.Minute),
@@ -58,6 +63,24 @@ func resourceLibvirtDomain() *schema.Resource {
 				Optional: true,
 				ForceNew: false,
 			},
+			"tags":       tagsSchema(),
+			"clone_from": cloneFromSchema(),
+			"init": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"init_args": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"root_filesystem": rootFilesystemSchema(),
+			"idmap":           idmapSchema(),
 			"vcpu": {
 				Type:     schema.TypeInt,
 				Optional: true,
@@ -341,6 +364,16 @@ func resourceLibvirtDomain() *schema.Resource {
 							Optional: true,
 							ForceNew: true,
 						},
+						"log_file": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"log_append": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+						},
 					},
 				},
 			},
@@ -410,14 +443,35 @@ func resourceLibvirtDomain() *schema.Resource {
 				ForceNew: false,
 			},
 			"cmdline": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				Required:      false,
+				ForceNew:      true,
+				Deprecated:    "use cmdline_args instead",
+				ConflictsWith: []string{"cmdline_args"},
+				Elem: &schema.Schema{
+					Type: schema.TypeMap,
+				},
+			},
+			"cmdline_args": {
 				Type:     schema.TypeList,
 				Optional: true,
-				Required: false,
 				ForceNew: true,
 				Elem: &schema.Schema{
-					Type: schema.TypeMap,
+					Type: schema.TypeString,
 				},
 			},
+			"dtb": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"uki": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"kernel", "initrd"},
+			},
 			"qemu_agent": {
 				Type:     schema.TypeBool,
 				Optional: true,
@@ -425,6 +479,30 @@ func resourceLibvirtDomain() *schema.Resource {
 				Default:  false,
 				ForceNew: false,
 			},
+			"address_sources": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"wait_for_address_family": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "any",
+			},
+			"exclude_link_local_addresses": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"exclude_address_ranges": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
 			"tpm": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -466,22 +544,434 @@ func resourceLibvirtDomain() *schema.Resource {
 					},
 				},
 			},
-			"xml": {
+			"rng": {
 				Type:     schema.TypeList,
 				Optional: true,
+				Computed: true,
+				ForceNew: true,
 				MaxItems: 1,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
-						"xslt": {
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "virtio",
+						},
+						"backend": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "random",
+						},
+						"device": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"rate_bytes": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: true,
+						},
+						"rate_period": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"watchdog": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"model": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"action": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "reset",
+						},
+					},
+				},
+			},
+			"memballoon": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "virtio",
+						},
+						"stats_period": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"vsock": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"cid": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"input": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"bus": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"sound": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"model": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"channel": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+						"target_name": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"source_path": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+					},
+				},
+			},
+			"qemu_commandline": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"args": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"env": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:     schema.TypeString,
+										Required: true,
+										ForceNew: true,
+									},
+									"value": {
+										Type:     schema.TypeString,
+										Optional: true,
+										ForceNew: true,
+									},
+								},
+							},
+						},
+						"capabilities": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"add": {
+										Type:     schema.TypeList,
+										Optional: true,
+										ForceNew: true,
+										Elem: &schema.Schema{
+											Type: schema.TypeString,
+										},
+									},
+									"del": {
+										Type:     schema.TypeList,
+										Optional: true,
+										ForceNew: true,
+										Elem: &schema.Schema{
+											Type: schema.TypeString,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"on_poweroff": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"on_reboot": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"on_crash": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"on_lockfailure": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"clock": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"offset": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+							Default:  "utc",
+						},
+						"timezone": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						"adjustment": {
 							Type:     schema.TypeString,
 							Optional: true,
 							ForceNew: true,
 						},
+						"timer": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:     schema.TypeString,
+										Required: true,
+										ForceNew: true,
+									},
+									"tickpolicy": {
+										Type:     schema.TypeString,
+										Optional: true,
+										ForceNew: true,
+									},
+									"track": {
+										Type:     schema.TypeString,
+										Optional: true,
+										ForceNew: true,
+									},
+									"present": {
+										Type:     schema.TypeBool,
+										Optional: true,
+										ForceNew: true,
+										Default:  true,
+									},
+								},
+							},
+						},
 					},
 				},
 			},
+			"features": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"acpi": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+							Default:  true,
+						},
+						"apic": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+							Default:  true,
+						},
+						"pae": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+							Default:  true,
+						},
+						"smm": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"vmport": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"ioapic": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+							ForceNew: true,
+						},
+						"hyperv": {
+							Type:     schema.TypeList,
+							Optional: true,
+							ForceNew: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: hypervFeaturesSchema(),
+							},
+						},
+					},
+				},
+			},
+			"xml_definition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"xml_definition_hide_runtime": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"xml_drift": xmlDriftSchema(),
+			"xml": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"xslt": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							ForceNew:         true,
+							DiffSuppressFunc: xsltDiffSupressFunc,
+						},
+						"patch": xmlPatchSchema(),
+						"transformed_paths": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"transformed_hash": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// hypervFeaturesSchema returns the schema of the Hyper-V enlightenments
+// block: one boolean per on/off enlightenment plus the ones taking a value.
+func hypervFeaturesSchema() map[string]*schema.Schema {
+	s := map[string]*schema.Schema{
+		"spinlocks": {
+			Type:     schema.TypeInt,
+			Optional: true,
+			ForceNew: true,
+		},
+		"vendor_id": {
+			Type:     schema.TypeString,
+			Optional: true,
+			ForceNew: true,
+		},
+		"stimer": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			ForceNew: true,
 		},
 	}
+	for name := range hypervFeatureStates(&libvirtxml.DomainFeatureHyperV{}) {
+		s[name] = &schema.Schema{
+			Type:     schema.TypeBool,
+			Optional: true,
+			ForceNew: true,
+		}
+	}
+	return s
 }
 
 func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -513,13 +1003,28 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	}
 	domainDef.Description = d.Get("description").(string)
 
-	domainDef.OS.Kernel = d.Get("kernel").(string)
-	domainDef.OS.Initrd = d.Get("initrd").(string)
+	if tags := d.Get("tags").(map[string]interface{}); len(tags) > 0 {
+		metadataXML, err := setTagsMetadata("", tags)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		domainDef.Metadata = &libvirtxml.DomainMetadata{XML: metadataXML}
+	}
+
+	if isLXCDomain(d) {
+		if err := checkLXCDomain(d); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if err := setBootFiles(d, &domainDef, virConn); err != nil {
+		return diag.FromErr(err)
+	}
 	domainDef.OS.Type.Arch = d.Get("arch").(string)
 
 	domainDef.Devices.Emulator = d.Get("emulator").(string)
 
-	if v := os.Getenv("TERRAFORM_LIBVIRT_TEST_DOMAIN_TYPE"); v != "" {
+	if v := os.Getenv("TERRAFORM_LIBVIRT_TEST_DOMAIN_TYPE"); v != "" && !isLXCDomain(d) {
 		domainDef.Type = v
 	} else {
 		domainDef.Type = d.Get("type").(string)
@@ -544,11 +1049,37 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	setFirmware(d, &domainDef)
 	setBootDevices(d, &domainDef)
 	setTPMs(d, &domainDef)
+	setLifecycleActions(d, &domainDef)
+
+	if err := setRNG(d, &domainDef); err != nil {
+		return diag.FromErr(err)
+	}
+
+	setWatchdog(d, &domainDef)
+	setMemBalloon(d, &domainDef)
+	setVSock(d, &domainDef)
+	setInputs(d, &domainDef)
+	setSounds(d, &domainDef)
+
+	if err := setChannels(d, &domainDef); err != nil {
+		return diag.FromErr(err)
+	}
+
+	setClock(d, &domainDef)
+	setFeatures(d, &domainDef)
 
 	if err := setCoreOSIgnition(d, &domainDef, arch); err != nil {
 		return diag.FromErr(err)
 	}
 
+	setQEMUCommandline(d, &domainDef)
+
+	if isLXCDomain(d) {
+		if err := setLXC(d, &domainDef, virConn); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
 	if err := setDisks(d, &domainDef, virConn); err != nil {
 		return diag.FromErr(err)
 	}
@@ -568,6 +1099,18 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		return diag.FromErr(err)
 	}
 
+	domainDefined := false
+	if _, ok := d.GetOk("clone_from.0.domain_id"); ok {
+		if domainDef, err = cloneDomain(ctx, d, meta.(*Client), domainDef); err != nil {
+			return diag.FromErr(err)
+		}
+		defer func() {
+			if !domainDefined {
+				deleteClonedVolumes(ctx, meta.(*Client), clonedVolumeIDs(d))
+			}
+		}()
+	}
+
 	connectURI, err := virConn.ConnectGetUri()
 	if err != nil {
 		return diag.Errorf("error retrieving libvirt connection URI: %s", err)
@@ -578,17 +1121,41 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	if err != nil {
 		return diag.Errorf("error serializing libvirt domain: %s", err)
 	}
+	data, err = setOnLockFailure(data, d.Get("on_lockfailure").(string))
+	if err != nil {
+		return diag.Errorf("error serializing libvirt domain: %s", err)
+	}
+	data, err = setQEMUNamespacePrefix(data)
+	if err != nil {
+		return diag.Errorf("error serializing libvirt domain: %s", err)
+	}
 	log.Printf("[DEBUG] Generated XML for libvirt domain:\n%s", data)
 
+	generatedData := data
 	data, err = transformResourceXML(data, d)
 	if err != nil {
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
+	if err := setTransformedXMLPaths(d, generatedData, data); err != nil {
+		return diag.Errorf("error comparing generated and transformed XML: %s", err)
+	}
+	d.Set("xml_definition", data)
 
 	domain, err := virConn.DomainDefineXML(data)
 	if err != nil {
 		return diag.Errorf("error defining libvirt domain: %s", err)
 	}
+	domainDefined = true
+
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+		}
+		if err := setTransformedXMLHash(d, xmlDesc); err != nil {
+			return diag.Errorf("error hashing libvirt domain XML description: %s", err)
+		}
+	}
 
 	if autostart, ok := d.GetOk("autostart"); ok {
 		var autostartInt int32
@@ -611,7 +1178,23 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 	log.Printf("[INFO] Domain ID: %s", d.Id())
 
 	if len(waitForLeases) > 0 {
-		if err := waitForStateDomainLeaseDone(ctx, virConn, domain, waitForLeases, d); err != nil {
+		// keep the boot output around when console logging is configured, it is the best
+		// hint when the guest never gets an address. Each capture needs its own connection.
+		var consoleOutput string
+		var capture *consoleCapture
+		if isConsoleLogged(d) {
+			if capture, _, err = startConsoleCapture(meta.(*Client).uri, domain, ""); err != nil {
+				log.Printf("[DEBUG] could not capture the console of domain %s: %s", domain.Name, err)
+			}
+		}
+		err = waitForStateDomainLeaseDone(ctx, meta.(*Client), domain, waitForLeases, d)
+		if capture != nil {
+			consoleOutput = capture.Stop()
+		}
+		if err != nil {
+			if consoleOutput != "" {
+				return diag.Errorf("%s\nconsole output of the domain:\n%s", err, consoleOutput)
+			}
 			return diag.FromErr(err)
 		}
 	}
@@ -662,7 +1245,7 @@ func resourceLibvirtDomainCreate(ctx context.Context, d *schema.ResourceData, me
 		return diag.FromErr(err)
 	}
 
-	return nil
+	return qemuCommandlineWarnings(d)
 }
 
 func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
@@ -725,6 +1308,25 @@ func resourceLibvirtDomainUpdate(ctx context.Context, d *schema.ResourceData, me
 		}
 	}
 
+	if d.HasChange("tags") {
+		if err := setDomainTags(virConn, domain, d.Get("tags").(map[string]interface{})); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("xml_drift") {
+		// like the rest of the definition, this takes effect on the next boot
+		err = reapplyTransformedXML(d, func() (string, error) {
+			return virConn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+		}, func(data string) error {
+			_, err := virConn.DomainDefineXML(data)
+			return err
+		})
+		if err != nil {
+			return diag.Errorf("error applying the xml block again to domain %s: %s", domain.Name, err)
+		}
+	}
+
 	netIfacesCount := d.Get("network_interface.#").(int)
 
 	for i := 0; i < netIfacesCount; i++ {
@@ -794,6 +1396,11 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		return diag.FromErr(err)
 	}
 
+	// do not let the provider defaults hide features and devices missing in libvirt
+	domainDef.Features = nil
+	domainDef.Devices.RNGs = nil
+	domainDef.Devices.Channels = nil
+
 	err = xml.Unmarshal([]byte(xmlDesc), &domainDef)
 	if err != nil {
 		return diag.Errorf("error reading libvirt domain XML description: %s", err)
@@ -812,6 +1419,14 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 
 	d.Set("name", domainDef.Name)
 	d.Set("description", domainDef.Description)
+
+	tags := map[string]string{}
+	if domainDef.Metadata != nil {
+		if tags, err = tagsFromMetadata(domainDef.Metadata.XML); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	d.Set("tags", tags)
 	d.Set("vcpu", domainDef.VCPU.Value)
 
 	switch domainDef.Memory.Unit {
@@ -823,7 +1438,9 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		return diag.Errorf("invalid memory unit : %s", domainDef.Memory.Unit)
 	}
 
-	if domainDef.OS.Loader != nil {
+	// the loader is only set by the provider when there is no firmware
+	// to autoselect
+	if domainDef.OS.Loader != nil && domainDef.OS.Firmware == "" {
 		d.Set("firmware", domainDef.OS.Loader.Path)
 	}
 
@@ -855,11 +1472,69 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 	d.Set("arch", domainDef.OS.Type.Arch)
 	d.Set("running", domainRunningNow)
 
-	cmdLines := splitKernelCmdLine(domainDef.OS.Cmdline)
+	d.Set("on_poweroff", domainDef.OnPoweroff)
+	d.Set("on_reboot", domainDef.OnReboot)
+	d.Set("on_crash", domainDef.OnCrash)
+
+	onLockFailure, err := getOnLockFailure(xmlDesc)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("on_lockfailure", onLockFailure)
+
+	// the devices of cloned domains not in the state are the ones of the source domain
+	_, cloned := d.GetOk("clone_from.0.domain_id")
+	setDevices := func(key string, blocks []map[string]interface{}) {
+		if _, ok := d.GetOk(key); ok || !cloned {
+			d.Set(key, blocks)
+		}
+	}
+	setDevices("rng", readRNG(domainDef.Devices.RNGs))
+	setDevices("watchdog", readWatchdog(domainDef.Devices.Watchdogs))
+	setDevices("memballoon", readMemBalloon(domainDef.Devices.MemBalloon))
+	setDevices("vsock", readVSock(domainDef.Devices.VSock))
+	setDevices("input", readInputs(domainDef.Devices.Inputs, d.Get("input").([]interface{})))
+	setDevices("sound", readSounds(domainDef.Devices.Sounds))
+	setDevices("channel", readChannels(domainDef.Devices.Channels))
+
+	if domainDef.Clock != nil {
+		clock := map[string]interface{}{
+			"offset":     domainDef.Clock.Offset,
+			"timezone":   domainDef.Clock.TimeZone,
+			"adjustment": domainDef.Clock.Adjustment,
+		}
+		var timers []map[string]interface{}
+		for _, timerDef := range domainDef.Clock.Timer {
+			timers = append(timers, map[string]interface{}{
+				"name":       timerDef.Name,
+				"tickpolicy": timerDef.TickPolicy,
+				"track":      timerDef.Track,
+				"present":    timerDef.Present != "no",
+			})
+		}
+		clock["timer"] = timers
+		d.Set("clock", []map[string]interface{}{clock})
+	}
+
+	if domainDef.Features != nil {
+		d.Set("features", []map[string]interface{}{readFeatures(domainDef.Features)})
+	}
+
+	if _, ok := d.GetOk("cmdline"); ok {
+		d.Set("cmdline", splitKernelCmdLine(domainDef.OS.Cmdline))
+	} else {
+		d.Set("cmdline_args", splitKernelArgs(domainDef.OS.Cmdline))
+	}
 
-	d.Set("cmdline", cmdLines)
-	d.Set("kernel", domainDef.OS.Kernel)
-	d.Set("initrd", domainDef.OS.Initrd)
+	if uki := d.Get("uki").(string); uki != "" {
+		d.Set("uki", readBootFile(virConn, uki, domainDef.OS.Kernel))
+	} else {
+		d.Set("kernel", readBootFile(virConn, d.Get("kernel").(string), domainDef.OS.Kernel))
+	}
+	d.Set("initrd", readBootFile(virConn, d.Get("initrd").(string), domainDef.OS.Initrd))
+	d.Set("dtb", readBootFile(virConn, d.Get("dtb").(string), domainDef.OS.DTB))
+	d.Set("init", domainDef.OS.Init)
+	d.Set("init_args", domainDef.OS.InitArgs)
 
 	caps, err := getHostCapabilities(virConn)
 	if err != nil {
@@ -880,6 +1555,11 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		disk  map[string]interface{}
 	)
 	for _, diskDef := range domainDef.Devices.Disks {
+		// the disks cloned from the source domain are not part of the disk blocks
+		if diskDef.Target != nil && isClonedDevice(d, "disk_targets", diskDef.Target.Dev) {
+			continue
+		}
+
 		// network drives do not have a volume associated
 		if diskDef.Source.Network != nil {
 			if len(diskDef.Source.Network.Hosts) < 1 {
@@ -954,6 +1634,12 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 
 	var filesystems []map[string]interface{}
 	for _, fsDef := range domainDef.Devices.Filesystems {
+		// the root filesystem of containers is tracked by root_filesystem, and
+		// only directories can be described by the filesystem block
+		if fsDef.Target == nil || fsDef.Target.Dir == lxcRootTarget ||
+			fsDef.Source == nil || fsDef.Source.Mount == nil {
+			continue
+		}
 		fs := map[string]interface{}{
 			"accessmode": fsDef.AccessMode,
 			"source":     fsDef.Source.Mount.Dir,
@@ -968,7 +1654,13 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 	}
 
 	// lookup interfaces with addresses
-	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, d)
+	var macs []string
+	for _, networkInterfaceDef := range domainDef.Devices.Interfaces {
+		if networkInterfaceDef.MAC != nil && networkInterfaceDef.MAC.Address != "" {
+			macs = append(macs, networkInterfaceDef.MAC.Address)
+		}
+	}
+	ifacesWithAddr, err := domainGetIfacesInfo(virConn, domain, d, macs)
 	if err != nil {
 		return diag.Errorf("error retrieving interface addresses: %s", err)
 	}
@@ -989,6 +1681,11 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 
 	var netIfaces []map[string]interface{}
 	for i, networkInterfaceDef := range domainDef.Devices.Interfaces {
+		// neither are the interfaces cloned from the source domain
+		if networkInterfaceDef.MAC != nil && isClonedDevice(d, "macs", networkInterfaceDef.MAC.Address) {
+			continue
+		}
+
 		// we need it to read old values
 		prefix := fmt.Sprintf("network_interface.%d", i)
 
@@ -1066,12 +1763,42 @@ func resourceLibvirtDomainRead(ctx context.Context, d *schema.ResourceData, meta
 		d.Set("network_interface", netIfaces)
 	}
 
-	if len(ifacesWithAddr) > 0 {
+	// prefer the addresses of the domain interfaces over the ones of interfaces created by the guest
+	var connAddrs []string
+	for _, netIface := range netIfaces {
+		connAddrs = append(connAddrs, netIface["addresses"].([]string)...)
+	}
+	for _, ifaceWithAddr := range ifacesWithAddr {
+		for _, addr := range ifaceWithAddr.Addrs {
+			connAddrs = append(connAddrs, addr.Addr)
+		}
+	}
+	if len(connAddrs) > 0 {
 		d.SetConnInfo(map[string]string{
 			"type": "ssh",
-			"host": ifacesWithAddr[0].Addrs[0].Addr,
+			"host": connAddrs[0],
 		})
 	}
+	var flags libvirt.DomainXMLFlags
+	if d.Get("xml_definition_hide_runtime").(bool) {
+		flags = libvirt.DomainXMLInactive
+	}
+	xmlDefinition, err := virConn.DomainGetXMLDesc(domain, flags)
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+	}
+	d.Set("xml_definition", xmlDefinition)
+
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+		}
+		if err := checkTransformedXMLDrift(d, xmlDesc); err != nil {
+			return diag.Errorf("error checking drift of libvirt domain XML description: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -1115,16 +1842,13 @@ func resourceLibvirtDomainDelete(ctx context.Context, d *schema.ResourceData, me
 		}
 	}
 
-	if err := virConn.DomainUndefineFlags(domain, libvirt.DomainUndefineNvram|
-		libvirt.DomainUndefineSnapshotsMetadata|libvirt.DomainUndefineManagedSave|
-		libvirt.DomainUndefineCheckpointsMetadata); err != nil {
-		if isError(err, libvirt.ErrNoSupport) || isError(err, libvirt.ErrInvalidArg) {
-			log.Printf("libvirt does not support undefine flags: will try again without flags")
-			if err := virConn.DomainUndefine(domain); err != nil {
-				return diag.Errorf("couldn't undefine libvirt domain: %s", err)
-			}
-		} else {
-			return diag.Errorf("couldn't undefine libvirt domain with flags: %s", err)
+	if err := undefineDomain(virConn, domain); err != nil {
+		return diag.FromErr(err)
+	}
+
+	for _, key := range clonedVolumeIDs(d) {
+		if err := volumeDelete(ctx, meta.(*Client), key); err != nil {
+			return diag.Errorf("error deleting volume cloned for the domain: %s", err)
 		}
 	}
 
@@ -977,6 +977,146 @@ func TestAccLibvirtDomain_Filesystems(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtDomain_LifecycleClockFeatures(t *testing.T) {
+	var domain libvirt.Domain
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+
+	config := fmt.Sprintf(`
+	resource "libvirt_domain" "%s" {
+		name           = "%s"
+		on_reboot      = "destroy"
+		on_crash       = "restart"
+		clock {
+			offset = "localtime"
+			timer {
+				name       = "rtc"
+				tickpolicy = "catchup"
+			}
+			timer {
+				name    = "hpet"
+				present = false
+			}
+		}
+		features {
+			smm = true
+			hyperv {
+				relaxed   = true
+				vapic     = true
+				spinlocks = 8191
+				synic     = false
+			}
+		}
+	}`, randomDomainName, randomDomainName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					resource.TestCheckResourceAttr(
+						"libvirt_domain."+randomDomainName, "on_reboot", "destroy"),
+					resource.TestCheckResourceAttr(
+						"libvirt_domain."+randomDomainName, "on_crash", "restart"),
+					resource.TestCheckResourceAttr(
+						"libvirt_domain."+randomDomainName, "clock.0.offset", "localtime"),
+					resource.TestCheckResourceAttr(
+						"libvirt_domain."+randomDomainName, "clock.0.timer.1.present", "false"),
+					resource.TestCheckResourceAttr(
+						"libvirt_domain."+randomDomainName, "features.0.hyperv.0.relaxed", "true"),
+					testAccCheckLibvirtDomainDescription(&domain, func(domainDef libvirtxml.Domain) error {
+						if domainDef.Features == nil || domainDef.Features.HyperV == nil {
+							return fmt.Errorf("Expected Hyper-V enlightenments to be enabled")
+						}
+						if domainDef.Features.HyperV.Spinlocks == nil || domainDef.Features.HyperV.Spinlocks.Retries != 8191 {
+							return fmt.Errorf("Expected Hyper-V spinlocks retries to be 8191")
+						}
+						if domainDef.Features.HyperV.Synic == nil || domainDef.Features.HyperV.Synic.State != "off" {
+							return fmt.Errorf("Expected Hyper-V synic to be turned off")
+						}
+						if domainDef.Features.SMM == nil || domainDef.Features.SMM.State != "on" {
+							return fmt.Errorf("Expected SMM to be enabled")
+						}
+						return nil
+					}),
+				),
+			},
+		},
+	})
+}
+
+func TestAccLibvirtDomain_AdditionalDevices(t *testing.T) {
+	var domain libvirt.Domain
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+
+	config := fmt.Sprintf(`
+	resource "libvirt_domain" "%s" {
+		name = "%s"
+		rng {
+			backend     = "builtin"
+			rate_bytes  = 1024
+			rate_period = 1000
+		}
+		watchdog {
+			model  = "i6300esb"
+			action = "poweroff"
+		}
+		memballoon {
+			stats_period = 10
+		}
+		input {
+			type = "tablet"
+			bus  = "usb"
+		}
+		sound {
+			model = "ich9"
+		}
+		channel {
+			type        = "unix"
+			target_name = "org.example.agent.0"
+		}
+	}`, randomDomainName, randomDomainName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					testAccCheckLibvirtDomainDescription(&domain, func(domainDef libvirtxml.Domain) error {
+						if len(domainDef.Devices.RNGs) != 1 || domainDef.Devices.RNGs[0].Backend.BuiltIn == nil {
+							return fmt.Errorf("Expected a single builtin RNG device")
+						}
+						if len(domainDef.Devices.Watchdogs) != 1 || domainDef.Devices.Watchdogs[0].Action != "poweroff" {
+							return fmt.Errorf("Expected an i6300esb watchdog with poweroff action")
+						}
+						if domainDef.Devices.MemBalloon == nil || domainDef.Devices.MemBalloon.Stats == nil ||
+							domainDef.Devices.MemBalloon.Stats.Period != 10 {
+							return fmt.Errorf("Expected memory balloon statistics every 10 seconds")
+						}
+						if len(domainDef.Devices.Sounds) != 1 || domainDef.Devices.Sounds[0].Model != "ich9" {
+							return fmt.Errorf("Expected an ich9 sound card")
+						}
+						for _, channel := range domainDef.Devices.Channels {
+							if channel.Target != nil && channel.Target.VirtIO != nil &&
+								channel.Target.VirtIO.Name == "org.example.agent.0" {
+								return nil
+							}
+						}
+						return fmt.Errorf("Expected a channel named org.example.agent.0")
+					}),
+				),
+			},
+		},
+	})
+}
+
 func testAccCheckLibvirtDomainExists(name string, domain *libvirt.Domain) resource.TestCheckFunc {
 	return func(state *terraform.State) error {
 		rs, err := getResourceFromTerraformState(name, state)
@@ -1776,7 +1916,7 @@ EOF
 func testAccCheckLibvirtDomainDestroy(s *terraform.State) error {
 	virConn := testAccProvider.Meta().(*Client).libvirt
 	for _, rs := range s.RootModule().Resources {
-		if rs.Type != "libvirt_domain" {
+		if rs.Type != "libvirt_domain" && rs.Type != "libvirt_domain_xml" {
 			continue
 		}
 		// Try to find the server
@@ -1857,3 +1997,69 @@ func TestAccLibvirtDomain_FwCfgName(t *testing.T) {
 		},
 	})
 }
+
+func TestAccLibvirtDomain_CloneFrom(t *testing.T) {
+	var domain libvirt.Domain
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomVolumeName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+
+	config := fmt.Sprintf(`
+	resource "libvirt_volume" "%[2]s" {
+		name = "%[2]s"
+		size = 1073741824
+	}
+
+	resource "libvirt_domain_xml" "golden" {
+		running = false
+		xml     = <<EOF
+<domain type="kvm">
+  <name>%[1]s-golden</name>
+  <memory unit="MiB">256</memory>
+  <vcpu>1</vcpu>
+  <os>
+    <type>hvm</type>
+  </os>
+  <devices>
+    <disk type="file" device="disk">
+      <driver name="qemu" type="qcow2"/>
+      <source file="${libvirt_volume.%[2]s.id}"/>
+      <target dev="vda" bus="virtio"/>
+    </disk>
+    <watchdog model="i6300esb" action="reset"/>
+  </devices>
+</domain>
+EOF
+	}
+
+	resource "libvirt_domain" "%[1]s" {
+		name = "%[1]s"
+		clone_from {
+			domain_id = libvirt_domain_xml.golden.id
+		}
+	}`, randomDomainName, randomVolumeName)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists("libvirt_domain."+randomDomainName, &domain),
+					resource.TestCheckResourceAttr("libvirt_domain."+randomDomainName, "clone_from.0.volume_ids.#", "1"),
+					testAccCheckLibvirtDomainDescription(&domain, func(domainDef libvirtxml.Domain) error {
+						if len(domainDef.Devices.Watchdogs) != 1 {
+							return fmt.Errorf("Expected the watchdog of the source domain")
+						}
+						if len(domainDef.Devices.Disks) != 1 || domainDef.Devices.Disks[0].Source.Volume == nil ||
+							domainDef.Devices.Disks[0].Source.Volume.Volume != randomDomainName+"-"+randomVolumeName {
+							return fmt.Errorf("Expected the disk to use a volume cloned from the one of the source domain")
+						}
+						return nil
+					}),
+				),
+			},
+		},
+	})
+}
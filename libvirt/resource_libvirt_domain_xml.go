@@ -0,0 +1,280 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func resourceLibvirtDomainXML() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceLibvirtDomainXMLCreate,
+		ReadContext:   resourceLibvirtDomainXMLRead,
+		DeleteContext: resourceLibvirtDomainXMLDelete,
+		UpdateContext: resourceLibvirtDomainXMLUpdate,
+		CustomizeDiff: resourceLibvirtDomainXMLCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"xml": {
+				Type:     schema.TypeString,
+				Required: true,
+				DiffSuppressFunc: func(_, old, new string, _ *schema.ResourceData) bool {
+					return domainXMLSemanticallyEqual(old, new)
+				},
+			},
+			"running": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// newDomainDefFromXML parses a domain definition given by the user.
+func newDomainDefFromXML(data string) (libvirtxml.Domain, error) {
+	var domainDef libvirtxml.Domain
+	if err := xml.Unmarshal([]byte(data), &domainDef); err != nil {
+		return domainDef, fmt.Errorf("error parsing domain XML: %w", err)
+	}
+	if domainDef.Name == "" {
+		return domainDef, fmt.Errorf("the domain XML must have a name")
+	}
+	return domainDef, nil
+}
+
+// changing the name or the UUID means a different domain.
+func resourceLibvirtDomainXMLCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, meta interface{}) error {
+	if diff.Id() == "" || !diff.HasChange("xml") {
+		return nil
+	}
+
+	oldXML, newXML := diff.GetChange("xml")
+	oldDef, err := newDomainDefFromXML(oldXML.(string))
+	if err != nil {
+		// nothing to compare with, the next apply defines the domain again
+		return nil //nolint:nilerr
+	}
+	newDef, err := newDomainDefFromXML(newXML.(string))
+	if err != nil {
+		return err
+	}
+
+	if oldDef.Name != newDef.Name || (newDef.UUID != "" && newDef.UUID != diff.Id()) {
+		return diff.ForceNew("xml")
+	}
+	return nil
+}
+
+func resourceLibvirtDomainXMLCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[DEBUG] Create resource libvirt_domain_xml")
+
+	virConn := meta.(*Client).libvirt
+
+	data := d.Get("xml").(string)
+	if _, err := newDomainDefFromXML(data); err != nil {
+		return diag.FromErr(err)
+	}
+
+	domain, err := virConn.DomainDefineXML(data)
+	if err != nil {
+		return diag.Errorf("error defining libvirt domain: %s", err)
+	}
+
+	d.SetId(uuidString(domain.UUID))
+	log.Printf("[INFO] Domain ID: %s", d.Id())
+
+	if d.Get("running").(bool) {
+		if err := virConn.DomainCreate(domain); err != nil {
+			return diag.Errorf("error creating libvirt domain: %s", err)
+		}
+	}
+
+	return resourceLibvirtDomainXMLRead(ctx, d, meta)
+}
+
+func resourceLibvirtDomainXMLRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[DEBUG] Read resource libvirt_domain_xml")
+
+	virConn := meta.(*Client).libvirt
+
+	domain, err := virConn.DomainLookupByUUID(parseUUID(d.Id()))
+	if err != nil {
+		if isError(err, libvirt.ErrNoDomain) {
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error retrieving libvirt domain: %s", err)
+	}
+
+	// compare with the persistent definition, the live one has runtime only details
+	storedXML, err := virConn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+	}
+
+	desiredXML := d.Get("xml").(string)
+	if desiredXML == "" {
+		// imported
+		d.Set("xml", storedXML)
+	} else {
+		isSubset, err := domainXMLIsSubset(desiredXML, storedXML)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if !isSubset {
+			log.Printf("[DEBUG] the definition of domain %s was changed outside of terraform", d.Id())
+			d.Set("xml", storedXML)
+		}
+	}
+
+	d.Set("name", domain.Name)
+
+	running, err := domainIsRunning(virConn, domain)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("running", running)
+
+	return nil
+}
+
+func resourceLibvirtDomainXMLUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[DEBUG] Update resource libvirt_domain_xml")
+
+	virConn := meta.(*Client).libvirt
+
+	domain, err := virConn.DomainLookupByUUID(parseUUID(d.Id()))
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt domain by update: %s", err)
+	}
+
+	running, err := domainIsRunning(virConn, domain)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var diags diag.Diagnostics
+	if d.HasChange("xml") {
+		data, err := domainXMLWithUUID(d.Get("xml").(string), d.Id())
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		// keep the MAC and device addresses libvirt generated for the current definition
+		storedXML, err := virConn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+		}
+		if data, err = mergeDomainXMLIdentities(data, storedXML); err != nil {
+			return diag.FromErr(err)
+		}
+
+		// the persistent definition gets all the changes
+		if _, err := virConn.DomainDefineXML(data); err != nil {
+			return diag.Errorf("error defining libvirt domain: %s", err)
+		}
+
+		// and the devices of the running domain are changed where possible
+		if running {
+			liveXML, err := virConn.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				return diag.Errorf("error retrieving libvirt domain XML description: %s", err)
+			}
+			changes, err := diffDomainXMLDevices(liveXML, data)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			diags = applyDomainXMLDeviceChanges(virConn, domain, changes)
+		}
+	}
+
+	if d.HasChange("running") {
+		if err := destroyDomainByUserRequest(virConn, d, domain); err != nil {
+			return diag.FromErr(err)
+		}
+		if d.Get("running").(bool) && !running {
+			if err := virConn.DomainCreate(domain); err != nil {
+				return diag.Errorf("error creating libvirt domain: %s", err)
+			}
+		}
+	}
+
+	return append(diags, resourceLibvirtDomainXMLRead(ctx, d, meta)...)
+}
+
+// applyDomainXMLDeviceChanges applies the device changes to the running domain. Devices
+// that can't be changed live only get the change the next time the domain starts, which
+// is returned as a warning for each of them.
+func applyDomainXMLDeviceChanges(virConn *libvirt.Libvirt, domain libvirt.Domain, changes domainXMLDeviceChanges) diag.Diagnostics {
+	flags := libvirt.DomainDeviceModifyLive
+
+	var diags diag.Diagnostics
+	warn := func(summary string, device string, err error) {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  summary,
+			Detail:   fmt.Sprintf("%s\n\n%s", err, device),
+		})
+	}
+
+	for _, device := range changes.Detach {
+		if err := virConn.DomainDetachDeviceFlags(domain, device, uint32(flags)); err != nil {
+			warn("Could not detach device from running domain, it will be removed on next start", device, err)
+		}
+	}
+	for _, device := range changes.Attach {
+		if err := virConn.DomainAttachDeviceFlags(domain, device, uint32(flags)); err != nil {
+			warn("Could not attach device to running domain, it will be added on next start", device, err)
+		}
+	}
+	for _, device := range changes.Update {
+		if err := virConn.DomainUpdateDeviceFlags(domain, device, flags); err != nil {
+			warn("Could not update device of running domain, it will be changed on next start", device, err)
+		}
+	}
+	return diags
+}
+
+func resourceLibvirtDomainXMLDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	log.Printf("[DEBUG] Delete resource libvirt_domain_xml")
+
+	virConn := meta.(*Client).libvirt
+
+	domain, err := virConn.DomainLookupByUUID(parseUUID(d.Id()))
+	if err != nil {
+		if isError(err, libvirt.ErrNoDomain) {
+			return nil
+		}
+		return diag.Errorf("error retrieving libvirt domain by delete: %s", err)
+	}
+
+	state, _, err := virConn.DomainGetState(domain, 0)
+	if err != nil {
+		return diag.Errorf("couldn't get info about domain: %s", err)
+	}
+
+	if state == int32(libvirt.DomainRunning) || state == int32(libvirt.DomainPaused) {
+		if err := virConn.DomainDestroy(domain); err != nil {
+			return diag.Errorf("couldn't destroy libvirt domain: %s", err)
+		}
+	}
+
+	if err := undefineDomain(virConn, domain); err != nil {
+		return diag.FromErr(err)
+	}
+
+	return nil
+}
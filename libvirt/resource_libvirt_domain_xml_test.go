@@ -0,0 +1,66 @@
+package libvirt
+
+import (
+	"fmt"
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"libvirt.org/go/libvirtxml"
+)
+
+func testAccLibvirtDomainXMLConfig(name string, devices string) string {
+	return fmt.Sprintf(`
+	resource "libvirt_domain_xml" "%s" {
+		xml = <<EOF
+<domain type="kvm">
+  <name>%s</name>
+  <memory unit="MiB">512</memory>
+  <vcpu>1</vcpu>
+  <os>
+    <type>hvm</type>
+  </os>
+  <devices>
+    %s
+  </devices>
+</domain>
+EOF
+	}`, name, name, devices)
+}
+
+func TestAccLibvirtDomainXML_Basic(t *testing.T) {
+	var domain libvirt.Domain
+	randomDomainName := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	resourceName := "libvirt_domain_xml." + randomDomainName
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtDomainDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccLibvirtDomainXMLConfig(randomDomainName, `<graphics type="vnc" autoport="yes"/>`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists(resourceName, &domain),
+					resource.TestCheckResourceAttr(resourceName, "name", randomDomainName),
+					resource.TestCheckResourceAttr(resourceName, "running", "true"),
+				),
+			},
+			{
+				Config: testAccLibvirtDomainXMLConfig(randomDomainName,
+					`<graphics type="vnc" autoport="yes"/>
+    <watchdog model="i6300esb" action="reset"/>`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtDomainExists(resourceName, &domain),
+					testAccCheckLibvirtDomainDescription(&domain, func(domainDef libvirtxml.Domain) error {
+						if len(domainDef.Devices.Watchdogs) != 1 {
+							return fmt.Errorf("Expected the watchdog to be added to the domain")
+						}
+						return nil
+					}),
+				),
+			},
+		},
+	})
+}
@@ -24,6 +24,10 @@ const (
 	dnsPrefix       = "dns.0"
 )
 
+func resourceLibvirtNetworkCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, meta interface{}) error {
+	return customizeXMLDriftDiff(diff)
+}
+
 // a libvirt network resource
 //
 // Resource example:
@@ -49,6 +53,7 @@ func resourceLibvirtNetwork() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
+		CustomizeDiff: resourceLibvirtNetworkCustomizeDiff,
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -274,6 +279,17 @@ func resourceLibvirtNetwork() *schema.Resource {
 					},
 				},
 			},
+			"tags": tagsSchema(),
+			"xml_definition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"xml_definition_hide_runtime": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"xml_drift": xmlDriftSchema(),
 			"xml": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -282,9 +298,22 @@ func resourceLibvirtNetwork() *schema.Resource {
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"xslt": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							ForceNew:         true,
+							DiffSuppressFunc: xsltDiffSupressFunc,
+						},
+						"patch": xmlPatchSchema(),
+						"transformed_paths": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"transformed_hash": {
 							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
+							Computed: true,
 						},
 					},
 				},
@@ -328,12 +357,33 @@ func resourceLibvirtNetworkUpdate(ctx context.Context, d *schema.ResourceData, m
 		}
 	}
 
+	if d.HasChange("tags") {
+		if err := setNetworkTags(meta.(*Client), network, d.Get("tags").(map[string]interface{})); err != nil {
+			return diag.Errorf("error updating tags for network %s: %s", network.Name, err)
+		}
+	}
+
 	// detect changes in the DNS entries in this network
 	err = updateDNSHosts(d, meta, network)
 	if err != nil {
 		return diag.Errorf("error updating DNS hosts for network %s: %s", network.Name, err)
 	}
 
+	if d.HasChange("xml_drift") {
+		err = reapplyTransformedXML(d, func() (string, error) {
+			return virConn.NetworkGetXMLDesc(network, uint32(libvirt.NetworkXMLInactive))
+		}, func(data string) error {
+			meta.(*Client).networkMutex.Lock()
+			defer meta.(*Client).networkMutex.Unlock()
+
+			_, err := virConn.NetworkDefineXML(data)
+			return err
+		})
+		if err != nil {
+			return diag.Errorf("error applying the xml block again to network %s: %s", network.Name, err)
+		}
+	}
+
 	return nil
 }
 
@@ -350,6 +400,14 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 	networkDef.Name = d.Get("name").(string)
 	networkDef.Domain = getDomainFromResource(d)
 
+	if tags := d.Get("tags").(map[string]interface{}); len(tags) > 0 {
+		metadataXML, err := setTagsMetadata("", tags)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		networkDef.Metadata = &libvirtxml.NetworkMetadata{XML: metadataXML}
+	}
+
 	// use a bridge provided by the user, or create one otherwise (libvirt will assign on automatically when empty)
 	networkDef.Bridge = getBridgeFromResource(d)
 
@@ -434,10 +492,15 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 	}
 	log.Printf("[DEBUG] Generated XML for libvirt network:\n%s", data)
 
+	generatedData := data
 	data, err = transformResourceXML(data, d)
 	if err != nil {
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
+	if err := setTransformedXMLPaths(d, generatedData, data); err != nil {
+		return diag.Errorf("error comparing generated and transformed XML: %s", err)
+	}
+	d.Set("xml_definition", data)
 
 	network, err := func() (libvirt.Network, error) {
 		// define only one network at a time
@@ -452,6 +515,16 @@ func resourceLibvirtNetworkCreate(ctx context.Context, d *schema.ResourceData, m
 		return diag.Errorf("error defining libvirt network: %s - %s", err, data)
 	}
 
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.NetworkGetXMLDesc(network, uint32(libvirt.NetworkXMLInactive))
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt network XML description: %s", err)
+		}
+		if err := setTransformedXMLHash(d, xmlDesc); err != nil {
+			return diag.Errorf("error hashing libvirt network XML description: %s", err)
+		}
+	}
+
 	err = virConn.NetworkCreate(network)
 	if err != nil {
 		// in some cases, the network creation fails but an artifact is created
@@ -513,6 +586,12 @@ func resourceLibvirtNetworkRead(ctx context.Context, d *schema.ResourceData, met
 	d.Set("name", networkDef.Name)
 	d.Set("bridge", networkDef.Bridge.Name)
 
+	tags, err := getNetworkTags(virConn, network)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("tags", tags)
+
 	if networkDef.MTU != nil {
 		d.Set("mtu", networkDef.MTU.Size)
 	}
@@ -641,6 +720,26 @@ func resourceLibvirtNetworkRead(ctx context.Context, d *schema.ResourceData, met
 	// TODO: get any other parameters from the network and save them
 
 	log.Printf("[DEBUG] Network ID %s successfully read", d.Id())
+	var flags uint32
+	if d.Get("xml_definition_hide_runtime").(bool) {
+		flags = uint32(libvirt.NetworkXMLInactive)
+	}
+	xmlDefinition, err := virConn.NetworkGetXMLDesc(network, flags)
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt network XML description: %s", err)
+	}
+	d.Set("xml_definition", xmlDefinition)
+
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.NetworkGetXMLDesc(network, uint32(libvirt.NetworkXMLInactive))
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt network XML description: %s", err)
+		}
+		if err := checkTransformedXMLDrift(d, xmlDesc); err != nil {
+			return diag.Errorf("error checking drift of libvirt network XML description: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -25,13 +25,14 @@ func resourceLibvirtPoolCustomizeDiff(ctx context.Context, diff *schema.Resource
 		}
 	}
 
-	return nil
+	return customizeXMLDriftDiff(diff)
 }
 
 func resourceLibvirtPool() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceLibvirtPoolCreate,
 		ReadContext:   resourceLibvirtPoolRead,
+		UpdateContext: resourceLibvirtPoolUpdate,
 		DeleteContext: resourceLibvirtPoolDelete,
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -107,6 +108,16 @@ func resourceLibvirtPool() *schema.Resource {
 					},
 				},
 			},
+			"xml_definition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"xml_definition_hide_runtime": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"xml_drift": xmlDriftSchema(),
 			"xml": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -115,9 +126,22 @@ func resourceLibvirtPool() *schema.Resource {
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"xslt": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							ForceNew:         true,
+							DiffSuppressFunc: xsltDiffSupressFunc,
+						},
+						"patch": xmlPatchSchema(),
+						"transformed_paths": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"transformed_hash": {
 							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
+							Computed: true,
 						},
 					},
 				},
@@ -207,16 +231,31 @@ func resourceLibvirtPoolCreate(ctx context.Context, d *schema.ResourceData, meta
 	}
 	log.Printf("[DEBUG] Generated XML for libvirt storage pool:\n%s", data)
 
+	generatedData := data
 	data, err = transformResourceXML(data, d)
 	if err != nil {
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
+	if err := setTransformedXMLPaths(d, generatedData, data); err != nil {
+		return diag.Errorf("error comparing generated and transformed XML: %s", err)
+	}
+	d.Set("xml_definition", data)
 
 	pool, err := virConn.StoragePoolDefineXML(data, 0)
 	if err != nil {
 		return diag.Errorf("error creating libvirt storage pool: %s", err)
 	}
 
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.StoragePoolGetXMLDesc(pool, libvirt.StorageXMLInactive)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt storage pool XML description: %s", err)
+		}
+		if err := setTransformedXMLHash(d, xmlDesc); err != nil {
+			return diag.Errorf("error hashing libvirt storage pool XML description: %s", err)
+		}
+	}
+
 	if !skipBuild {
 		if err := virConn.StoragePoolBuild(pool, 0); err != nil {
 			return diag.Errorf("error building libvirt storage pool: %s", err)
@@ -325,9 +364,55 @@ func resourceLibvirtPoolRead(ctx context.Context, d *schema.ResourceData, meta i
 		}
 	}
 
+	var flags libvirt.StorageXMLFlags
+	if d.Get("xml_definition_hide_runtime").(bool) {
+		flags = libvirt.StorageXMLInactive
+	}
+	xmlDefinition, err := virConn.StoragePoolGetXMLDesc(pool, flags)
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt storage pool XML description: %s", err)
+	}
+	d.Set("xml_definition", xmlDefinition)
+
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.StoragePoolGetXMLDesc(pool, libvirt.StorageXMLInactive)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt storage pool XML description: %s", err)
+		}
+		if err := checkTransformedXMLDrift(d, xmlDesc); err != nil {
+			return diag.Errorf("error checking drift of libvirt storage pool XML description: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// resourceLibvirtPoolUpdate applies the xml block again when its changes were
+// modified outside of terraform, every other attribute that changes the pool forces
+// a new one.
+func resourceLibvirtPoolUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if d.HasChange("xml_drift") {
+		virConn := meta.(*Client).libvirt
+
+		pool, err := virConn.StoragePoolLookupByUUID(parseUUID(d.Id()))
+		if err != nil {
+			return diag.Errorf("error retrieving storage pool info: %s", err)
+		}
+
+		err = reapplyTransformedXML(d, func() (string, error) {
+			return virConn.StoragePoolGetXMLDesc(pool, libvirt.StorageXMLInactive)
+		}, func(data string) error {
+			_, err := virConn.StoragePoolDefineXML(data, 0)
+			return err
+		})
+		if err != nil {
+			return diag.Errorf("error applying the xml block again to storage pool %s: %s", pool.Name, err)
+		}
+	}
+
+	return resourceLibvirtPoolRead(ctx, d, meta)
+}
+
 func resourceLibvirtPoolDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client := meta.(*Client)
 	virConn := client.libvirt
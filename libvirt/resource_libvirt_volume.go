@@ -9,12 +9,14 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/retry"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
 )
 
 func resourceLibvirtVolume() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceLibvirtVolumeCreate,
 		ReadContext:   resourceLibvirtVolumeRead,
+		UpdateContext: resourceLibvirtVolumeUpdate,
 		DeleteContext: resourceLibvirtVolumeDelete,
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -33,11 +35,32 @@ func resourceLibvirtVolume() *schema.Resource {
 				Optional: true,
 				ForceNew: true,
 			},
+			"source_checksum": sourceChecksumSchema(),
+			"source_http":     sourceHTTPSchema(),
+			"source_convert": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"source_digest": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
 			"size": {
 				Type:     schema.TypeInt,
 				Optional: true,
 				Computed: true,
-				ForceNew: true,
+			},
+			"allocate_on_resize": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"allow_shrink": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
 			},
 			"format": {
 				Type:     schema.TypeString,
@@ -66,6 +89,16 @@ func resourceLibvirtVolume() *schema.Resource {
 				ForceNew: true,
 				Default:  false,
 			},
+			"xml_definition": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"xml_definition_hide_runtime": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"xml_drift": xmlDriftSchema(),
 			"xml": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -74,9 +107,22 @@ func resourceLibvirtVolume() *schema.Resource {
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"xslt": {
+							Type:             schema.TypeString,
+							Optional:         true,
+							ForceNew:         true,
+							DiffSuppressFunc: xsltDiffSupressFunc,
+						},
+						"patch": xmlPatchSchema(),
+						"transformed_paths": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"transformed_hash": {
 							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
+							Computed: true,
 						},
 					},
 				},
@@ -85,9 +131,56 @@ func resourceLibvirtVolume() *schema.Resource {
 		Importer: &schema.ResourceImporter{
 			StateContext: schema.ImportStatePassthroughContext,
 		},
+		CustomizeDiff: resourceLibvirtVolumeCustomizeDiff,
 	}
 }
 
+func resourceLibvirtVolumeCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, meta interface{}) error {
+	if err := customizeSourceDigestDiff(diff, meta.(*Client)); err != nil {
+		return err
+	}
+
+	// the size of existing volumes is changed in place, but shrinking them
+	// loses the data at their end
+	if diff.Id() == "" || !diff.HasChange("size") || !diff.NewValueKnown("size") {
+		return nil
+	}
+
+	oldSize, newSize := diff.GetChange("size")
+	if newSize.(int) < oldSize.(int) && !diff.Get("allow_shrink").(bool) {
+		return fmt.Errorf("can't shrink volume from %d to %d bytes, set 'allow_shrink' to do it anyway", oldSize, newSize)
+	}
+	return nil
+}
+
+// customizeSourceDigestDiff re-imports the source when the checksum published in
+// the checksum file of source_checksum does not match the imported one anymore.
+func customizeSourceDigestDiff(diff *schema.ResourceDiff, client *Client) error {
+	if diff.Id() == "" || diff.HasChange("source_checksum") || diff.Get("source_checksum.0.url").(string) == "" {
+		return nil
+	}
+
+	options, err := client.sourceHTTPOptions(diff, "source_http")
+	if err != nil {
+		return err
+	}
+
+	digest, err := expectedSourceDigest(diff.Get("source_checksum.0").(map[string]interface{}), diff.Get("source").(string), options)
+	if err != nil {
+		log.Printf("[WARN] Could not check the upstream checksum of %s: %s", diff.Get("source"), err)
+		return nil
+	}
+	if digest == diff.Get("source_digest").(string) {
+		return nil
+	}
+
+	log.Printf("[INFO] Checksum of %s changed upstream to %s", diff.Get("source"), digest)
+	if err := diff.SetNew("source_digest", digest); err != nil {
+		return err
+	}
+	return diff.ForceNew("source_digest")
+}
+
 func resourceLibvirtVolumeCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client := meta.(*Client)
 	virConn := meta.(*Client).libvirt
@@ -127,6 +220,7 @@ func resourceLibvirtVolumeCreate(ctx context.Context, d *schema.ResourceData, me
 	}
 
 	var img image
+	var verifier *checksumVerifier
 	var baseVolume libvirt.StorageVol
 	// an source image was given, this mean we can't choose size
 	if source, ok := d.GetOk("source"); ok {
@@ -143,20 +237,86 @@ func resourceLibvirtVolumeCreate(ctx context.Context, d *schema.ResourceData, me
 			return diag.Errorf("'base_volume_name' can't be specified when also 'source' is given")
 		}
 
-		if img, err = newImage(source.(string)); err != nil {
+		options, err := client.sourceHTTPOptions(d, "source_http")
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		if img, err = newImageWithOptions(source.(string), options); err != nil {
 			return diag.FromErr(err)
 		}
 
-		// if no format is given, autodetect
-		if !isFormatGiven {
-			isQCOW2, err := img.IsQCOW2()
+		// take the disk image for the architecture of the host from registries
+		if oci, ok := img.(*ociImage); ok {
+			arch, err := getHostArchitecture(virConn)
 			if err != nil {
-				return diag.Errorf("error while determining image type for %s: %s", img.String(), err)
+				return diag.Errorf("error retrieving host architecture: %s", err)
 			}
+			oci.architecture = ociArchitecture(arch)
+		}
 
-			if isQCOW2 {
-				volumeDef.Target.Format.Type = "qcow2"
+		// volumes are streamed from a connection to their host
+		if volumeImage, ok := img.(*libvirtImage); ok {
+			if err := volumeImage.connect(client.uri); err != nil {
+				return diag.FromErr(err)
 			}
+			defer volumeImage.disconnect()
+		}
+
+		var digest string
+		if _, ok := d.GetOk("source_checksum"); ok {
+			if digest, err = expectedSourceDigest(d.Get("source_checksum.0").(map[string]interface{}), source.(string), options); err != nil {
+				return diag.Errorf("error retrieving checksum of %s: %s", img.String(), err)
+			}
+			if verifier, err = newChecksumVerifier(digest); err != nil {
+				return diag.FromErr(err)
+			}
+		}
+
+		// remote images are downloaded once to the cache, when enabled
+		img = newCachedImage(img, client.downloadCache, digest)
+
+		if verifier != nil {
+			// verify the image as downloaded, even when it is only read once to a
+			// temporary file, like compressed images
+			img = newVerifiedImage(img, verifier)
+		}
+		defer cleanupImage(img)
+
+		sourceFormat, err := img.Format()
+		if err != nil {
+			return diag.Errorf("error while determining image type for %s: %s", img.String(), err)
+		}
+		log.Printf("[DEBUG] Image %s format is %s", img, sourceFormat)
+
+		// if no format is given, use the one of the image, or qcow2 for the non
+		// native ones to convert
+		convert := d.Get("source_convert").(bool)
+		switch {
+		case isFormatGiven:
+		case convert && !isNativeFormat(sourceFormat):
+			volumeDef.Target.Format.Type = imageFormatQCOW2
+		default:
+			volumeDef.Target.Format.Type = volumeFormat(sourceFormat)
+		}
+
+		if convert && volumeFormat(sourceFormat) != volumeDef.Target.Format.Type {
+			converted, cleanup, err := convertImage(img, sourceFormat, volumeDef.Target.Format.Type)
+			defer cleanup()
+			if err != nil {
+				return diag.FromErr(err)
+			}
+
+			if verifier != nil {
+				if err := verifier.Verify(); err != nil {
+					return diag.Errorf("error while verifying source %s: %s", img.String(), err)
+				}
+				d.Set("source_digest", verifier.Digest())
+				verifier = nil
+			}
+			img = converted
+		} else if !isFormatGiven && !isLibvirtVolumeFormat(volumeDef.Target.Format.Type) {
+			return diag.Errorf("images in %s format can't be used as volumes by libvirt, set 'source_convert' to convert %s", sourceFormat, img.String())
 		}
 
 		// update the image in the description, even if the file has not changed
@@ -233,16 +393,34 @@ be smaller than the backing store specified with
 		}
 	}
 
+	// new raw volumes of file based pools read as zeros, so only the data of the
+	// source has to be uploaded, and the volume does not need to be allocated
+	sparse := false
+	if _, ok := d.GetOk("source"); ok && volumeDef.Target.Format.Type == imageFormatRaw {
+		poolDef, diags := newDefPoolFromLibvirt(virConn, pool)
+		if diags.HasError() {
+			return diags
+		}
+		if sparse = isSparsePool(poolDef); sparse {
+			volumeDef.Allocation = &libvirtxml.StorageVolumeSize{Unit: "bytes", Value: 0}
+		}
+	}
+
 	data, err := xmlMarshallIndented(volumeDef)
 	if err != nil {
 		return diag.Errorf("error serializing libvirt volume: %s", err)
 	}
 	log.Printf("[DEBUG] Generated XML for libvirt volume:\n%s", data)
 
+	generatedData := data
 	data, err = transformResourceXML(data, d)
 	if err != nil {
 		return diag.Errorf("error applying XSLT stylesheet: %s", err)
 	}
+	if err := setTransformedXMLPaths(d, generatedData, data); err != nil {
+		return diag.Errorf("error comparing generated and transformed XML: %s", err)
+	}
+	d.Set("xml_definition", data)
 
 	var volume libvirt.StorageVol
 	if d.Get("base_volume_copy").(bool) {
@@ -260,15 +438,38 @@ be smaller than the backing store specified with
 			return diag.Errorf("error looking up libvirt volume: %s", err)
 		}
 		log.Printf("[INFO] Volume about to be created was found and left as-is: %s", volumeDef.Name)
+		// its content is unknown, the holes of the source must be written
+		sparse = false
 	}
 
 	// we use the key as the id
 	d.SetId(volume.Key)
 	log.Printf("[INFO] Volume ID: %s", d.Id())
 
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.StorageVolGetXMLDesc(volume, 0)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt volume XML description: %s", err)
+		}
+		if err := setTransformedXMLHash(d, xmlDesc); err != nil {
+			return diag.Errorf("error hashing libvirt volume XML description: %s", err)
+		}
+	}
+
 	// upload source if present
 	if _, ok := d.GetOk("source"); ok {
-		err = img.Import(newVolumeUploader(virConn, &volume, volumeDef.Capacity.Value), volumeDef)
+		// the size of compressed qcow2 images is their virtual size, which can be
+		// smaller than the image, so they are uploaded until their end
+		uploadSize := volumeDef.Capacity.Value
+		if _, ok := img.(*compressedImage); ok {
+			uploadSize = 0
+		}
+		uploader := newVolumeUploader(virConn, &volume, uploadSize)
+		if sparse {
+			uploader = newSparseVolumeUploader(virConn, &volume)
+		}
+
+		err = img.Import(uploader, volumeDef)
 		if err != nil {
 			//  don't save volume ID  in case of error. This will taint the volume after.
 			// If we don't throw away the id, we will keep instead a broken volume.
@@ -276,6 +477,18 @@ be smaller than the backing store specified with
 			d.Set("id", "")
 			return diag.Errorf("error while uploading source %s: %s", img.String(), err)
 		}
+
+		if verifier != nil {
+			if err := verifier.Verify(); err != nil {
+				// never leave a volume with unexpected content behind
+				if err := virConn.StorageVolDelete(volume, 0); err != nil {
+					log.Printf("[WARN] Could not delete volume %s after failed verification: %s", volume.Key, err)
+				}
+				d.SetId("")
+				return diag.Errorf("error while verifying source %s: %s", img.String(), err)
+			}
+			d.Set("source_digest", verifier.Digest())
+		}
 	}
 
 	if requiresResize, err := volumeRequiresResize(virConn, d, volume, baseVolume, pool); err != nil {
@@ -286,10 +499,8 @@ be smaller than the backing store specified with
 		log.Printf("[WARNING] Could not determine whether volume '%s' requires resize%s", volume.Name, errContext)
 	} else if requiresResize {
 		if size, ok := d.GetOk("size"); ok {
-			if err := virConn.StorageVolResize(volume, uint64(size.(int)), 0); err != nil {
-				return diag.Errorf("failed to resize volume '%s': %s", volume.Key, err)
-			} else {
-				log.Printf("[INFO] Volume '%s' successfully resized", volume.Key)
+			if err := volumeResize(virConn, volume, uint64(size.(int)), d.Get("allocate_on_resize").(bool), false); err != nil {
+				return diag.FromErr(err)
 			}
 		}
 	}
@@ -384,9 +595,68 @@ func resourceLibvirtVolumeRead(ctx context.Context, d *schema.ResourceData, meta
 		d.Set("format", volumeDef.Target.Format.Type)
 	}
 
+	xmlDefinition, err := virConn.StorageVolGetXMLDesc(volume, 0)
+	if err != nil {
+		return diag.Errorf("error retrieving libvirt volume XML description: %s", err)
+	}
+	if d.Get("xml_definition_hide_runtime").(bool) {
+		// volumes have no persistent definition, drop what changes as the volume is used
+		xmlDefinition, err = stripXMLElements(xmlDefinition, "/volume/allocation", "/volume/physical", "/volume/target/timestamps")
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	d.Set("xml_definition", xmlDefinition)
+
+	if _, ok := d.GetOk("xml.0.transformed_paths"); ok {
+		xmlDesc, err := virConn.StorageVolGetXMLDesc(volume, 0)
+		if err != nil {
+			return diag.Errorf("error retrieving libvirt volume XML description: %s", err)
+		}
+		if err := checkTransformedXMLDrift(d, xmlDesc); err != nil {
+			return diag.Errorf("error checking drift of libvirt volume XML description: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// resourceLibvirtVolumeUpdate resizes the volume, every other attribute that
+// changes the volume forces a new one.
+func resourceLibvirtVolumeUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*Client)
+	virConn := client.libvirt
+
+	if d.HasChange("size") {
+		poolName := d.Get("pool").(string)
+		client.poolMutexKV.Lock(poolName)
+		defer client.poolMutexKV.Unlock(poolName)
+
+		volume, err := virConn.StorageVolLookupByKey(d.Id())
+		if err != nil {
+			return diag.Errorf("can't retrieve volume %s: %s", d.Id(), err)
+		}
+
+		pool, err := virConn.StoragePoolLookupByVolume(volume)
+		if err != nil {
+			return diag.Errorf("can't retrieve pool of volume %s: %s", d.Id(), err)
+		}
+
+		requiresResize, diags := volumeRequiresResize(virConn, d, volume, libvirt.StorageVol{}, pool)
+		if diags.HasError() {
+			return diags
+		}
+		if requiresResize {
+			if err := volumeResize(virConn, volume, uint64(d.Get("size").(int)),
+				d.Get("allocate_on_resize").(bool), d.Get("allow_shrink").(bool)); err != nil {
+				return diag.FromErr(err)
+			}
+		}
+	}
+
+	return resourceLibvirtVolumeRead(ctx, d, meta)
+}
+
 // resourceLibvirtVolumeDelete removed a volume resource.
 func resourceLibvirtVolumeDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
 	client := meta.(*Client)
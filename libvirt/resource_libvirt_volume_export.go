@@ -0,0 +1,328 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// resourceLibvirtVolumeExport exports the content of a volume to a local file or an
+// http endpoint. The export happens on creation, and again whenever the triggers
+// change; destroying the resource leaves the exported file in place.
+func resourceLibvirtVolumeExport() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceLibvirtVolumeExportCreate,
+		ReadContext:   resourceLibvirtVolumeExportRead,
+		UpdateContext: resourceLibvirtVolumeExportUpdate,
+		DeleteContext: resourceLibvirtVolumeExportDelete,
+		Schema: map[string]*schema.Schema{
+			"volume_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"url"},
+			},
+			"url": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"path"},
+			},
+			"http": sourceHTTPSchema(),
+			"compression": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"checksum_algorithm": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "sha256",
+				ForceNew: true,
+			},
+			"checksum_file": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"triggers": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"checksum": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// volumeExport is the result of an export: the checksum and the size of what was
+// written to the destination, after compression.
+type volumeExport struct {
+	checksum string
+	size     int64
+}
+
+// exportVolume writes what download produces to dst, compressed with c, and returns
+// the checksum of the written content with algorithm.
+func exportVolume(download func(io.Writer) error, dst io.Writer, c compression, algorithm string) (*volumeExport, error) {
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{}
+
+	compressor, err := newCompressingWriter(c, io.MultiWriter(dst, h, counter))
+	if err != nil {
+		return nil, err
+	}
+	if err := download(compressor); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+
+	return &volumeExport{
+		checksum: algorithm + ":" + hex.EncodeToString(h.Sum(nil)),
+		size:     counter.n,
+	}, nil
+}
+
+// exportVolumeToFile exports to a local file, replaced only once the export is
+// complete. Uncompressed exports keep the holes of the volume.
+func exportVolumeToFile(download func(io.Writer) error, dst string, c compression, algorithm string) (*volumeExport, error) {
+	var export *volumeExport
+	err := writeFileAtomic(dst, func(w io.Writer) error {
+		file := w.(*os.File)
+		//nolint:mnd
+		if err := file.Chmod(0o644); err != nil {
+			return err
+		}
+
+		var sparse *sparseFileWriter
+		if c == compressionNone {
+			var err error
+			if sparse, err = newSparseFileWriter(file); err != nil {
+				return err
+			}
+			w = sparse
+		}
+
+		var err error
+		if export, err = exportVolume(download, w, c, algorithm); err != nil {
+			return err
+		}
+		if sparse != nil {
+			return sparse.Close()
+		}
+		return nil
+	})
+	return export, err
+}
+
+// putHTTP uploads what write produces to u with a PUT request.
+func putHTTP(options *httpOptions, u *url.URL, write func(io.Writer) error) error {
+	req, err := options.newRequest(http.MethodPut, u, nil)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	req.Body = reader
+	written := make(chan error, 1)
+	go func() {
+		err := write(writer)
+		writer.CloseWithError(err)
+		written <- err
+	}()
+
+	resp, err := options.client.Do(req)
+	// the server may answer before reading the whole body, don't leave the writer blocked
+	reader.Close()
+	writeErr := <-written
+	if err != nil {
+		if writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+			return writeErr
+		}
+		return fmt.Errorf("error while uploading to %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("error while uploading to %s: unexpected response code %d", u.Redacted(), resp.StatusCode)
+	}
+	return writeErr
+}
+
+// exportVolumeToURL exports to an http endpoint.
+func exportVolumeToURL(download func(io.Writer) error, options *httpOptions, u *url.URL, c compression, algorithm string) (*volumeExport, error) {
+	var export *volumeExport
+	err := putHTTP(options, u, func(w io.Writer) error {
+		var err error
+		export, err = exportVolume(download, w, c, algorithm)
+		return err
+	})
+	return export, err
+}
+
+// checksumFileContent returns the checksum file of an export named name, in the
+// format of sha256sum.
+func checksumFileContent(export *volumeExport, name string) string {
+	return fmt.Sprintf("%s  %s\n", export.checksum[strings.Index(export.checksum, ":")+1:], name)
+}
+
+func resourceLibvirtVolumeExportCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*Client)
+	virConn := client.libvirt
+
+	dstPath := d.Get("path").(string)
+	dstURL := d.Get("url").(string)
+	if (dstPath == "") == (dstURL == "") {
+		return diag.Errorf("exactly one of 'path' or 'url' must be set")
+	}
+
+	c := compression(d.Get("compression").(string))
+	switch c {
+	case compressionNone, compressionGzip, compressionXZ, compressionZstd:
+	default:
+		return diag.Errorf("unsupported compression '%s', must be 'gzip', 'xz' or 'zstd'", c)
+	}
+
+	algorithm := d.Get("checksum_algorithm").(string)
+	if _, err := newChecksumHash(algorithm); err != nil {
+		return diag.FromErr(err)
+	}
+
+	volume, err := virConn.StorageVolLookupByKey(d.Get("volume_id").(string))
+	if err != nil {
+		return diag.Errorf("can't retrieve volume %s: %v", d.Get("volume_id").(string), err)
+	}
+	download := newVolumeDownloader(virConn, &volume)
+
+	var export *volumeExport
+	if dstPath != "" {
+		log.Printf("[INFO] Exporting volume %s to %s", volume.Name, dstPath)
+		if export, err = exportVolumeToFile(download, dstPath, c, algorithm); err != nil {
+			return diag.Errorf("error exporting volume %s to %s: %v", volume.Name, dstPath, err)
+		}
+
+		if d.Get("checksum_file").(bool) {
+			content := checksumFileContent(export, filepath.Base(dstPath))
+			err := writeFileAtomic(dstPath+"."+algorithm, func(w io.Writer) error {
+				//nolint:mnd
+				if err := w.(*os.File).Chmod(0o644); err != nil {
+					return err
+				}
+				_, err := io.WriteString(w, content)
+				return err
+			})
+			if err != nil {
+				return diag.Errorf("error writing the checksum of %s: %v", dstPath, err)
+			}
+		}
+		d.SetId(dstPath)
+	} else {
+		u, err := url.Parse(dstURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return diag.Errorf("'url' must be an http or https url, got '%s'", dstURL)
+		}
+		options, err := client.sourceHTTPOptions(d, "http")
+		if err != nil {
+			return diag.FromErr(err)
+		}
+
+		log.Printf("[INFO] Exporting volume %s to %s", volume.Name, u.Redacted())
+		if export, err = exportVolumeToURL(download, options, u, c, algorithm); err != nil {
+			return diag.Errorf("error exporting volume %s: %v", volume.Name, err)
+		}
+
+		if d.Get("checksum_file").(bool) {
+			checksumURL := *u
+			checksumURL.Path += "." + algorithm
+			content := checksumFileContent(export, path.Base(u.Path))
+			err := putHTTP(options, &checksumURL, func(w io.Writer) error {
+				_, err := io.WriteString(w, content)
+				return err
+			})
+			if err != nil {
+				return diag.Errorf("error uploading the checksum of volume %s: %v", volume.Name, err)
+			}
+		}
+		d.SetId(dstURL)
+	}
+
+	d.Set("checksum", export.checksum)
+	d.Set("size", export.size)
+
+	return resourceLibvirtVolumeExportRead(ctx, d, meta)
+}
+
+// resourceLibvirtVolumeExportRead notices when an exported file was removed, to
+// export it again. Exports to urls are not checked.
+func resourceLibvirtVolumeExportRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	dstPath := d.Get("path").(string)
+	if dstPath == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(dstPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WARN] Exported volume %s is gone", dstPath)
+			d.SetId("")
+			return nil
+		}
+		return diag.Errorf("error checking the export %s: %v", dstPath, err)
+	}
+	return nil
+}
+
+// resourceLibvirtVolumeExportUpdate has nothing to do, all the attributes changing
+// the export force a new one.
+func resourceLibvirtVolumeExportUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	return resourceLibvirtVolumeExportRead(ctx, d, meta)
+}
+
+// resourceLibvirtVolumeExportDelete forgets the export. The exported file is kept,
+// as it is usually meant to outlive the volume.
+func resourceLibvirtVolumeExportDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	d.SetId("")
+	return nil
+}
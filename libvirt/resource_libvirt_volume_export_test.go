@@ -0,0 +1,180 @@
+package libvirt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/acctest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportVolume(t *testing.T) {
+	content := newSparseContent()
+	download := func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(content))
+		return err
+	}
+
+	// uncompressed, to a sparse file
+	dst := filepath.Join(t.TempDir(), "volume.raw")
+	export, err := exportVolumeToFile(download, dst, compressionNone, "sha256")
+	require.NoError(t, err)
+	written, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+	assert.Equal(t, sha256Digest(content), export.checksum)
+	assert.Equal(t, int64(len(content)), export.size)
+
+	// compressed, the checksum is the one of the compressed file
+	dst = filepath.Join(t.TempDir(), "volume.raw.gz")
+	export, err = exportVolumeToFile(download, dst, compressionGzip, "sha256")
+	require.NoError(t, err)
+	written, err = os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Digest(written), export.checksum)
+	assert.Equal(t, int64(len(written)), export.size)
+	gz, err := gzip.NewReader(bytes.NewReader(written))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, content, decompressed)
+
+	checksum, err := parseChecksumFile(bytes.NewBufferString(checksumFileContent(export, "volume.raw.gz")), "volume.raw.gz")
+	require.NoError(t, err)
+	sum := sha256.Sum256(written)
+	assert.Equal(t, hex.EncodeToString(sum[:]), checksum)
+
+	_, err = exportVolumeToFile(download, dst, compressionBzip2, "sha256")
+	assert.Error(t, err)
+}
+
+func TestExportVolumeToURL(t *testing.T) {
+	content := newSparseContent()
+	download := func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(content))
+		return err
+	}
+
+	uploads := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer upload-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		uploads[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	options, err := newHTTPOptions(map[string]interface{}{"bearer_token": "upload-token"})
+	require.NoError(t, err)
+
+	u, err := url.Parse(server.URL + "/images/volume.raw.zst")
+	require.NoError(t, err)
+	export, err := exportVolumeToURL(download, options, u, compressionZstd, "sha512")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(uploads["/images/volume.raw.zst"])), export.size)
+
+	decompressed, err := newDecompressingReader(compressionZstd, bytes.NewReader(uploads["/images/volume.raw.zst"]))
+	require.NoError(t, err)
+	uploaded, err := io.ReadAll(decompressed)
+	require.NoError(t, err)
+	assert.Equal(t, content, uploaded)
+
+	// rejected uploads are errors
+	_, err = exportVolumeToURL(download, defaultHTTPOptions(), u, compressionNone, "sha256")
+	assert.ErrorContains(t, err, "unexpected response code 403")
+}
+
+func testAccCheckLibvirtVolumeExportFile(name string, path string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("not found: %s", name)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if checksum := sha256Digest(content); rs.Primary.Attributes["checksum"] != checksum {
+			return fmt.Errorf("checksum of %s is %s, expected %s", path, rs.Primary.Attributes["checksum"], checksum)
+		}
+		if _, err := os.Stat(path + ".sha256"); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestAccLibvirtVolumeExport_Basic(t *testing.T) {
+	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := t.TempDir()
+	exportPath := filepath.Join(t.TempDir(), "export.qcow2.gz")
+
+	imagePath, err := filepath.Abs("testdata/test.qcow2")
+	require.NoError(t, err)
+
+	config := func(trigger string) string {
+		return fmt.Sprintf(`
+		resource "libvirt_pool" "%[1]s" {
+			name = "%[1]s"
+			type = "dir"
+			path = "%[2]s"
+		}
+
+		resource "libvirt_volume" "%[1]s" {
+			name   = "%[1]s"
+			source = "%[3]s"
+			pool   = "${libvirt_pool.%[1]s.name}"
+		}
+
+		resource "libvirt_volume_export" "%[1]s" {
+			volume_id     = "${libvirt_volume.%[1]s.id}"
+			path          = "%[4]s"
+			compression   = "gzip"
+			checksum_file = true
+			triggers = {
+				build = "%[5]s"
+			}
+		}`, random, randomPoolPath, imagePath, exportPath, trigger)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config("1"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExportFile("libvirt_volume_export."+random, exportPath),
+				),
+			},
+			{
+				PreConfig: func() {
+					os.Remove(exportPath)
+				},
+				Config: config("2"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExportFile("libvirt_volume_export."+random, exportPath),
+				),
+			},
+		},
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 
 	libvirt "github.com/digitalocean/go-libvirt"
@@ -207,6 +208,66 @@ func TestAccLibvirtVolume_Basic(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtVolume_Resize(t *testing.T) {
+	var volume, resized libvirt.StorageVol
+	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := t.TempDir()
+
+	config := func(size int, allowShrink bool) string {
+		return fmt.Sprintf(`
+		resource "libvirt_pool" "%[1]s" {
+			name = "%[1]s"
+			type = "dir"
+			path = "%[2]s"
+		}
+
+		resource "libvirt_volume" "%[1]s" {
+			name         = "%[1]s"
+			size         = %[3]d
+			pool         = "${libvirt_pool.%[1]s.name}"
+			allow_shrink = %[4]t
+		}`, random, randomPoolPath, size, allowShrink)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config(1073741824, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExists("libvirt_volume."+random, &volume),
+					testAccCheckLibvirtVolumeExpectedCapacity("libvirt_volume."+random, 1073741824),
+				),
+			},
+			{
+				Config: config(2147483648, false),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExists("libvirt_volume."+random, &resized),
+					testAccCheckLibvirtVolumeExpectedCapacity("libvirt_volume."+random, 2147483648),
+					func(*terraform.State) error {
+						if resized.Key != volume.Key {
+							return fmt.Errorf("volume was recreated instead of resized")
+						}
+						return nil
+					},
+				),
+			},
+			{
+				Config:      config(1073741824, false),
+				ExpectError: regexp.MustCompile("can't shrink volume"),
+			},
+			{
+				Config: config(1073741824, true),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExpectedCapacity("libvirt_volume."+random, 1073741824),
+				),
+			},
+		},
+	})
+}
+
 func TestAccLibvirtVolume_BackingStoreTestByID(t *testing.T) {
 	var volume libvirt.StorageVol
 	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
@@ -587,6 +648,102 @@ func TestAccLibvirtVolume_DownloadFromSourceFormat(t *testing.T) {
 	})
 }
 
+func TestAccLibvirtVolume_LibvirtSource(t *testing.T) {
+	var copied libvirt.StorageVol
+	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := t.TempDir()
+	randomCopyPoolPath := t.TempDir()
+
+	imagePath, err := filepath.Abs("testdata/test.qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// copied from another pool of the provider's host
+	config := fmt.Sprintf(`
+	resource "libvirt_pool" "%[1]s" {
+		name = "%[1]s"
+		type = "dir"
+		path = "%[2]s"
+	}
+
+	resource "libvirt_pool" "%[1]s_copy" {
+		name = "%[1]s_copy"
+		type = "dir"
+		path = "%[3]s"
+	}
+
+	resource "libvirt_volume" "%[1]s" {
+		name   = "%[1]s"
+		source = "%[4]s"
+		pool   = "${libvirt_pool.%[1]s.name}"
+	}
+
+	resource "libvirt_volume" "%[1]s_copy" {
+		name   = "%[1]s_copy"
+		source = "libvirt:///${libvirt_pool.%[1]s.name}/${libvirt_volume.%[1]s.name}"
+		pool   = "${libvirt_pool.%[1]s_copy.name}"
+	}`, random, randomPoolPath, randomCopyPoolPath, imagePath)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckLibvirtVolumeExists("libvirt_volume."+random+"_copy", &copied),
+					testAccCheckLibvirtVolumeExpectedFormat("libvirt_volume."+random+"_copy", "qcow2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccLibvirtVolume_LibvirtSourceBackingStore(t *testing.T) {
+	random := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
+	randomPoolPath := t.TempDir()
+
+	// volumes with a backing store are not copied without it
+	config := fmt.Sprintf(`
+	resource "libvirt_pool" "%[1]s" {
+		name = "%[1]s"
+		type = "dir"
+		path = "%[2]s"
+	}
+
+	resource "libvirt_volume" "%[1]s_base" {
+		name = "%[1]s_base"
+		size = 1048576
+		pool = "${libvirt_pool.%[1]s.name}"
+	}
+
+	resource "libvirt_volume" "%[1]s" {
+		name           = "%[1]s"
+		base_volume_id = "${libvirt_volume.%[1]s_base.id}"
+		pool           = "${libvirt_pool.%[1]s.name}"
+	}
+
+	resource "libvirt_volume" "%[1]s_copy" {
+		name   = "%[1]s_copy"
+		source = "libvirt:///${libvirt_pool.%[1]s.name}/${libvirt_volume.%[1]s.name}"
+		pool   = "${libvirt_pool.%[1]s.name}"
+	}`, random, randomPoolPath)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckLibvirtVolumeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config:      config,
+				ExpectError: regexp.MustCompile("only volumes without a backing store"),
+			},
+		},
+	})
+}
+
 func TestAccLibvirtVolume_Format(t *testing.T) {
 	var volume libvirt.StorageVol
 	randomVolumeResource := acctest.RandStringFromCharSet(10, acctest.CharSetAlpha)
@@ -0,0 +1,228 @@
+package libvirt
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/beevik/etree"
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+// the tags of the resources are stored in the <metadata> element of their definition,
+// in a namespace owned by the provider:
+//
+//	<metadata>
+//	  <tf:tags xmlns:tf="https://github.com/dmacvicar/terraform-provider-libvirt/tags/1.0">
+//	    <tf:tag name="owner">alice</tf:tag>
+//	  </tf:tags>
+//	</metadata>
+const (
+	tagsMetadataNamespace = "https://github.com/dmacvicar/terraform-provider-libvirt/tags/1.0"
+	tagsMetadataPrefix    = "tf"
+)
+
+func tagsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeMap,
+		Optional: true,
+		Elem: &schema.Schema{
+			Type: schema.TypeString,
+		},
+	}
+}
+
+func sortedTagNames(tags map[string]interface{}) []string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTagsElement creates the tags element. Without prefix, the namespace is left for
+// libvirt to set, as DomainSetMetadata does.
+func newTagsElement(tags map[string]interface{}, prefix string) *etree.Element {
+	tag := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + ":" + name
+	}
+
+	element := etree.NewElement(tag("tags"))
+	if prefix != "" {
+		element.CreateAttr("xmlns:"+prefix, tagsMetadataNamespace)
+	}
+	for _, name := range sortedTagNames(tags) {
+		child := element.CreateElement(tag("tag"))
+		child.CreateAttr("name", name)
+		child.SetText(tags[name].(string))
+	}
+	return element
+}
+
+// tagsMetadataElement returns the tags as the metadata element expected by
+// DomainSetMetadata, or an empty string to remove them.
+func tagsMetadataElement(tags map[string]interface{}) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(newTagsElement(tags, ""))
+	return doc.WriteToString()
+}
+
+func parseMetadataXML(metadataXML string) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString("<metadata>" + metadataXML + "</metadata>"); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata XML: %w", err)
+	}
+	return doc.Root(), nil
+}
+
+func isTagsMetadataElement(element *etree.Element) bool {
+	return element.Tag == "tags" && element.NamespaceURI() == tagsMetadataNamespace
+}
+
+// setTagsMetadata replaces the tags in the inner XML of a <metadata> element, keeping
+// the metadata of other applications.
+func setTagsMetadata(metadataXML string, tags map[string]interface{}) (string, error) {
+	metadata, err := parseMetadataXML(metadataXML)
+	if err != nil {
+		return "", err
+	}
+
+	for _, child := range metadata.ChildElements() {
+		if isTagsMetadataElement(child) {
+			metadata.RemoveChild(child)
+		}
+	}
+	if len(tags) > 0 {
+		metadata.AddChild(newTagsElement(tags, tagsMetadataPrefix))
+	}
+
+	doc := etree.NewDocument()
+	for _, child := range metadata.ChildElements() {
+		doc.AddChild(child)
+	}
+	return doc.WriteToString()
+}
+
+// tagsFromMetadata reads the tags from the inner XML of a <metadata> element.
+func tagsFromMetadata(metadataXML string) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	metadata, err := parseMetadataXML(metadataXML)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range metadata.ChildElements() {
+		if !isTagsMetadataElement(child) {
+			continue
+		}
+		for _, tag := range child.SelectElements("tag") {
+			tags[tag.SelectAttrValue("name", "")] = tag.Text()
+		}
+	}
+	return tags, nil
+}
+
+// tagsMatch tells whether tags has all the tags of the filter.
+func tagsMatch(tags map[string]string, filter map[string]interface{}) bool {
+	for name, value := range filter {
+		if tagValue, ok := tags[name]; !ok || tagValue != value.(string) {
+			return false
+		}
+	}
+	return true
+}
+
+// setDomainTags replaces the tags of a domain, both in its persistent and live definitions.
+func setDomainTags(virConn *libvirt.Libvirt, domain libvirt.Domain, tags map[string]interface{}) error {
+	element, err := tagsMetadataElement(tags)
+	if err != nil {
+		return err
+	}
+
+	var metadata libvirt.OptString
+	if element != "" {
+		metadata = libvirt.OptString{element}
+	}
+
+	flags := libvirt.DomainAffectConfig
+	running, err := domainIsRunning(virConn, domain)
+	if err != nil {
+		return err
+	}
+	if running {
+		flags |= libvirt.DomainAffectLive
+	}
+
+	if err := virConn.DomainSetMetadata(domain, int32(libvirt.DomainMetadataElement), metadata,
+		libvirt.OptString{tagsMetadataPrefix}, libvirt.OptString{tagsMetadataNamespace}, flags); err != nil {
+		return fmt.Errorf("error setting tags of domain: %w", err)
+	}
+	return nil
+}
+
+// getDomainTags reads the tags of a domain without fetching its whole definition.
+func getDomainTags(virConn *libvirt.Libvirt, domain libvirt.Domain) (map[string]string, error) {
+	element, err := virConn.DomainGetMetadata(domain, int32(libvirt.DomainMetadataElement),
+		libvirt.OptString{tagsMetadataNamespace}, 0)
+	if err != nil {
+		if isError(err, libvirt.ErrNoDomainMetadata) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("error retrieving tags of domain: %w", err)
+	}
+	return tagsFromMetadata(element)
+}
+
+// setNetworkTags replaces the tags of a network by redefining it. go-libvirt has no
+// NetworkSetMetadata, and the metadata does not need the network to be restarted.
+func setNetworkTags(client *Client, network libvirt.Network, tags map[string]interface{}) error {
+	virConn := client.libvirt
+
+	// define only one network at a time
+	client.networkMutex.Lock()
+	defer client.networkMutex.Unlock()
+
+	networkDef, err := getXMLNetworkDefFromLibvirtFlags(virConn, network, uint32(libvirt.NetworkXMLInactive))
+	if err != nil {
+		return err
+	}
+
+	var metadataXML string
+	if networkDef.Metadata != nil {
+		metadataXML = networkDef.Metadata.XML
+	}
+	if metadataXML, err = setTagsMetadata(metadataXML, tags); err != nil {
+		return err
+	}
+	networkDef.Metadata = &libvirtxml.NetworkMetadata{XML: metadataXML}
+
+	data, err := xmlMarshallIndented(networkDef)
+	if err != nil {
+		return fmt.Errorf("error serializing libvirt network: %w", err)
+	}
+	if _, err := virConn.NetworkDefineXML(data); err != nil {
+		return fmt.Errorf("error setting tags of network: %w", err)
+	}
+	return nil
+}
+
+// getNetworkTags reads the tags from the persistent definition of a network.
+func getNetworkTags(virConn *libvirt.Libvirt, network libvirt.Network) (map[string]string, error) {
+	networkDef, err := getXMLNetworkDefFromLibvirtFlags(virConn, network, uint32(libvirt.NetworkXMLInactive))
+	if err != nil {
+		return nil, err
+	}
+	if networkDef.Metadata == nil {
+		return map[string]string{}, nil
+	}
+	return tagsFromMetadata(networkDef.Metadata.XML)
+}
@@ -0,0 +1,89 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetTagsMetadata(t *testing.T) {
+	other := `<app:config xmlns:app="http://example.com/app"><app:key>value</app:key></app:config>`
+
+	metadataXML, err := setTagsMetadata(other, map[string]interface{}{"owner": "alice", "cost-center": "42"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(metadataXML, "app:config") {
+		t.Errorf("expected the metadata of other applications to be kept: %s", metadataXML)
+	}
+
+	tags, err := tagsFromMetadata(metadataXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tags) != 2 || tags["owner"] != "alice" || tags["cost-center"] != "42" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	// replacing the tags does not duplicate the element
+	metadataXML, err = setTagsMetadata(metadataXML, map[string]interface{}{"owner": "bob"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Count(metadataXML, "<tf:tags") != 1 {
+		t.Errorf("expected exactly one tags element: %s", metadataXML)
+	}
+	tags, _ = tagsFromMetadata(metadataXML)
+	if len(tags) != 1 || tags["owner"] != "bob" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	// and removing them keeps the rest
+	metadataXML, err = setTagsMetadata(metadataXML, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(metadataXML, "tags") || !strings.Contains(metadataXML, "app:config") {
+		t.Errorf("expected only the tags to be removed: %s", metadataXML)
+	}
+}
+
+func TestTagsFromMetadata(t *testing.T) {
+	// as returned by DomainGetMetadata
+	tags, err := tagsFromMetadata(`<tags xmlns="` + tagsMetadataNamespace + `"><tag name="owner">alice</tag></tags>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tags["owner"] != "alice" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+
+	// tags elements of other namespaces are ignored
+	tags, err = tagsFromMetadata(`<tags xmlns="http://example.com/other"><tag name="owner">bob</tag></tags>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("expected no tags, got %v", tags)
+	}
+}
+
+func TestTagsMatch(t *testing.T) {
+	tags := map[string]string{"owner": "alice", "env": "lab"}
+
+	cases := []struct {
+		filter map[string]interface{}
+		match  bool
+	}{
+		{map[string]interface{}{}, true},
+		{map[string]interface{}{"owner": "alice"}, true},
+		{map[string]interface{}{"owner": "alice", "env": "lab"}, true},
+		{map[string]interface{}{"owner": "bob"}, false},
+		{map[string]interface{}{"team": "infra"}, false},
+	}
+
+	for _, c := range cases {
+		if tagsMatch(tags, c.filter) != c.match {
+			t.Errorf("expected tagsMatch(%v, %v) to be %v", tags, c.filter, c.match)
+		}
+	}
+}
@@ -44,6 +44,14 @@ func formatBoolYesNo(b bool) string {
 	return "no"
 }
 
+// formatBoolOnOff is similar to strconv.FormatBool with on/off instead of true/false.
+func formatBoolOnOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
 // analog to internal libvirt.checkError
 // IsNotFound in libvirt-go should be enhanced to detect the other types
 // (pool, network).
@@ -158,6 +158,31 @@ func splitKernelCmdLine(cmdLine string) []map[string]string {
 	return cmdLines
 }
 
+// splitKernelArgs splits a kernel command line in its arguments, keeping the
+// spaces inside double quotes, like in foo="a b".
+func splitKernelArgs(cmdLine string) []string {
+	var args []string
+	var arg strings.Builder
+	quoted := false
+	for _, r := range cmdLine {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+			continue
+		}
+		arg.WriteRune(r)
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
 func getHostArchitecture(virConn *libvirt.Libvirt) (string, error) {
 	type HostCapabilities struct {
 		XMLName xml.Name `xml:"capabilities"`
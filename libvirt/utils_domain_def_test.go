@@ -36,6 +36,18 @@ func TestSplitKernelEmptyCmdLine(t *testing.T) {
 	}
 }
 
+func TestSplitKernelArgs(t *testing.T) {
+	e := []string{"console=ttyS0", "root=/dev/vda1", `dyndbg="file foo.c +p"`, "rw"}
+	r := splitKernelArgs(`console=ttyS0  root=/dev/vda1 dyndbg="file foo.c +p" rw`)
+	if !reflect.DeepEqual(r, e) {
+		t.Fatalf("got='%s' expected='%s'", spew.Sdump(r), spew.Sdump(e))
+	}
+
+	if r := splitKernelArgs(""); r != nil {
+		t.Fatalf("expected no arguments, got='%s'", spew.Sdump(r))
+	}
+}
+
 func TestGetHostArchitecture(t *testing.T) {
 	skipIfAccDisabled(t)
 	conn := testAccProvider.Meta().(*Client).libvirt
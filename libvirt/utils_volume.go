@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -29,19 +30,35 @@ func newVolumeUploader(virConn *libvirt.Libvirt, volume *libvirt.StorageVol, siz
 }
 
 // returns a function you can give a writer to download the volume content
-// the function will return the downloaded size.
+// the function will return the downloaded size. Files get holes instead of
+// the runs of zeros of the volume.
 func newVolumeDownloader(virConn *libvirt.Libvirt, volume *libvirt.StorageVol) func(src io.Writer) error {
 	return func(dst io.Writer) error {
 		start := time.Now()
 
-		bufdst := bufio.NewWriter(dst)
+		var sparse *sparseFileWriter
+		if file, ok := dst.(*os.File); ok {
+			var err error
+			if sparse, err = newSparseFileWriter(file); err != nil {
+				return err
+			}
+			dst = sparse
+		}
+
+		bufdst := bufio.NewWriterSize(dst, copierBufferSize)
 		if err := virConn.StorageVolDownload(*volume, bufdst, 0, 0, 0); err != nil {
 			return fmt.Errorf("error while downloading volume: %w", err)
 		}
 
 		log.Printf("[DEBUG] download took %d ms", time.Since(start).Milliseconds())
 
-		return bufdst.Flush()
+		if err := bufdst.Flush(); err != nil {
+			return err
+		}
+		if sparse != nil {
+			return sparse.Close()
+		}
+		return nil
 	}
 }
 
@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd
+
+package libvirt
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileDataExtents returns the ranges of file with data, found with SEEK_DATA and
+// SEEK_HOLE, or nil when the filesystem does not support them.
+func fileDataExtents(file *os.File) ([]volumeExtent, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	extents := []volumeExtent{}
+	for offset := int64(0); offset < fi.Size(); {
+		data, err := file.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// no data after offset
+			break
+		} else if errors.Is(err, syscall.EINVAL) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		hole, err := file.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		extents = append(extents, volumeExtent{offset: data, length: hole - data})
+		offset = hole
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return extents, nil
+}
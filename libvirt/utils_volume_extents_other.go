@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package libvirt
+
+import (
+	"os"
+)
+
+// fileDataExtents can't find the holes of files on this platform.
+func fileDataExtents(file *os.File) ([]volumeExtent, error) {
+	return nil, nil
+}
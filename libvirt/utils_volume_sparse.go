@@ -0,0 +1,278 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// granularity of the zero detection
+	sparseBlockSize = 64 * 1024
+	// shorter runs of zeros are uploaded, as every segment of data costs an upload
+	// stream, which is cheaper than sending some megabytes of zeros
+	sparseMinHoleSize = 16 * 1024 * 1024
+)
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+func isZeroBlock(block []byte) bool {
+	return bytes.Equal(block, zeroBlock[:len(block)])
+}
+
+// volumeExtent is a range of a file with data.
+type volumeExtent struct {
+	offset int64
+	length int64
+}
+
+// isSparsePool returns whether the volumes of the pool are files, where what was
+// never written reads as zeros.
+func isSparsePool(poolDef libvirtxml.StoragePool) bool {
+	switch poolDef.Type {
+	case "dir", "fs", "netfs":
+		return true
+	}
+	return false
+}
+
+// sparseVolumeWriter uploads what is written to it to a volume, skipping the runs
+// of zeros, which are left as holes in the volume. The data between them is
+// uploaded in segments, each with its own upload stream.
+//
+// libvirt can do this itself with sparse streams, but go-libvirt does not handle
+// their hole packets, so the holes are skipped by uploading at offsets instead.
+type sparseVolumeWriter struct {
+	// upload uploads r to the volume, starting at offset
+	upload func(r io.Reader, offset uint64) error
+	// position in the volume after what was written
+	offset uint64
+	// zeros written at the end, not uploaded yet
+	zeros uint64
+	// the upload of the current segment, if any
+	segment *io.PipeWriter
+	done    chan error
+	// bytes uploaded, including zeros in the segments
+	uploaded uint64
+	// number of segments, each uploaded with its own stream
+	segments int
+}
+
+func (w *sparseVolumeWriter) startSegment() {
+	w.segments++
+	reader, writer := io.Pipe()
+	w.segment = writer
+	w.done = make(chan error, 1)
+	go func(offset uint64) {
+		err := w.upload(bufio.NewReaderSize(reader, copierBufferSize), offset)
+		reader.CloseWithError(err)
+		w.done <- err
+	}(w.offset)
+}
+
+func (w *sparseVolumeWriter) endSegment() error {
+	if w.segment == nil {
+		return nil
+	}
+	w.segment.Close()
+	w.segment = nil
+	return <-w.done
+}
+
+func (w *sparseVolumeWriter) writeSegment(p []byte) error {
+	n, err := w.segment.Write(p)
+	w.uploaded += uint64(n)
+	return err
+}
+
+// skip adds a hole of n bytes, like the ones found in sparse files.
+func (w *sparseVolumeWriter) skip(n uint64) error {
+	w.offset += n
+	w.zeros += n
+	if w.segment != nil && w.zeros >= sparseMinHoleSize {
+		return w.endSegment()
+	}
+	return nil
+}
+
+func (w *sparseVolumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		block := p
+		if len(block) > sparseBlockSize {
+			block = block[:sparseBlockSize]
+		}
+
+		if isZeroBlock(block) {
+			if err := w.skip(uint64(len(block))); err != nil {
+				return written, err
+			}
+		} else {
+			if w.segment == nil {
+				// the zeros before are a hole
+				w.zeros = 0
+				w.startSegment()
+			}
+			for ; w.zeros > 0; w.zeros -= min(w.zeros, sparseBlockSize) {
+				if err := w.writeSegment(zeroBlock[:min(w.zeros, sparseBlockSize)]); err != nil {
+					return written, err
+				}
+			}
+			if err := w.writeSegment(block); err != nil {
+				return written, err
+			}
+			w.offset += uint64(len(block))
+		}
+
+		written += len(block)
+		p = p[len(block):]
+	}
+	return written, nil
+}
+
+// Close ends the upload, leaving the zeros at the end as a hole.
+func (w *sparseVolumeWriter) Close() error {
+	return w.endSegment()
+}
+
+// newSparseVolumeUploader returns a function to upload an image to a volume, sending
+// only its data. The volume must read as zeros where nothing is written, like new
+// raw volumes in the pools accepted by isSparsePool. The holes of local files are
+// found with SEEK_DATA and SEEK_HOLE, and zeros are skipped in any other image.
+func newSparseVolumeUploader(virConn *libvirt.Libvirt, volume *libvirt.StorageVol) func(src io.Reader) error {
+	return func(src io.Reader) error {
+		start := time.Now()
+		w := &sparseVolumeWriter{
+			upload: func(r io.Reader, offset uint64) error {
+				return virConn.StorageVolUpload(*volume, r, offset, 0, 0)
+			},
+		}
+
+		if err := copySparse(w, src); err != nil {
+			w.Close()
+			return fmt.Errorf("error while uploading volume %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error while uploading volume %w", err)
+		}
+
+		log.Printf("[DEBUG] sparse upload of %d bytes, %d sent in %d segments, took %d ms",
+			w.offset, w.uploaded, w.segments, time.Since(start).Milliseconds())
+		return nil
+	}
+}
+
+// copySparse copies src to w, skipping the holes of src when it is a local file
+// and they can be found.
+func copySparse(w *sparseVolumeWriter, src io.Reader) error {
+	buf := make([]byte, copierBufferSize)
+
+	file, ok := src.(*os.File)
+	if !ok {
+		_, err := io.CopyBuffer(w, src, buf)
+		return err
+	}
+
+	extents, err := fileDataExtents(file)
+	if err != nil {
+		return err
+	}
+	if extents == nil {
+		// holes are not supported, rely on the zero detection
+		_, err := io.CopyBuffer(w, src, buf)
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for _, extent := range mergeVolumeExtents(extents, sparseMinHoleSize) {
+		if err := w.skip(uint64(extent.offset - offset)); err != nil {
+			return err
+		}
+		if _, err := file.Seek(extent.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyBuffer(w, io.LimitReader(file, extent.length), buf); err != nil {
+			return err
+		}
+		offset = extent.offset + extent.length
+	}
+	return w.skip(uint64(fi.Size() - offset))
+}
+
+// mergeVolumeExtents merges the extents separated by holes shorter than minHole, whose
+// zeros are uploaded with the data around them.
+func mergeVolumeExtents(extents []volumeExtent, minHole int64) []volumeExtent {
+	var merged []volumeExtent
+	for _, extent := range extents {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if extent.offset-(last.offset+last.length) < minHole {
+				last.length = extent.offset + extent.length - last.offset
+				continue
+			}
+		}
+		merged = append(merged, extent)
+	}
+	return merged
+}
+
+// sparseFileWriter writes to a file, leaving holes instead of writing zeros.
+type sparseFileWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func newSparseFileWriter(file *os.File) (*sparseFileWriter, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &sparseFileWriter{file: file, offset: offset}, nil
+}
+
+func (w *sparseFileWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		block := p
+		if len(block) > sparseBlockSize {
+			block = block[:sparseBlockSize]
+		}
+
+		if isZeroBlock(block) {
+			if _, err := w.file.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+				return written, err
+			}
+		} else if _, err := w.file.Write(block); err != nil {
+			return written, err
+		}
+
+		w.offset += int64(len(block))
+		written += len(block)
+		p = p[len(block):]
+	}
+	return written, nil
+}
+
+// Close gives the file its size when it ends with a hole.
+func (w *sparseFileWriter) Close() error {
+	fi, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < w.offset {
+		return w.file.Truncate(w.offset)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package libvirt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSparseContent returns data separated by a long and a short run of zeros, and
+// ending with zeros.
+func newSparseContent() []byte {
+	var content bytes.Buffer
+	content.Write(bytes.Repeat([]byte("data"), 25000))
+	content.Write(make([]byte, 3*sparseMinHoleSize))
+	content.Write(bytes.Repeat([]byte("more"), 2500))
+	content.Write(make([]byte, sparseBlockSize))
+	content.Write(bytes.Repeat([]byte("last"), 1250))
+	content.Write(make([]byte, 2*sparseMinHoleSize))
+	return content.Bytes()
+}
+
+// fakeVolume is a volume in memory, reading as zeros where nothing was written.
+type fakeVolume struct {
+	mu       sync.Mutex
+	content  []byte
+	segments int
+}
+
+func (v *fakeVolume) upload(r io.Reader, offset uint64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	copy(v.content[offset:], data)
+	v.segments++
+	return nil
+}
+
+func TestSparseVolumeWriter(t *testing.T) {
+	content := newSparseContent()
+
+	sparseFile := filepath.Join(t.TempDir(), "image.raw")
+	file, err := os.Create(sparseFile)
+	require.NoError(t, err)
+	_, err = io.Copy(&sparseFileWriter{file: file}, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, file.Truncate(int64(len(content))))
+	require.NoError(t, file.Close())
+
+	file, err = os.Open(sparseFile)
+	require.NoError(t, err)
+	defer file.Close()
+
+	// from a stream and from a sparse file
+	for _, src := range []io.Reader{bytes.NewReader(content), file} {
+		volume := &fakeVolume{content: make([]byte, len(content))}
+		w := &sparseVolumeWriter{upload: volume.upload}
+		require.NoError(t, copySparse(w, src))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, content, volume.content)
+		assert.Equal(t, uint64(len(content)), w.offset)
+		// the short run of zeros is uploaded, not the long ones
+		assert.Equal(t, 2, volume.segments)
+		assert.Less(t, w.uploaded, uint64(sparseMinHoleSize))
+	}
+}
+
+func TestSparseVolumeWriterFragmented(t *testing.T) {
+	// 64 runs of data separated by holes of about 1 MiB, and a long hole in the middle
+	const extents = 64
+	const stride = 1024 * 1024
+	size := extents*stride + 2*sparseMinHoleSize
+	content := make([]byte, size)
+	for i := 0; i < extents; i++ {
+		offset := i * stride
+		if i >= extents/2 {
+			offset += 2 * sparseMinHoleSize
+		}
+		copy(content[offset:], bytes.Repeat([]byte("data"), 1024))
+	}
+
+	sparseFile := filepath.Join(t.TempDir(), "image.raw")
+	file, err := os.Create(sparseFile)
+	require.NoError(t, err)
+	_, err = io.Copy(&sparseFileWriter{file: file}, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, file.Truncate(int64(len(content))))
+	require.NoError(t, file.Close())
+
+	file, err = os.Open(sparseFile)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for _, src := range []io.Reader{bytes.NewReader(content), file} {
+		volume := &fakeVolume{content: make([]byte, len(content))}
+		w := &sparseVolumeWriter{upload: volume.upload}
+		require.NoError(t, copySparse(w, src))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, content, volume.content)
+		// the short holes are uploaded with the data, only the long one splits it
+		assert.Equal(t, 2, volume.segments)
+		assert.Equal(t, 2, w.segments)
+	}
+}
+
+func TestMergeVolumeExtents(t *testing.T) {
+	extents := []volumeExtent{
+		{offset: 0, length: 4096},
+		{offset: 8192, length: 4096},
+		{offset: 100000, length: 4096},
+		{offset: 110000, length: 10},
+	}
+
+	assert.Equal(t, []volumeExtent{
+		{offset: 0, length: 12288},
+		{offset: 100000, length: 10010},
+	}, mergeVolumeExtents(extents, 65536))
+	assert.Equal(t, extents, mergeVolumeExtents(extents, 4096))
+	assert.Nil(t, mergeVolumeExtents(nil, 65536))
+}
+
+func TestSparseFileWriter(t *testing.T) {
+	content := newSparseContent()
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "volume.raw"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	w, err := newSparseFileWriter(file)
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	written, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+}
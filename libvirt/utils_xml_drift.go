@@ -0,0 +1,247 @@
+package libvirt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// canonicalXMLElement serializes an element ignoring whitespace and attribute order.
+func canonicalXMLElement(element *etree.Element) string {
+	var sb strings.Builder
+	sb.WriteString("<" + element.FullTag())
+
+	attrs := make([]string, 0, len(element.Attr))
+	for _, attr := range element.Attr {
+		attrs = append(attrs, fmt.Sprintf(" %s=%q", attr.FullKey(), attr.Value))
+	}
+	sort.Strings(attrs)
+	sb.WriteString(strings.Join(attrs, ""))
+	sb.WriteString(">")
+
+	sb.WriteString(strings.TrimSpace(element.Text()))
+	for _, child := range element.ChildElements() {
+		sb.WriteString(canonicalXMLElement(child))
+	}
+
+	sb.WriteString("</" + element.FullTag() + ">")
+	return sb.String()
+}
+
+// canonicalXMLElementSelf is like canonicalXMLElement but ignores the child elements.
+func canonicalXMLElementSelf(element *etree.Element) string {
+	shallow := element.Copy()
+	for _, child := range shallow.ChildElements() {
+		shallow.RemoveChild(child)
+	}
+	return canonicalXMLElement(shallow)
+}
+
+// indexedXMLChildren returns the child elements of element by a path segment
+// identifying them, like disk[2] for the second disk child.
+func indexedXMLChildren(element *etree.Element) ([]string, map[string]*etree.Element) {
+	var segments []string
+	children := make(map[string]*etree.Element)
+	count := make(map[string]int)
+	for _, child := range element.ChildElements() {
+		count[child.FullTag()]++
+		segment := fmt.Sprintf("%s[%d]", child.FullTag(), count[child.FullTag()])
+		segments = append(segments, segment)
+		children[segment] = child
+	}
+	return segments, children
+}
+
+func collectTransformedXMLPaths(original, transformed *etree.Element, path string, paths []string) []string {
+	if canonicalXMLElementSelf(original) != canonicalXMLElementSelf(transformed) {
+		return append(paths, path)
+	}
+
+	originalSegments, originalChildren := indexedXMLChildren(original)
+	transformedSegments, transformedChildren := indexedXMLChildren(transformed)
+
+	for _, segment := range transformedSegments {
+		originalChild, ok := originalChildren[segment]
+		switch {
+		case !ok:
+			paths = append(paths, path+"/"+segment)
+		case canonicalXMLElement(originalChild) != canonicalXMLElement(transformedChildren[segment]):
+			paths = collectTransformedXMLPaths(originalChild, transformedChildren[segment], path+"/"+segment, paths)
+		}
+	}
+
+	// elements removed by the transformation
+	for _, segment := range originalSegments {
+		if _, ok := transformedChildren[segment]; !ok {
+			paths = append(paths, path+"/"+segment)
+		}
+	}
+
+	return paths
+}
+
+// transformedXMLPaths returns the paths of the smallest subtrees which differ between
+// the original and the transformed XML documents.
+func transformedXMLPaths(original, transformed string) ([]string, error) {
+	originalDoc := etree.NewDocument()
+	if err := originalDoc.ReadFromString(original); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	transformedDoc := etree.NewDocument()
+	if err := transformedDoc.ReadFromString(transformed); err != nil {
+		return nil, fmt.Errorf("failed to parse transformed XML: %w", err)
+	}
+
+	originalRoot, transformedRoot := originalDoc.Root(), transformedDoc.Root()
+	if originalRoot == nil || transformedRoot == nil {
+		return nil, fmt.Errorf("XML document without root element")
+	}
+
+	if originalRoot.FullTag() != transformedRoot.FullTag() {
+		return []string{"/" + transformedRoot.FullTag()}, nil
+	}
+	return collectTransformedXMLPaths(originalRoot, transformedRoot, "/"+transformedRoot.FullTag(), nil), nil
+}
+
+// hashXMLPaths hashes the normalized subtrees of the XML document at the given paths.
+func hashXMLPaths(xmlS string, paths []string) (string, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlS); err != nil {
+		return "", fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	hash := sha256.New()
+	for _, path := range paths {
+		compiled, err := etree.CompilePath(path)
+		if err != nil {
+			return "", fmt.Errorf("invalid path '%s': %w", path, err)
+		}
+
+		fmt.Fprintf(hash, "%s\n", path)
+		if element := doc.FindElementPath(compiled); element != nil {
+			fmt.Fprintf(hash, "%s\n", canonicalXMLElement(element))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// xmlDriftSchema is the computed attribute set by checkTransformedXMLDrift when the
+// parts of the definition changed by the xml block were modified outside of terraform.
+func xmlDriftSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeBool,
+		Computed: true,
+	}
+}
+
+// setTransformedXMLPaths remembers in the xml block of the resource which parts of
+// the definition were changed by transformResourceXML, so that Read can detect changes
+// made to them outside of terraform with checkTransformedXMLDrift.
+func setTransformedXMLPaths(d *schema.ResourceData, original, transformed string) error {
+	if _, ok := d.GetOk("xml.0"); !ok || original == transformed {
+		return nil
+	}
+
+	paths, err := transformedXMLPaths(original, transformed)
+	if err != nil {
+		return err
+	}
+
+	xmlBlock := d.Get("xml.0").(map[string]interface{})
+	xmlBlock["transformed_paths"] = paths
+	return d.Set("xml", []interface{}{xmlBlock})
+}
+
+func transformedXMLPathsFromResource(d *schema.ResourceData) []string {
+	var paths []string
+	for _, path := range d.Get("xml.0.transformed_paths").([]interface{}) {
+		paths = append(paths, path.(string))
+	}
+	return paths
+}
+
+// setTransformedXMLHash hashes the parts of libvirtXML changed by the transformation,
+// as libvirt stored them when the transformed XML was defined. It is the baseline
+// checkTransformedXMLDrift compares with.
+func setTransformedXMLHash(d *schema.ResourceData, libvirtXML string) error {
+	paths := transformedXMLPathsFromResource(d)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	hash, err := hashXMLPaths(libvirtXML, paths)
+	if err != nil {
+		return err
+	}
+
+	xmlBlock := d.Get("xml.0").(map[string]interface{})
+	xmlBlock["transformed_hash"] = hash
+	if err := d.Set("xml", []interface{}{xmlBlock}); err != nil {
+		return err
+	}
+	return d.Set("xml_drift", false)
+}
+
+// checkTransformedXMLDrift compares the parts of the definition changed by the
+// transformation with the ones from libvirtXML, and sets xml_drift when they changed
+// since the transformed XML was defined. customizeXMLDriftDiff then plans an update
+// applying the transformation again.
+func checkTransformedXMLDrift(d *schema.ResourceData, libvirtXML string) error {
+	paths := transformedXMLPathsFromResource(d)
+	storedHash := d.Get("xml.0.transformed_hash").(string)
+	if len(paths) == 0 || storedHash == "" {
+		return nil
+	}
+
+	hash, err := hashXMLPaths(libvirtXML, paths)
+	if err != nil {
+		return err
+	}
+
+	drift := hash != storedHash
+	if drift {
+		log.Printf("[WARN] the parts of the XML definition changed by the xml block were modified outside of terraform")
+	}
+	return d.Set("xml_drift", drift)
+}
+
+// customizeXMLDriftDiff plans to clear xml_drift, so that Update applies the xml
+// block again with reapplyTransformedXML. Volumes, whose definition can't be changed,
+// only report the drift.
+func customizeXMLDriftDiff(diff *schema.ResourceDiff) error {
+	if diff.Id() == "" || !diff.Get("xml_drift").(bool) {
+		return nil
+	}
+	return diff.SetNew("xml_drift", false)
+}
+
+// reapplyTransformedXML applies the xml block of the resource again to the definition
+// stored by libvirt, returned by getXML, and defines the result with defineXML. That
+// definition already went through the transformation, so its patch operations are
+// applied with reapplyXMLPatch.
+func reapplyTransformedXML(d *schema.ResourceData, getXML func() (string, error), defineXML func(string) error) error {
+	libvirtXML, err := getXML()
+	if err != nil {
+		return err
+	}
+
+	data, err := retransformResourceXML(libvirtXML, d)
+	if err != nil {
+		return fmt.Errorf("error applying XSLT stylesheet: %w", err)
+	}
+	log.Printf("[DEBUG] Defining the XML transformed again:\n%s", data)
+	if err := defineXML(data); err != nil {
+		return err
+	}
+
+	if libvirtXML, err = getXML(); err != nil {
+		return err
+	}
+	return setTransformedXMLHash(d, libvirtXML)
+}
@@ -0,0 +1,162 @@
+package libvirt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const testDriftOriginalXML = `<domain type="kvm">
+  <name>test</name>
+  <devices>
+    <interface type="network">
+      <model type="virtio"/>
+    </interface>
+    <interface type="network">
+      <model type="virtio"/>
+    </interface>
+    <graphics type="spice"/>
+  </devices>
+</domain>`
+
+const testDriftTransformedXML = `<domain type="kvm">
+  <name>test</name>
+  <devices>
+    <interface type="network">
+      <model type="virtio"/>
+    </interface>
+    <interface type="network">
+      <model type="e1000"/>
+    </interface>
+    <watchdog model="i6300esb"/>
+  </devices>
+</domain>`
+
+func TestTransformedXMLPaths(t *testing.T) {
+	paths, err := transformedXMLPaths(testDriftOriginalXML, testDriftTransformedXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{
+		"/domain/devices[1]/interface[2]/model[1]",
+		"/domain/devices[1]/watchdog[1]",
+		"/domain/devices[1]/graphics[1]",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected paths %v, got %v", expected, paths)
+	}
+
+	paths, err = transformedXMLPaths(testDriftOriginalXML, testDriftOriginalXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(paths) != 0 {
+		t.Errorf("expected no paths for an identity transformation, got %v", paths)
+	}
+}
+
+func TestHashXMLPaths(t *testing.T) {
+	paths := []string{"/domain/devices[1]/interface[2]/model[1]", "/domain/devices[1]/watchdog[1]"}
+
+	hash, err := hashXMLPaths(testDriftTransformedXML, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// libvirt adding elements elsewhere and reformatting does not matter
+	reformatted := `<domain type="kvm"><name>test</name><devices>
+<interface type="network"><model type="virtio"/><alias name="net0"/></interface>
+<interface type="network"><model   type="e1000" /></interface>
+<watchdog model="i6300esb"></watchdog><memballoon model="virtio"/></devices></domain>`
+	reformattedHash, err := hashXMLPaths(reformatted, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if hash != reformattedHash {
+		t.Errorf("expected changes outside of the paths to be ignored")
+	}
+
+	driftedHash, err := hashXMLPaths(testDriftOriginalXML, paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if hash == driftedHash {
+		t.Errorf("expected changes in the paths to change the hash")
+	}
+}
+
+func TestTransformedXMLDrift(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceLibvirtDomain().Schema, map[string]interface{}{
+		"name": "test",
+		"xml": []interface{}{
+			map[string]interface{}{
+				"patch": []interface{}{
+					map[string]interface{}{
+						"op":    "replace",
+						"xpath": "/domain/devices/interface[2]/model/@type",
+						"value": "e1000",
+					},
+				},
+			},
+		},
+	})
+
+	transformed, err := transformResourceXML(testDriftOriginalXML, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := setTransformedXMLPaths(d, testDriftOriginalXML, transformed); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the baseline is what libvirt stored at creation
+	stored := strings.Replace(transformed, "<name>test</name>", "<name>test</name><uuid>1</uuid>", 1)
+	if err := setTransformedXMLHash(d, stored); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Get("xml.0.transformed_hash").(string) == "" {
+		t.Errorf("expected the hash to be set at creation")
+	}
+
+	if err := checkTransformedXMLDrift(d, stored); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Get("xml_drift").(bool) {
+		t.Errorf("expected no drift for the stored definition")
+	}
+
+	drifted := strings.Replace(stored, "e1000", "rtl8139", 1)
+	if err := checkTransformedXMLDrift(d, drifted); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !d.Get("xml_drift").(bool) {
+		t.Errorf("expected drift to be detected")
+	}
+	if len(d.Get("xml.0.patch").([]interface{})) != 1 {
+		t.Errorf("expected the xml block to be kept on drift")
+	}
+
+	// applying the block again restores the changed parts
+	defined := drifted
+	err = reapplyTransformedXML(d, func() (string, error) {
+		return defined, nil
+	}, func(data string) error {
+		defined = data
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(defined, "e1000") || strings.Contains(defined, "rtl8139") {
+		t.Errorf("expected the patch to be applied again, got %s", defined)
+	}
+	if err := checkTransformedXMLDrift(d, defined); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.Get("xml_drift").(bool) {
+		t.Errorf("expected no drift after applying the xml block again")
+	}
+}
@@ -0,0 +1,247 @@
+package libvirt
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// xmlPatchSchema is the schema of the patch operations of the xml block of the resources.
+func xmlPatchSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"op": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: true,
+				},
+				"xpath": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: true,
+				},
+				"value": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
+
+type xmlPatchOp struct {
+	Op    string
+	XPath string
+	Value string
+}
+
+// splitXMLPatchPath splits a path ending with /@name into the element path and the
+// attribute name.
+func splitXMLPatchPath(path string) (string, string) {
+	idx := strings.LastIndex(path, "/@")
+	if idx < 0 || strings.ContainsAny(path[idx+2:], "[]/") {
+		return path, ""
+	}
+	elementPath := path[:idx]
+	if elementPath == "" {
+		elementPath = "."
+	}
+	return elementPath, path[idx+2:]
+}
+
+// parseXMLFragment parses value into a list of elements. The fragment can have more
+// than one root element.
+func parseXMLFragment(value string) ([]*etree.Element, error) {
+	fragment := etree.NewDocument()
+	if err := fragment.ReadFromString("<fragment>" + value + "</fragment>"); err != nil {
+		return nil, fmt.Errorf("invalid XML fragment %s: %w", value, err)
+	}
+	return fragment.Root().ChildElements(), nil
+}
+
+// patchXML applies the patch operations to the xml data, in order.
+//
+// The paths are evaluated with the XPath subset supported by etree, for example
+// /domain/devices/disk[@device='cdrom']. A path ending with /@name addresses an
+// attribute of the matching elements.
+//
+//   - add: appends the XML fragment in value to the matching elements, or sets the
+//     attribute to value
+//   - replace: replaces the matching elements with the XML fragment in value, or the
+//     value of the existing attribute
+//   - remove: removes the matching elements or attribute
+//
+// Every operation must match at least one element.
+func patchXML(xmlS string, ops []xmlPatchOp) (string, error) {
+	return applyXMLPatch(xmlS, ops, false)
+}
+
+// reapplyXMLPatch applies the patch operations again to xml data they were already
+// applied to, like the definition stored by libvirt. Applying them any number of times
+// gives the same result:
+//
+//   - add: skips the elements of the fragment already in the matching elements
+//   - replace: skips paths not matching anything anymore, and sets attributes even
+//     when they were removed
+//   - remove: skips paths not matching anything anymore
+func reapplyXMLPatch(xmlS string, ops []xmlPatchOp) (string, error) {
+	return applyXMLPatch(xmlS, ops, true)
+}
+
+func applyXMLPatch(xmlS string, ops []xmlPatchOp, reapply bool) (string, error) {
+	if len(ops) == 0 {
+		return xmlS, nil
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlS); err != nil {
+		return "", fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	for _, op := range ops {
+		elementPath, attr := splitXMLPatchPath(op.XPath)
+		path, err := etree.CompilePath(elementPath)
+		if err != nil {
+			return "", fmt.Errorf("invalid xpath '%s': %w", op.XPath, err)
+		}
+
+		elements := doc.FindElementsPath(path)
+		if len(elements) == 0 {
+			if reapply && (op.Op == "replace" || op.Op == "remove") {
+				continue
+			}
+			return "", fmt.Errorf("xpath '%s' of %s operation does not match anything", op.XPath, op.Op)
+		}
+
+		if attr != "" {
+			err = patchXMLAttr(elements, attr, op, reapply)
+		} else {
+			err = patchXMLElements(elements, op, reapply)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	doc.Indent(2) //nolint:mnd
+	patched, err := doc.WriteToString()
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[DEBUG] Patched XML with user specified operations:\n%s", patched)
+
+	return patched, nil
+}
+
+func patchXMLAttr(elements []*etree.Element, attr string, op xmlPatchOp, reapply bool) error {
+	for _, element := range elements {
+		switch op.Op {
+		case "add":
+			element.CreateAttr(attr, op.Value)
+		case "replace":
+			if element.SelectAttr(attr) == nil && !reapply {
+				return fmt.Errorf("attribute of xpath '%s' can't be replaced, it does not exist", op.XPath)
+			}
+			element.CreateAttr(attr, op.Value)
+		case "remove":
+			if element.RemoveAttr(attr) == nil && !reapply {
+				return fmt.Errorf("attribute of xpath '%s' can't be removed, it does not exist", op.XPath)
+			}
+		default:
+			return fmt.Errorf("invalid XML patch operation '%s', must be 'add', 'replace' or 'remove'", op.Op)
+		}
+	}
+	return nil
+}
+
+func patchXMLElements(elements []*etree.Element, op xmlPatchOp, reapply bool) error {
+	var fragment []*etree.Element
+	if op.Op == "add" || op.Op == "replace" {
+		var err error
+		if fragment, err = parseXMLFragment(op.Value); err != nil {
+			return err
+		}
+		if op.Op == "add" && len(fragment) == 0 {
+			return fmt.Errorf("add operation of xpath '%s' requires an XML fragment as value", op.XPath)
+		}
+	}
+
+	for _, element := range elements {
+		switch op.Op {
+		case "add":
+			for _, child := range fragment {
+				if reapply && hasXMLChild(element, child) {
+					continue
+				}
+				element.AddChild(child.Copy())
+			}
+		case "replace", "remove":
+			parent := element.Parent()
+			if parent == nil || (parent.Parent() == nil && parent.Tag == "") {
+				return fmt.Errorf("xpath '%s' of %s operation can't address the root element", op.XPath, op.Op)
+			}
+			index := element.Index()
+			parent.RemoveChildAt(index)
+			if op.Op == "replace" {
+				for i, child := range fragment {
+					parent.InsertChildAt(index+i, child.Copy())
+				}
+			}
+		default:
+			return fmt.Errorf("invalid XML patch operation '%s', must be 'add', 'replace' or 'remove'", op.Op)
+		}
+	}
+	return nil
+}
+
+// hasXMLChild returns whether element has a child equal to child, ignoring whitespace
+// and the order of the attributes.
+func hasXMLChild(element, child *etree.Element) bool {
+	canonical := canonicalXMLElement(child)
+	for _, existing := range element.ChildElements() {
+		if canonicalXMLElement(existing) == canonical {
+			return true
+		}
+	}
+	return false
+}
+
+// stripXMLElements removes the elements matching any of the paths, if any.
+func stripXMLElements(xmlS string, paths ...string) (string, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(xmlS); err != nil {
+		return "", fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	for _, path := range paths {
+		for _, element := range doc.FindElements(path) {
+			if parent := element.Parent(); parent != nil {
+				parent.RemoveChild(element)
+			}
+		}
+	}
+
+	return doc.WriteToString()
+}
+
+// xmlPatchOpsFromResource reads the xml.0.patch operations of a resource.
+func xmlPatchOpsFromResource(d *schema.ResourceData) []xmlPatchOp {
+	var ops []xmlPatchOp
+	for i := 0; i < d.Get("xml.0.patch.#").(int); i++ {
+		prefix := fmt.Sprintf("xml.0.patch.%d", i)
+		ops = append(ops, xmlPatchOp{
+			Op:    d.Get(prefix + ".op").(string),
+			XPath: d.Get(prefix + ".xpath").(string),
+			Value: d.Get(prefix + ".value").(string),
+		})
+	}
+	return ops
+}
@@ -0,0 +1,145 @@
+package libvirt
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPatchDomainXML = `<domain type="kvm">
+  <name>test</name>
+  <devices>
+    <disk type="file" device="cdrom">
+      <target dev="hda" bus="ide"/>
+    </disk>
+    <disk type="file" device="disk">
+      <target dev="vda" bus="virtio"/>
+    </disk>
+    <graphics type="spice" autoport="yes"/>
+  </devices>
+</domain>`
+
+func TestPatchXML(t *testing.T) {
+	patched, err := patchXML(testPatchDomainXML, []xmlPatchOp{
+		{Op: "replace", XPath: "/domain/devices/disk[@device='cdrom']/target/@bus", Value: "sata"},
+		{Op: "add", XPath: "/domain/devices", Value: `<watchdog model="i6300esb"/><sound model="ich9"/>`},
+		{Op: "remove", XPath: "/domain/devices/graphics"},
+		{Op: "replace", XPath: "/domain/name", Value: "<name>patched</name>"},
+		{Op: "add", XPath: "/domain/@id", Value: "4"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		`<target dev="hda" bus="sata"/>`,
+		`<target dev="vda" bus="virtio"/>`,
+		`<watchdog model="i6300esb"/>`,
+		`<sound model="ich9"/>`,
+		`<name>patched</name>`,
+		`<domain type="kvm" id="4">`,
+	} {
+		if !strings.Contains(patched, expected) {
+			t.Errorf("expected %s in patched XML:\n%s", expected, patched)
+		}
+	}
+	if strings.Contains(patched, "graphics") {
+		t.Errorf("expected graphics to be removed:\n%s", patched)
+	}
+}
+
+func TestPatchXMLErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		op   xmlPatchOp
+	}{
+		{"no match", xmlPatchOp{Op: "remove", XPath: "/domain/devices/tpm"}},
+		{"missing attribute", xmlPatchOp{Op: "replace", XPath: "/domain/@id", Value: "1"}},
+		{"invalid operation", xmlPatchOp{Op: "move", XPath: "/domain/name"}},
+		{"invalid fragment", xmlPatchOp{Op: "add", XPath: "/domain/devices", Value: "<watchdog>"}},
+		{"empty fragment", xmlPatchOp{Op: "add", XPath: "/domain/devices", Value: ""}},
+		{"root element", xmlPatchOp{Op: "remove", XPath: "/domain"}},
+		{"invalid xpath", xmlPatchOp{Op: "remove", XPath: "/domain/devices/disk[@device="}},
+	}
+
+	for _, c := range cases {
+		if _, err := patchXML(testPatchDomainXML, []xmlPatchOp{c.op}); err == nil {
+			t.Errorf("%s: expected an error for %+v", c.name, c.op)
+		}
+	}
+}
+
+func TestPatchXMLNoOps(t *testing.T) {
+	patched, err := patchXML(testPatchDomainXML, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if patched != testPatchDomainXML {
+		t.Errorf("expected the XML to be left untouched without operations")
+	}
+}
+
+func TestStripXMLElements(t *testing.T) {
+	volumeXML := `<volume type="file">
+  <name>test.qcow2</name>
+  <capacity unit="bytes">1073741824</capacity>
+  <allocation unit="bytes">200704</allocation>
+  <target>
+    <path>/pool/test.qcow2</path>
+    <timestamps>
+      <atime>1700000000.1</atime>
+    </timestamps>
+  </target>
+</volume>`
+
+	stripped, err := stripXMLElements(volumeXML, "/volume/allocation", "/volume/physical", "/volume/target/timestamps")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, removed := range []string{"allocation", "timestamps"} {
+		if strings.Contains(stripped, removed) {
+			t.Errorf("expected %s to be removed from %s", removed, stripped)
+		}
+	}
+	if !strings.Contains(stripped, "<capacity") || !strings.Contains(stripped, "<path>") {
+		t.Errorf("expected the other elements to be kept in %s", stripped)
+	}
+}
+
+func TestReapplyXMLPatch(t *testing.T) {
+	ops := []xmlPatchOp{
+		{Op: "replace", XPath: "/domain/devices/disk[@device='cdrom']/target/@bus", Value: "sata"},
+		{Op: "add", XPath: "/domain/devices", Value: `<watchdog model="i6300esb"/><sound model="ich9"/>`},
+		{Op: "remove", XPath: "/domain/devices/graphics"},
+		{Op: "replace", XPath: "/domain/devices/disk[@device='disk']", Value: `<disk type="file" device="lun"><target dev="sda"/></disk>`},
+		{Op: "add", XPath: "/domain/@id", Value: "4"},
+	}
+
+	patched, err := patchXML(testPatchDomainXML, ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the operations can't be applied twice as such
+	if _, err := patchXML(patched, ops); err == nil {
+		t.Errorf("expected an error applying the remove operation again")
+	}
+
+	again, err := reapplyXMLPatch(patched, ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if again != patched {
+		t.Errorf("expected applying the patch again to change nothing, got:\n%s\ninstead of:\n%s", again, patched)
+	}
+
+	// the parts changed since the patch was applied are restored
+	drifted := strings.Replace(patched, `bus="sata"`, `bus="ide"`, 1)
+	drifted = strings.Replace(drifted, `<sound model="ich9"/>`, "", 1)
+	restored, err := reapplyXMLPatch(drifted, ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if restored != patched {
+		t.Errorf("expected the patch to be applied again, got:\n%s\ninstead of:\n%s", restored, patched)
+	}
+}
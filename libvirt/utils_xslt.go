@@ -97,14 +97,29 @@ func transformXML(xmlS string, xsltS string) (string, error) {
 	return string(transformedXML), err
 }
 
-// this function applies a XSLT transform to the xml data
+// this function applies a XSLT transform and the patch operations to the xml data
 // and is to be reused in all resource types
-// your resource need to have a xml.xslt element in the schema.
+// your resource need to have a xml.xslt and xml.patch elements in the schema.
 func transformResourceXML(xml string, d *schema.ResourceData) (string, error) {
-	xslt, ok := d.GetOk("xml.0.xslt")
-	if !ok {
-		return xml, nil
+	if xslt, ok := d.GetOk("xml.0.xslt"); ok {
+		var err error
+		if xml, err = transformXML(xml, xslt.(string)); err != nil {
+			return xml, err
+		}
 	}
 
-	return transformXML(xml, xslt.(string))
+	return patchXML(xml, xmlPatchOpsFromResource(d))
+}
+
+// retransformResourceXML is like transformResourceXML for xml data the transformation
+// was already applied to, see reapplyXMLPatch. The XSLT stylesheet is just applied again.
+func retransformResourceXML(xml string, d *schema.ResourceData) (string, error) {
+	if xslt, ok := d.GetOk("xml.0.xslt"); ok {
+		var err error
+		if xml, err = transformXML(xml, xslt.(string)); err != nil {
+			return xml, err
+		}
+	}
+
+	return reapplyXMLPatch(xml, xmlPatchOpsFromResource(d))
 }
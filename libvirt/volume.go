@@ -142,9 +142,28 @@ func volumeDelete(ctx context.Context, client *Client, key string) error {
 	return nil
 }
 
-// volumeRequiresResize checks whether a volume needs resizing after being created with StorageVolCreateXMLFrom.
-// StorageVolCreateXMLFrom may ignore requested volume capacity in some cases. For example when qcow2 is involved,
-// libvirt clones the volume using `qemu-img convert` which creates a new volume with the same capacity as the original.
+// volumeResize sets the capacity of a volume to size bytes. With allocate, the new
+// space is allocated instead of left sparse. Volumes are only made smaller with shrink.
+func volumeResize(virConn *libvirt.Libvirt, volume libvirt.StorageVol, size uint64, allocate bool, shrink bool) error {
+	var flags libvirt.StorageVolResizeFlags
+	if allocate {
+		flags |= libvirt.StorageVolResizeAllocate
+	}
+	if shrink {
+		flags |= libvirt.StorageVolResizeShrink
+	}
+
+	if err := virConn.StorageVolResize(volume, size, flags); err != nil {
+		return fmt.Errorf("failed to resize volume '%s': %w", volume.Key, err)
+	}
+	log.Printf("[INFO] Volume '%s' successfully resized", volume.Key)
+	return nil
+}
+
+// volumeRequiresResize checks whether a volume needs resizing to its size, either after the size was updated, or
+// after being created with StorageVolCreateXMLFrom. StorageVolCreateXMLFrom may ignore requested volume capacity in
+// some cases. For example when qcow2 is involved, libvirt clones the volume using `qemu-img convert` which creates a
+// new volume with the same capacity as the original.
 func volumeRequiresResize(
 	virConn *libvirt.Libvirt,
 	d *schema.ResourceData,
@@ -152,7 +171,8 @@ func volumeRequiresResize(
 	baseVolume libvirt.StorageVol,
 	volumePool libvirt.StoragePool,
 ) (bool, diag.Diagnostics) {
-	if !d.Get("base_volume_copy").(bool) {
+	updated := !d.IsNewResource() && d.HasChange("size")
+	if !updated && !d.Get("base_volume_copy").(bool) {
 		return false, nil
 	}
 
@@ -166,6 +186,11 @@ func volumeRequiresResize(
 		return false, diag.Errorf("could not get volume '%s' xml definition: %s", volume.Name, err)
 	}
 
+	// an updated size is applied unless the volume has it already
+	if updated {
+		return volumeXML.Capacity == nil || uint64(size.(int)) != volumeXML.Capacity.Value*UnitsMap[volumeXML.Capacity.Unit], nil
+	}
+
 	baseVolumeXML, err := newDefVolumeFromLibvirt(virConn, baseVolume)
 	if err != nil {
 		return false, diag.Errorf("could not get volume '%s' xml definition: %s", baseVolume.Name, err)
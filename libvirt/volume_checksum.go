@@ -0,0 +1,219 @@
+package libvirt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"libvirt.org/go/libvirtxml"
+)
+
+func sourceChecksumSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"algorithm": {
+					Type:     schema.TypeString,
+					Optional: true,
+					Default:  "sha256",
+					ForceNew: true,
+				},
+				"value": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"url": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+				"entry": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: true,
+				},
+			},
+		},
+	}
+}
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm '%s', must be 'sha256' or 'sha512'", algorithm)
+}
+
+// parseChecksumFile finds the checksum of entry in a checksum file, either in the
+// format of sha256sum ("<checksum>  <name>", with a '*' before binary names) or in
+// the BSD one ("SHA256 (<name>) = <checksum>").
+func parseChecksumFile(r io.Reader, entry string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if open := strings.Index(line, " ("); open > 0 {
+			if end := strings.LastIndex(line, ") = "); end > open {
+				if line[open+2:end] == entry {
+					return line[end+4:], nil
+				}
+				continue
+			}
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == entry {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum found for '%s'", entry)
+}
+
+// fetchChecksum downloads the checksum file at checksumURL, which can be anything
+// a volume source can be, and returns the checksum of entry.
+func fetchChecksum(checksumURL string, entry string, options *httpOptions) (string, error) {
+	img, err := newImageWithOptions(checksumURL, options)
+	if err != nil {
+		return "", err
+	}
+
+	var content bytes.Buffer
+	if err := img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&content, r)
+		return err
+	}, newDefVolume()); err != nil {
+		return "", err
+	}
+
+	checksum, err := parseChecksumFile(&content, entry)
+	if err != nil {
+		return "", fmt.Errorf("error reading checksum file %s: %w", checksumURL, err)
+	}
+	return checksum, nil
+}
+
+// expectedSourceDigest returns the digest the source of a volume must have, as
+// "<algorithm>:<checksum>", from the settings of its source_checksum block. Checksum
+// files are downloaded with the same options as the source.
+func expectedSourceDigest(checksum map[string]interface{}, source string, options *httpOptions) (string, error) {
+	algorithm := checksum["algorithm"].(string)
+	if _, err := newChecksumHash(algorithm); err != nil {
+		return "", err
+	}
+
+	value := checksum["value"].(string)
+	checksumURL := checksum["url"].(string)
+	switch {
+	case value != "" && checksumURL != "":
+		return "", fmt.Errorf("source_checksum can't have both 'value' and 'url' set")
+	case value == "" && checksumURL == "":
+		return "", fmt.Errorf("source_checksum must have either 'value' or 'url' set")
+	case checksumURL != "":
+		entry := checksum["entry"].(string)
+		if entry == "" {
+			sourceURL, err := url.Parse(source)
+			if err != nil {
+				return "", fmt.Errorf("can't parse source '%s' as url: %w", source, err)
+			}
+			entry = path.Base(sourceURL.Path)
+		}
+
+		var err error
+		if value, err = fetchChecksum(checksumURL, entry, options); err != nil {
+			return "", err
+		}
+	}
+
+	return algorithm + ":" + strings.ToLower(value), nil
+}
+
+// checksumVerifier hashes what is read through it, to compare it with the
+// expected digest once the reader is consumed.
+type checksumVerifier struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+func newChecksumVerifier(digest string) (*checksumVerifier, error) {
+	algorithm, expected, found := strings.Cut(digest, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid digest '%s'", digest)
+	}
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumVerifier{algorithm: algorithm, expected: expected, hash: h}, nil
+}
+
+func (v *checksumVerifier) Reader(r io.Reader) io.Reader {
+	v.hash.Reset()
+	return io.TeeReader(r, v.hash)
+}
+
+// Digest returns the digest of what was read, as "<algorithm>:<checksum>".
+func (v *checksumVerifier) Digest() string {
+	return v.algorithm + ":" + hex.EncodeToString(v.hash.Sum(nil))
+}
+
+func (v *checksumVerifier) Verify() error {
+	if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+		return fmt.Errorf("checksum mismatch: expected %s %s, got %s", v.algorithm, v.expected, actual)
+	}
+	return nil
+}
+
+// verifiedImage is an image whose content is verified while it is imported.
+type verifiedImage struct {
+	image
+	verifier *checksumVerifier
+}
+
+func (i *verifiedImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	return i.image.Import(func(src io.Reader) error {
+		reader := i.verifier.Reader(src)
+		if err := uploader(reader); err != nil {
+			return err
+		}
+		// decompressors may stop before the end of the file, hash what is left
+		_, err := io.Copy(io.Discard, reader)
+		return err
+	}, vol)
+}
+
+// newVerifiedImage verifies the image with verifier when imported. Checksums are
+// published for the files as downloaded, so compressed images are verified
+// before being decompressed.
+func newVerifiedImage(img image, verifier *checksumVerifier) image {
+	if compressed, ok := img.(*compressedImage); ok {
+		return &compressedImage{
+			image:       &verifiedImage{image: compressed.image, verifier: verifier},
+			compression: compressed.compression,
+		}
+	}
+	return &verifiedImage{image: img, verifier: verifier}
+}
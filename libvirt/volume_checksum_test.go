@@ -0,0 +1,139 @@
+package libvirt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testChecksumContent = "this is a qcow image... well, it is not"
+
+// sha256 of testChecksumContent.
+const testChecksumSHA256 = "53ecdde86e721cafd376914eeddaba2535e08926c39b0028f36c7a0566cfac68"
+
+func TestParseChecksumFile(t *testing.T) {
+	sums := `# generated by sha256sum
+0123  openSUSE-Leap.iso
+4567 *openSUSE-Leap.qcow2
+SHA256 (Fedora-Cloud.qcow2) = 89ab
+`
+	for entry, expected := range map[string]string{
+		"openSUSE-Leap.iso":   "0123",
+		"openSUSE-Leap.qcow2": "4567",
+		"Fedora-Cloud.qcow2":  "89ab",
+	} {
+		checksum, err := parseChecksumFile(strings.NewReader(sums), entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != expected {
+			t.Errorf("expected checksum %s for %s, got %s", expected, entry, checksum)
+		}
+	}
+
+	if _, err := parseChecksumFile(strings.NewReader(sums), "missing.qcow2"); err == nil {
+		t.Error("expected an error for a missing entry")
+	}
+}
+
+func TestExpectedSourceDigest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ABCD  image.qcow2\n")
+	}))
+	defer server.Close()
+
+	checksum := map[string]interface{}{"algorithm": "sha512", "value": "", "url": server.URL + "/SHA512SUMS", "entry": ""}
+	digest, err := expectedSourceDigest(checksum, "http://example.com/images/image.qcow2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha512:abcd" {
+		t.Errorf("unexpected digest %s", digest)
+	}
+
+	checksum = map[string]interface{}{"algorithm": "md5", "value": "abcd", "url": "", "entry": ""}
+	if _, err := expectedSourceDigest(checksum, "image.qcow2", nil); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+
+	checksum = map[string]interface{}{"algorithm": "sha256", "value": "", "url": "", "entry": ""}
+	if _, err := expectedSourceDigest(checksum, "image.qcow2", nil); err == nil {
+		t.Error("expected an error without value nor url")
+	}
+}
+
+func TestChecksumVerifier(t *testing.T) {
+	verifier, err := newChecksumVerifier("sha256:" + testChecksumSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, verifier.Reader(strings.NewReader(testChecksumContent))); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(); err != nil {
+		t.Error(err)
+	}
+	if verifier.Digest() != "sha256:"+testChecksumSHA256 {
+		t.Errorf("unexpected digest %s", verifier.Digest())
+	}
+
+	verifier, err = newChecksumVerifier("sha256:" + testChecksumSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, verifier.Reader(strings.NewReader("tampered"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+}
+
+func TestVerifiedCompressedImage(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write([]byte(testChecksumContent)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(t.TempDir(), "image.raw.gz")
+	if err := os.WriteFile(localPath, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(compressed.Bytes())
+
+	img, err := newImage(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := newChecksumVerifier("sha256:" + hex.EncodeToString(digest[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the checksum is the one of the compressed file, the upload gets it decompressed
+	var uploaded bytes.Buffer
+	if err := newVerifiedImage(img, verifier).Import(func(r io.Reader) error {
+		_, err := io.Copy(&uploaded, r)
+		return err
+	}, newDefVolume()); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.String() != testChecksumContent {
+		t.Errorf("unexpected upload %q", uploaded.String())
+	}
+	if err := verifier.Verify(); err != nil {
+		t.Error(err)
+	}
+}
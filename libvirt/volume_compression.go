@@ -0,0 +1,406 @@
+package libvirt
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"libvirt.org/go/libvirtxml"
+)
+
+type compression string
+
+const (
+	compressionNone  compression = ""
+	compressionGzip  compression = "gzip"
+	compressionXZ    compression = "xz"
+	compressionZstd  compression = "zstd"
+	compressionBzip2 compression = "bzip2"
+)
+
+var compressionSuffixes = map[string]compression{
+	".gz":  compressionGzip,
+	".xz":  compressionXZ,
+	".zst": compressionZstd,
+	".bz2": compressionBzip2,
+}
+
+var compressionMagics = map[compression][]byte{
+	compressionGzip:  {0x1f, 0x8b},
+	compressionXZ:    {0xfd, '7', 'z', 'X', 'Z', 0x00},
+	compressionZstd:  {0x28, 0xb5, 0x2f, 0xfd},
+	compressionBzip2: {'B', 'Z', 'h'},
+}
+
+// the longest of the magic numbers.
+const compressionMagicSize = 6
+
+// errStopImport stops an import once the reader got what it needed.
+var errStopImport = errors.New("import stopped")
+
+// compressionFromName returns the compression of a file by its suffix, like
+// image.qcow2.xz.
+func compressionFromName(name string) compression {
+	for suffix, c := range compressionSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return c
+		}
+	}
+	return compressionNone
+}
+
+// compressionFromHeader returns the compression of a file by its magic number.
+func compressionFromHeader(header []byte) compression {
+	for c, magic := range compressionMagics {
+		if bytes.HasPrefix(header, magic) {
+			return c
+		}
+	}
+	return compressionNone
+}
+
+// compressionFromFile returns the compression of a local file, by its suffix or by
+// its magic number.
+func compressionFromFile(path string) (compression, error) {
+	if c := compressionFromName(path); c != compressionNone {
+		return c, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return compressionNone, fmt.Errorf("error while opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	header := make([]byte, compressionMagicSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return compressionNone, err
+	}
+	return compressionFromHeader(header[:n]), nil
+}
+
+func newDecompressingReader(c compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionXZ:
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	case compressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case compressionNone:
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newCompressingWriter compresses what is written to w, until closed. bzip2 is
+// only supported for reading.
+func newCompressingWriter(c compression, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	case compressionXZ:
+		return xz.NewWriter(w)
+	case compressionZstd:
+		return zstd.NewWriter(w)
+	case compressionBzip2:
+		return nil, fmt.Errorf("compressing with %s is not supported", c)
+	case compressionNone:
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// compressedImage is an image whose content is decompressed while it is
+// imported, so that the volume gets the uncompressed image.
+type compressedImage struct {
+	image       image
+	compression compression
+	// the start of the decompressed image, once read
+	header []byte
+	// the decompressed image, when its size can only be known by decompressing it,
+	// and how to remove it
+	spooled       *localImage
+	removeSpooled func()
+}
+
+func (i *compressedImage) String() string {
+	return i.image.String()
+}
+
+// decompressed imports the image, giving the decompressed content to importer.
+func (i *compressedImage) decompressed(importer func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	return i.image.Import(func(src io.Reader) error {
+		reader, err := newDecompressingReader(i.compression, src)
+		if err != nil {
+			return fmt.Errorf("error decompressing %s as %s: %w", i.image.String(), i.compression, err)
+		}
+		defer reader.Close()
+		return importer(reader)
+	}, vol)
+}
+
+// readHeader decompresses the start of the image once, stopping the import as soon
+// as it is read.
+func (i *compressedImage) readHeader() ([]byte, error) {
+	if i.header != nil {
+		return i.header, nil
+	}
+
+	err := i.decompressed(func(r io.Reader) error {
+		var err error
+		if i.header, err = readImageHeader(r); err != nil {
+			return err
+		}
+		return errStopImport
+	}, newDefVolume())
+	if err != nil && !errors.Is(err, errStopImport) {
+		return nil, err
+	}
+	return i.header, nil
+}
+
+// decompress decompresses the image once to a temporary file, for the images whose
+// size is not recorded in their metadata, and the ones converted by qemu-img.
+func (i *compressedImage) decompress() (*localImage, error) {
+	if i.spooled != nil {
+		return i.spooled, nil
+	}
+
+	spooled, cleanup, err := spoolImage(i.String(), func(w io.Writer) error {
+		return i.decompressed(func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		}, newDefVolume())
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.spooled, i.removeSpooled = spooled, cleanup
+	return spooled, nil
+}
+
+// cleanup removes the decompressed image.
+func (i *compressedImage) cleanup() {
+	if i.removeSpooled != nil {
+		i.removeSpooled()
+	}
+	i.spooled, i.removeSpooled = nil, nil
+}
+
+// Size returns the uncompressed size of the image, from the index of xz files, or
+// the virtual size of qcow2 images. Other compressed formats don't reliably record
+// the size of their content, so the image is decompressed to a temporary file.
+func (i *compressedImage) Size() (uint64, error) {
+	if i.spooled != nil {
+		return i.spooled.Size()
+	}
+
+	if i.compression == compressionXZ {
+		size, ok, err := i.xzSize()
+		if err != nil {
+			log.Printf("[DEBUG] Could not read the index of %s: %s", i.String(), err)
+		} else if ok {
+			return size, nil
+		}
+	}
+
+	header, err := i.readHeader()
+	if err != nil {
+		return 0, err
+	}
+	if detectImageFormat(header, nil) == imageFormatQCOW2 && len(header) >= qcow2SizeOffset+8 {
+		return binary.BigEndian.Uint64(header[qcow2SizeOffset:]), nil
+	}
+
+	log.Printf("[DEBUG] The size of %s is unknown, decompressing it", i.String())
+	spooled, err := i.decompress()
+	if err != nil {
+		return 0, err
+	}
+	return spooled.Size()
+}
+
+// xzSize returns the uncompressed size recorded in the index at the end of an xz
+// file, when its end can be read on its own.
+func (i *compressedImage) xzSize() (uint64, bool, error) {
+	tail, total, err := readImageTail(i.image, xzTailReadSize)
+	if err != nil || tail == nil {
+		return 0, false, err
+	}
+
+	indexSize, ok := xzIndexSize(tail)
+	if !ok {
+		return 0, false, nil
+	}
+	if len(tail) < indexSize+xzStreamFooterSize {
+		if tail, total, err = readImageTail(i.image, indexSize+xzStreamFooterSize); err != nil || tail == nil {
+			return 0, false, err
+		}
+	}
+
+	size, ok := xzUncompressedSize(tail, total)
+	return size, ok, nil
+}
+
+// Format returns the format of the decompressed image.
+func (i *compressedImage) Format() (string, error) {
+	if i.spooled != nil {
+		return i.spooled.Format()
+	}
+
+	header, err := i.readHeader()
+	if err != nil {
+		return "", err
+	}
+	return detectImageFormat(header, nil), nil
+}
+
+// Import decompresses the image while it is read by the uploader, unless it was
+// decompressed to a temporary file already.
+func (i *compressedImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	if i.spooled != nil {
+		return i.spooled.Import(uploader, newDefVolume())
+	}
+	return i.decompressed(uploader, newDefVolume())
+}
+
+const (
+	// where qcow2 images record their virtual size
+	qcow2SizeOffset = 24
+
+	xzStreamHeaderSize = 12
+	xzStreamFooterSize = 12
+	// enough for the index of most xz files, read with their footer
+	xzTailReadSize = 64 * 1024
+)
+
+// readImageTail reads the last size bytes of img, or all of it when smaller, and
+// returns its whole size. The tail is nil when it can't be read on its own.
+func readImageTail(img image, size int) ([]byte, uint64, error) {
+	switch i := img.(type) {
+	case *verifiedImage:
+		return readImageTail(i.image, size)
+	case *cachedImage:
+		local, err := i.fetch()
+		if err != nil {
+			return nil, 0, err
+		}
+		return readImageTail(local, size)
+	case *localImage:
+		file, err := os.Open(i.path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error while opening %s: %w", i.path, err)
+		}
+		defer file.Close()
+
+		fi, err := file.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		if fi.Size() < int64(size) {
+			size = int(fi.Size())
+		}
+		tail := make([]byte, size)
+		if _, err := file.ReadAt(tail, fi.Size()-int64(size)); err != nil {
+			return nil, 0, err
+		}
+		return tail, uint64(fi.Size()), nil
+	case *httpImage:
+		total, err := i.Size()
+		if err != nil {
+			// without Content-Length, the end can't be found
+			return nil, 0, nil
+		}
+		tail, err := i.readRange("-"+strconv.Itoa(size), size)
+		return tail, total, err
+	}
+	return nil, 0, nil
+}
+
+// xzIndexSize returns the size of the index before the stream footer ending tail.
+func xzIndexSize(tail []byte) (int, bool) {
+	if len(tail) < xzStreamFooterSize {
+		return 0, false
+	}
+	footer := tail[len(tail)-xzStreamFooterSize:]
+	if footer[10] != 'Y' || footer[11] != 'Z' ||
+		crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[0:4]) {
+		return 0, false
+	}
+	return (int(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4, true
+}
+
+// xzUncompressedSize adds the uncompressed sizes of the blocks in the index of the xz
+// file ending with tail. Only the index of the last stream is at the end of a file,
+// so the size is only known when the stream is the whole file.
+func xzUncompressedSize(tail []byte, total uint64) (uint64, bool) {
+	indexSize, ok := xzIndexSize(tail)
+	if !ok || len(tail) < indexSize+xzStreamFooterSize || indexSize < 8 {
+		return 0, false
+	}
+	index := tail[len(tail)-xzStreamFooterSize-indexSize : len(tail)-xzStreamFooterSize]
+	if index[0] != 0 ||
+		crc32.ChecksumIEEE(index[:indexSize-4]) != binary.LittleEndian.Uint32(index[indexSize-4:]) {
+		return 0, false
+	}
+
+	records := index[1 : indexSize-4]
+	count, n := binary.Uvarint(records)
+	if n <= 0 || count > uint64(len(records)) {
+		return 0, false
+	}
+	records = records[n:]
+
+	streamSize := uint64(xzStreamHeaderSize + indexSize + xzStreamFooterSize)
+	var size uint64
+	for ; count > 0; count-- {
+		unpadded, n := binary.Uvarint(records)
+		if n <= 0 {
+			return 0, false
+		}
+		records = records[n:]
+		uncompressed, n := binary.Uvarint(records)
+		if n <= 0 {
+			return 0, false
+		}
+		records = records[n:]
+
+		// blocks are padded to four bytes
+		streamSize += (unpadded + 3) &^ 3
+		size += uncompressed
+	}
+
+	if streamSize != total {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,199 @@
+package libvirt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func TestCompressionDetection(t *testing.T) {
+	assert.Equal(t, compressionXZ, compressionFromName("/images/leap.qcow2.xz"))
+	assert.Equal(t, compressionGzip, compressionFromName("leap.raw.GZ"))
+	assert.Equal(t, compressionZstd, compressionFromName("leap.img.zst"))
+	assert.Equal(t, compressionNone, compressionFromName("leap.qcow2"))
+
+	assert.Equal(t, compressionGzip, compressionFromHeader([]byte{0x1f, 0x8b, 0x08, 0x00}))
+	assert.Equal(t, compressionXZ, compressionFromHeader([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}))
+	assert.Equal(t, compressionZstd, compressionFromHeader([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}))
+	assert.Equal(t, compressionNone, compressionFromHeader([]byte("QFI\xfb")))
+	assert.Equal(t, compressionNone, compressionFromHeader(nil))
+}
+
+func TestCompressedImage(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test.qcow2"))
+	require.NoError(t, err)
+
+	compressors := map[string]func(io.Writer) (io.WriteCloser, error){
+		"gzip": func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"xz":   func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+		"zstd": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	}
+
+	suffixes := map[string]string{"gzip": "gz", "xz": "xz", "zstd": "zst"}
+
+	fws := newFileWebServer(t)
+	fws.Start()
+	defer fws.Close()
+
+	for name, compressor := range compressors {
+		var compressed bytes.Buffer
+		w, err := compressor(&compressed)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// without suffix, found by its magic number
+		localPath := filepath.Join(t.TempDir(), "image")
+		require.NoError(t, os.WriteFile(localPath, compressed.Bytes(), 0o644))
+
+		// with suffix, as magic numbers are not checked for remote images
+		suffixed := filepath.Join(t.TempDir(), "image.qcow2."+suffixes[name])
+		require.NoError(t, os.WriteFile(suffixed, compressed.Bytes(), 0o644))
+		httpURL, err := fws.AddFile(suffixed)
+		require.NoError(t, err)
+
+		for _, source := range []string{localPath, httpURL} {
+			img, err := newImage(source)
+			require.NoError(t, err, name)
+			require.IsType(t, &compressedImage{}, img, "%s: %s", name, source)
+
+			// the size of xz images is in their index, the one of qcow2 images in
+			// their header
+			expectedSize := uint64(512)
+			if name == "xz" {
+				expectedSize = uint64(len(content))
+			}
+			size, err := img.Size()
+			require.NoError(t, err, name)
+			assert.Equal(t, expectedSize, size, "%s: %s", name, source)
+
+			format, err := img.Format()
+			require.NoError(t, err, name)
+			assert.Equal(t, "qcow2", format, "%s: %s", name, source)
+
+			var imported bytes.Buffer
+			require.NoError(t, img.Import(func(r io.Reader) error {
+				_, err := io.Copy(&imported, r)
+				return err
+			}, newDefVolume()))
+			assert.Equal(t, content, imported.Bytes(), "%s: %s", name, source)
+
+			// streamed, without decompressing it to a temporary file
+			assert.Nil(t, img.(*compressedImage).spooled, "%s: %s", name, source)
+		}
+	}
+}
+
+func TestCompressedImageSpooledWithoutSize(t *testing.T) {
+	content := bytes.Repeat([]byte("raw image"), 1024)
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	localPath := filepath.Join(t.TempDir(), "image.raw.gz")
+	require.NoError(t, os.WriteFile(localPath, compressed.Bytes(), 0o644))
+
+	img, err := newImage(localPath)
+	require.NoError(t, err)
+
+	// gzip does not record the size of raw images, so they are decompressed first
+	size, err := img.Size()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(content)), size)
+	spooled := img.(*compressedImage).spooled
+	require.NotNil(t, spooled)
+
+	var imported bytes.Buffer
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume()))
+	assert.Equal(t, content, imported.Bytes())
+
+	cleanupImage(img)
+	_, err = os.Stat(spooled.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestXZUncompressedSize(t *testing.T) {
+	compress := func(content []byte) []byte {
+		var compressed bytes.Buffer
+		w, err := xz.NewWriter(&compressed)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return compressed.Bytes()
+	}
+
+	first := compress(bytes.Repeat([]byte("first"), 100000))
+	size, ok := xzUncompressedSize(first, uint64(len(first)))
+	require.True(t, ok)
+	assert.Equal(t, uint64(500000), size)
+
+	// the index at the end only describes the last of several streams
+	second := compress([]byte("second"))
+	both := append(append([]byte{}, first...), second...)
+	_, ok = xzUncompressedSize(both, uint64(len(both)))
+	assert.False(t, ok)
+
+	_, ok = xzUncompressedSize([]byte("not an xz file"), 14)
+	assert.False(t, ok)
+}
+
+func TestCompressedImageDownloadedOnce(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "test.qcow2"))
+	require.NoError(t, err)
+
+	var compressed bytes.Buffer
+	w, err := xz.NewWriter(&compressed)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	// the start of the image is read for its format and its index for its size, the
+	// whole image only once while imported
+	var downloads int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+			atomic.AddInt64(&downloads, 1)
+		}
+		http.ServeContent(w, r, "image.qcow2.xz", time.Time{}, bytes.NewReader(compressed.Bytes()))
+	}))
+	defer server.Close()
+
+	img, err := newImage(server.URL + "/image.qcow2.xz")
+	require.NoError(t, err)
+	defer cleanupImage(img)
+
+	size, err := img.Size()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(content)), size)
+	format, err := img.Format()
+	require.NoError(t, err)
+	assert.Equal(t, imageFormatQCOW2, format)
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}, newDefVolume()))
+
+	assert.Equal(t, int64(2), atomic.LoadInt64(&downloads))
+	assert.Nil(t, img.(*compressedImage).spooled)
+}
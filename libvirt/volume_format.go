@@ -0,0 +1,182 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// formats of the disk images, as named by qemu-img.
+const (
+	imageFormatRaw   = "raw"
+	imageFormatQCOW2 = "qcow2"
+	imageFormatVMDK  = "vmdk"
+	imageFormatVHD   = "vpc"
+	imageFormatVHDX  = "vhdx"
+	imageFormatVDI   = "vdi"
+	imageFormatISO   = "iso"
+)
+
+//nolint:mnd
+const (
+	// enough of the image to find the ISO 9660 volume descriptor, at 0x8001
+	imageFormatHeaderSize = 0x8006
+	// VHD images have a footer, and only the dynamic ones a copy at the start
+	vhdFooterSize  = 512
+	vdiMagicOffset = 0x40
+	isoMagicOffset = 0x8001
+)
+
+var (
+	qcowMagic = []byte{'Q', 'F', 'I', 0xfb}
+	vmdkMagic = []byte("KDMV")
+	vhdMagic  = []byte("conectix")
+	vhdxMagic = []byte("vhdxfile")
+	vdiMagic  = []byte{0x7f, 0x10, 0xda, 0xbe}
+	isoMagic  = []byte("CD001")
+)
+
+// detectImageFormat returns the format of an image from its first bytes and, when
+// known, its last ones, defaulting to raw.
+//
+//nolint:mnd
+func detectImageFormat(header []byte, footer []byte) string {
+	hasMagic := func(buf []byte, offset int, magic []byte) bool {
+		return len(buf) >= offset+len(magic) && bytes.Equal(buf[offset:offset+len(magic)], magic)
+	}
+
+	switch {
+	case hasMagic(header, 0, qcowMagic) && len(header) >= 8:
+		if version := binary.BigEndian.Uint32(header[4:8]); version == 2 || version == 3 {
+			return imageFormatQCOW2
+		}
+	case hasMagic(header, 0, vmdkMagic):
+		return imageFormatVMDK
+	case hasMagic(header, 0, vhdxMagic):
+		return imageFormatVHDX
+	case hasMagic(header, 0, vhdMagic), hasMagic(footer, 0, vhdMagic):
+		return imageFormatVHD
+	case hasMagic(header, vdiMagicOffset, vdiMagic):
+		return imageFormatVDI
+	case hasMagic(header, isoMagicOffset, isoMagic):
+		return imageFormatISO
+	}
+	return imageFormatRaw
+}
+
+// readImageHeader reads the first bytes of an image needed to detect its format, or
+// less if the image is smaller.
+func readImageHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, imageFormatHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// detectStreamFormat returns the format of the image read through an import, which
+// is stopped once the header is read. The footer of VHD images is not looked at.
+func detectStreamFormat(importer func(func(io.Reader) error) error) (string, error) {
+	var header []byte
+	err := importer(func(r io.Reader) error {
+		var err error
+		if header, err = readImageHeader(r); err != nil {
+			return err
+		}
+		return errStopImport
+	})
+	if err != nil && !errors.Is(err, errStopImport) {
+		return "", err
+	}
+	return detectImageFormat(header, nil), nil
+}
+
+// volumeFormat returns the format of the volume for an image in format. ISO images
+// are just raw data for libvirt.
+func volumeFormat(format string) string {
+	if format == imageFormatISO {
+		return imageFormatRaw
+	}
+	return format
+}
+
+// isNativeFormat returns whether volumes in format don't need to be converted to
+// be used by qemu with the best performance.
+func isNativeFormat(format string) bool {
+	return format == imageFormatQCOW2 || format == imageFormatRaw
+}
+
+// isLibvirtVolumeFormat returns whether libvirt knows the format, so that images
+// in it can be uploaded without conversion.
+func isLibvirtVolumeFormat(format string) bool {
+	switch format {
+	case imageFormatRaw, imageFormatQCOW2, imageFormatVMDK, imageFormatVHD, imageFormatVDI:
+		return true
+	}
+	return false
+}
+
+// convertImage converts img, in sourceFormat, to targetFormat with qemu-img, in a
+// temporary directory removed by the returned cleanup function.
+func convertImage(img image, sourceFormat string, targetFormat string) (*localImage, func(), error) {
+	qemuImg, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("qemu-img is needed to convert images: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "terraform-provider-libvirt-convert")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Printf("[WARN] Could not remove %s: %s", tmpDir, err)
+		}
+	}
+
+	// qemu-img needs to seek in the images, so remote ones are copied first
+	sourcePath := filepath.Join(tmpDir, "source")
+	if local, ok := img.(*localImage); ok {
+		sourcePath = local.path
+	} else if compressed, ok := img.(*compressedImage); ok {
+		decompressed, err := compressed.decompress()
+		if err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+		sourcePath = decompressed.path
+	} else {
+		err := writeFileAtomic(sourcePath, func(w io.Writer) error {
+			return img.Import(func(r io.Reader) error {
+				_, err := io.Copy(w, r)
+				return err
+			}, newDefVolume())
+		})
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("error while reading %s: %w", img.String(), err)
+		}
+	}
+
+	targetPath := filepath.Join(tmpDir, "target")
+	log.Printf("[INFO] Converting %s from %s to %s", img.String(), sourceFormat, targetFormat)
+	//nolint:gosec // the formats are the ones known by detectImageFormat or the volume format
+	cmd := exec.Command(qemuImg, "convert",
+		"-f", volumeFormat(sourceFormat),
+		"-O", targetFormat,
+		sourcePath,
+		targetPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("error converting %s to %s: %w: %s", img.String(), targetFormat, err, bytes.TrimSpace(output))
+	}
+
+	return &localImage{path: targetPath}, cleanup, nil
+}
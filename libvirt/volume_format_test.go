@@ -0,0 +1,115 @@
+package libvirt
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newImageWithMagic returns an image of size bytes, with magic at offset.
+func newImageWithMagic(size int, offset int, magic []byte) []byte {
+	content := make([]byte, size)
+	copy(content[offset:], magic)
+	return content
+}
+
+func TestDetectImageFormat(t *testing.T) {
+	for expected, header := range map[string][]byte{
+		imageFormatQCOW2: []byte("QFI\xfb\x00\x00\x00\x02"),
+		imageFormatVMDK:  newImageWithMagic(512, 0, []byte("KDMV")),
+		imageFormatVHDX:  newImageWithMagic(512, 0, []byte("vhdxfile")),
+		imageFormatVHD:   newImageWithMagic(512, 0, []byte("conectix")),
+		imageFormatVDI:   newImageWithMagic(512, vdiMagicOffset, []byte{0x7f, 0x10, 0xda, 0xbe}),
+		imageFormatISO:   newImageWithMagic(imageFormatHeaderSize, isoMagicOffset, []byte("CD001")),
+		imageFormatRaw:   newImageWithMagic(512, 0, []byte("QFI\xfb\x00\x00\x00\x09")),
+	} {
+		assert.Equal(t, expected, detectImageFormat(header, nil))
+	}
+
+	// fixed VHD images only have a footer
+	assert.Equal(t, imageFormatVHD, detectImageFormat(make([]byte, 512), []byte("conectix")))
+	assert.Equal(t, imageFormatRaw, detectImageFormat(nil, nil))
+
+	assert.Equal(t, imageFormatRaw, volumeFormat(imageFormatISO))
+	assert.True(t, isLibvirtVolumeFormat(imageFormatVHD))
+	assert.False(t, isLibvirtVolumeFormat(imageFormatVHDX))
+}
+
+func TestImageFormat(t *testing.T) {
+	// a fixed VHD image: raw data and a footer
+	vhd := make([]byte, 2*imageFormatHeaderSize)
+	copy(vhd[len(vhd)-vhdFooterSize:], "conectix")
+	vhdPath := filepath.Join(t.TempDir(), "appliance.vhd")
+	require.NoError(t, os.WriteFile(vhdPath, vhd, 0o644))
+
+	format, err := (&localImage{path: vhdPath}).Format()
+	require.NoError(t, err)
+	assert.Equal(t, imageFormatVHD, format)
+
+	var served int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/noranges" {
+			w.Write(vhd)
+			return
+		}
+		counter := &countingResponseWriter{ResponseWriter: w, count: &served}
+		http.ServeContent(counter, r, "appliance.vhd", time.Time{}, bytes.NewReader(vhd))
+	}))
+	defer server.Close()
+
+	img, err := newImage(server.URL + "/appliance")
+	require.NoError(t, err)
+	format, err = img.Format()
+	require.NoError(t, err)
+	assert.Equal(t, imageFormatVHD, format)
+	// only the header and the footer were downloaded
+	assert.Equal(t, int64(imageFormatHeaderSize+vhdFooterSize), atomic.LoadInt64(&served))
+
+	// without range support, only the header is read
+	img, err = newImage(server.URL + "/noranges")
+	require.NoError(t, err)
+	format, err = img.Format()
+	require.NoError(t, err)
+	assert.Equal(t, imageFormatRaw, format)
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	count *int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	atomic.AddInt64(w.count, int64(n))
+	return n, err
+}
+
+func TestConvertImage(t *testing.T) {
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+
+	img, err := newImage(filepath.Join("testdata", "test.qcow2"))
+	require.NoError(t, err)
+
+	converted, cleanup, err := convertImage(img, imageFormatQCOW2, imageFormatVMDK)
+	require.NoError(t, err)
+	defer cleanup()
+
+	format, err := converted.Format()
+	require.NoError(t, err)
+	assert.Equal(t, imageFormatVMDK, format)
+
+	cleanup()
+	_, err = os.Stat(converted.path)
+	assert.True(t, os.IsNotExist(err))
+}
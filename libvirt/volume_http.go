@@ -0,0 +1,396 @@
+package libvirt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// number of download retries on network and server errors (eg. 5xx)
+	defaultHTTPRetries = 3
+	// wait time before the first retry, doubled on each of the next ones
+	defaultHTTPRetryWait = 2 * time.Second
+	// longest wait time between retries
+	defaultHTTPRetryMaxWait = time.Minute
+)
+
+// the settings used to download http sources, both in the provider, as defaults, and
+// in the volumes.
+func sourceHTTPSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"retries": {
+					Type:     schema.TypeInt,
+					Optional: true,
+				},
+				"retry_wait": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"retry_max_wait": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"bearer_token": {
+					Type:      schema.TypeString,
+					Optional:  true,
+					Sensitive: true,
+				},
+				"username": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"password": {
+					Type:      schema.TypeString,
+					Optional:  true,
+					Sensitive: true,
+				},
+				"headers": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+				},
+				"ca_file": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"insecure": {
+					Type:     schema.TypeBool,
+					Optional: true,
+				},
+				"proxy": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+			},
+		},
+	}
+}
+
+// resourceConfig is a schema.ResourceData or a schema.ResourceDiff.
+type resourceConfig interface {
+	Get(key string) interface{}
+	GetOkExists(key string) (interface{}, bool)
+}
+
+// sourceHTTPSettings returns the settings of the source_http block key of d, nil when
+// not set. Only the settings present in the configuration are returned, so that false
+// or 0 can still override the ones of the provider.
+func sourceHTTPSettings(d resourceConfig, key string) map[string]interface{} {
+	block := d.Get(key).([]interface{})
+	if len(block) == 0 || block[0] == nil {
+		return nil
+	}
+
+	settings := make(map[string]interface{})
+	for name := range block[0].(map[string]interface{}) {
+		// like for dhcp.0.enabled of networks, the deprecated GetOkExists is the way
+		// to know about settings explicitly set to their zero value
+		if value, ok := d.GetOkExists(key + ".0." + name); ok {
+			settings[name] = value
+		}
+	}
+	return settings
+}
+
+// mergeSourceHTTPSettings returns the settings of a source_http block, taking the
+// ones not set from defaults, the block of the provider.
+func mergeSourceHTTPSettings(defaults map[string]interface{}, settings map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+
+	for key, value := range settings {
+		v, ok := value.(map[string]interface{})
+		if !ok {
+			merged[key] = value
+			continue
+		}
+
+		headers := make(map[string]interface{})
+		if defaultHeaders, ok := merged[key].(map[string]interface{}); ok {
+			for name, value := range defaultHeaders {
+				headers[name] = value
+			}
+		}
+		for name, value := range v {
+			headers[name] = value
+		}
+		merged[key] = headers
+	}
+	return merged
+}
+
+// httpOptions are the settings used to download an http image.
+type httpOptions struct {
+	retries      int
+	retryWait    time.Duration
+	retryMaxWait time.Duration
+	header       http.Header
+	client       *http.Client
+}
+
+func defaultHTTPOptions() *httpOptions {
+	return &httpOptions{
+		retries:      defaultHTTPRetries,
+		retryWait:    defaultHTTPRetryWait,
+		retryMaxWait: defaultHTTPRetryMaxWait,
+		header:       http.Header{},
+		client:       &http.Client{},
+	}
+}
+
+func parseDurationSetting(settings map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	value, _ := settings[key].(string)
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %w", key, value, err)
+	}
+	return duration, nil
+}
+
+// newHTTPOptions returns the options for the settings of a source_http block.
+func newHTTPOptions(settings map[string]interface{}) (*httpOptions, error) {
+	options := defaultHTTPOptions()
+	if len(settings) == 0 {
+		return options, nil
+	}
+
+	var err error
+	if retries, ok := settings["retries"].(int); ok && retries >= 0 {
+		options.retries = retries
+	}
+	if options.retryWait, err = parseDurationSetting(settings, "retry_wait", defaultHTTPRetryWait); err != nil {
+		return nil, err
+	}
+	if options.retryMaxWait, err = parseDurationSetting(settings, "retry_max_wait", defaultHTTPRetryMaxWait); err != nil {
+		return nil, err
+	}
+
+	if headers, ok := settings["headers"].(map[string]interface{}); ok {
+		for name, value := range headers {
+			options.header.Set(name, value.(string))
+		}
+	}
+
+	token, _ := settings["bearer_token"].(string)
+	username, _ := settings["username"].(string)
+	password, _ := settings["password"].(string)
+	switch {
+	case token != "" && username != "":
+		return nil, fmt.Errorf("source_http can't have both 'bearer_token' and 'username' set")
+	case token != "":
+		options.header.Set("Authorization", "Bearer "+token)
+	case username != "":
+		request := http.Request{Header: http.Header{}}
+		request.SetBasicAuth(username, password)
+		options.header.Set("Authorization", request.Header.Get("Authorization"))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	insecure, _ := settings["insecure"].(bool)
+	caFile, _ := settings["ca_file"].(string)
+	if insecure || caFile != "" {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: insecure,
+			MinVersion:         tls.VersionTLS12,
+		}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if proxy, _ := settings["proxy"].(string); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy '%s': %w", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	options.client = &http.Client{Transport: transport}
+	return options, nil
+}
+
+// wait returns the time to wait before the given retry, starting from 0.
+func (o *httpOptions) wait(retry int) time.Duration {
+	wait := o.retryWait
+	for i := 0; i < retry && wait < o.retryMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > o.retryMaxWait {
+		return o.retryMaxWait
+	}
+	return wait
+}
+
+// newRequest creates a request with the headers of the options and the given ones.
+func (o *httpOptions) newRequest(method string, u *url.URL, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range o.header {
+		req.Header[name] = values
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return req, nil
+}
+
+// resumingReader reads the body of a download, resuming it with a range request
+// when the connection fails.
+type resumingReader struct {
+	image   *httpImage
+	options *httpOptions
+	body    io.ReadCloser
+	// bytes read so far
+	offset int64
+	// the ETag or last modification time, to make sure the resumed content is the same
+	validator string
+	retries   int
+}
+
+func newResumingReader(img *httpImage, options *httpOptions, response *http.Response) *resumingReader {
+	validator := response.Header.Get("ETag")
+	if validator == "" {
+		validator = response.Header.Get("Last-Modified")
+	}
+	return &resumingReader{image: img, options: options, body: response.Body, validator: validator}
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if n > 0 {
+			// give what was read before, the error comes again on next read
+			return n, nil
+		}
+
+		if r.validator == "" {
+			return 0, err
+		}
+		if resumeErr := r.resumeWithRetries(err); resumeErr != nil {
+			return 0, resumeErr
+		}
+	}
+}
+
+// resumeWithRetries resumes the download after it failed with err, trying again
+// within the retries left when resuming fails on network or server errors.
+func (r *resumingReader) resumeWithRetries(err error) error {
+	var resumeErr error
+	for r.retries < r.options.retries {
+		log.Printf("[WARN] Download of %s failed after %d bytes, resuming: %s", r.image, r.offset, err)
+		time.Sleep(r.options.wait(r.retries))
+		r.retries++
+
+		var retry bool
+		if retry, resumeErr = r.resume(); resumeErr == nil {
+			return nil
+		} else if !retry {
+			break
+		}
+		log.Printf("[DEBUG] Could not resume download of %s (retry #%d): %s", r.image, r.retries, resumeErr)
+	}
+
+	if resumeErr != nil {
+		return fmt.Errorf("%w, and could not resume: %w", err, resumeErr)
+	}
+	return err
+}
+
+// resume requests the rest of the image, from the offset read so far. On failure, it
+// tells whether trying again may help.
+func (r *resumingReader) resume() (bool, error) {
+	header := http.Header{}
+	header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+	header.Set("If-Range", r.validator)
+
+	req, err := r.options.newRequest("GET", r.image.url, header)
+	if err != nil {
+		return false, err
+	}
+	response, err := r.options.client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		return !errors.As(err, &certErr), err
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		response.Body.Close()
+		return response.StatusCode >= http.StatusInternalServerError,
+			fmt.Errorf("server answered %s to the range request", response.Status)
+	}
+
+	// the content must continue where the download failed
+	if start, ok := contentRangeStart(response.Header.Get("Content-Range")); !ok || start != r.offset {
+		response.Body.Close()
+		return false, fmt.Errorf("server answered the range request from byte %d with range '%s'",
+			r.offset, response.Header.Get("Content-Range"))
+	}
+
+	r.body.Close()
+	r.body = response.Body
+	return false, nil
+}
+
+// contentRangeStart returns the first byte of a Content-Range header, like
+// "bytes 100-199/200".
+func contentRangeStart(contentRange string) (int64, bool) {
+	byteRange, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, false
+	}
+	start, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return offset, true
+}
+
+func (r *resumingReader) Close() error {
+	return r.body.Close()
+}
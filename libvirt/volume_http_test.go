@@ -0,0 +1,286 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeSourceHTTPSettings(t *testing.T) {
+	defaults := map[string]interface{}{
+		"retries":      5,
+		"bearer_token": "provider-token",
+		"headers":      map[string]interface{}{"X-Team": "infra", "X-Env": "prod"},
+		"insecure":     true,
+		"proxy":        "http://proxy:3128",
+	}
+	// retries and insecure are set to their zero values, proxy is not set
+	d := schema.TestResourceDataRaw(t, resourceLibvirtVolume().Schema, map[string]interface{}{
+		"name": "test",
+		"source_http": []interface{}{
+			map[string]interface{}{
+				"retries":      0,
+				"bearer_token": "volume-token",
+				"headers":      map[string]interface{}{"X-Env": "test"},
+				"insecure":     false,
+			},
+		},
+	})
+
+	merged := mergeSourceHTTPSettings(defaults, sourceHTTPSettings(d, "source_http"))
+	assert.Equal(t, 0, merged["retries"])
+	assert.Equal(t, false, merged["insecure"])
+	assert.Equal(t, "volume-token", merged["bearer_token"])
+	assert.Equal(t, "http://proxy:3128", merged["proxy"])
+	assert.Equal(t, map[string]interface{}{"X-Team": "infra", "X-Env": "test"}, merged["headers"])
+
+	options, err := newHTTPOptions(merged)
+	require.NoError(t, err)
+	assert.Equal(t, 0, options.retries)
+
+	d = schema.TestResourceDataRaw(t, resourceLibvirtVolume().Schema, map[string]interface{}{"name": "test"})
+	assert.Nil(t, sourceHTTPSettings(d, "source_http"))
+}
+
+func TestHTTPOptions(t *testing.T) {
+	options, err := newHTTPOptions(nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultHTTPRetries, options.retries)
+	assert.Equal(t, 2*time.Second, options.wait(0))
+	assert.Equal(t, 8*time.Second, options.wait(2))
+	assert.Equal(t, time.Minute, options.wait(10))
+
+	options, err = newHTTPOptions(map[string]interface{}{"username": "user", "password": "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", options.header.Get("Authorization"))
+
+	_, err = newHTTPOptions(map[string]interface{}{"username": "user", "bearer_token": "token"})
+	assert.Error(t, err)
+
+	_, err = newHTTPOptions(map[string]interface{}{"retry_wait": "soon"})
+	assert.Error(t, err)
+
+	_, err = newHTTPOptions(map[string]interface{}{"ca_file": filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestHTTPImageAuthAndCA(t *testing.T) {
+	content := []byte("private image")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Artifact-Repo") != "images" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0o644))
+
+	importImage := func(settings map[string]interface{}) ([]byte, error) {
+		options, err := newHTTPOptions(settings)
+		if err != nil {
+			return nil, err
+		}
+		img, err := newImageWithOptions(server.URL+"/image.raw", options)
+		if err != nil {
+			return nil, err
+		}
+		var imported bytes.Buffer
+		err = img.Import(func(r io.Reader) error {
+			_, err := io.Copy(&imported, r)
+			return err
+		}, newDefVolume())
+		return imported.Bytes(), err
+	}
+
+	// the certificate of the server is not trusted
+	_, err := importImage(map[string]interface{}{"bearer_token": "s3cret"})
+	assert.Error(t, err)
+
+	// no credentials
+	_, err = importImage(map[string]interface{}{"ca_file": caFile})
+	assert.Error(t, err)
+
+	for _, settings := range []map[string]interface{}{
+		{"ca_file": caFile},
+		{"insecure": true},
+	} {
+		settings["bearer_token"] = "s3cret"
+		settings["headers"] = map[string]interface{}{"X-Artifact-Repo": "images"}
+		imported, err := importImage(settings)
+		require.NoError(t, err)
+		assert.Equal(t, content, imported)
+	}
+}
+
+func TestHTTPImageResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var requests, resumed int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+
+		var offset int
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			assert.Equal(t, `"v1"`, r.Header.Get("If-Range"))
+			_, err := fmt.Sscanf(rangeHeader, "bytes=%d-", &offset)
+			require.NoError(t, err)
+			atomic.AddInt32(&resumed, 1)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+
+		// every response is cut after a third of the image
+		end := offset + len(content)/3
+		if end > len(content) {
+			end = len(content)
+		}
+		w.Write(content[offset:end])
+		if end < len(content) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	options, err := newHTTPOptions(map[string]interface{}{"retry_wait": "10ms"})
+	require.NoError(t, err)
+	img, err := newImageWithOptions(server.URL+"/image.raw", options)
+	require.NoError(t, err)
+
+	var imported bytes.Buffer
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume()))
+	assert.Equal(t, content, imported.Bytes())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&resumed))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// giving up after the configured retries
+	options, err = newHTTPOptions(map[string]interface{}{"retries": 1, "retry_wait": "10ms"})
+	require.NoError(t, err)
+	img, err = newImageWithOptions(server.URL+"/image.raw", options)
+	require.NoError(t, err)
+	assert.Error(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}, newDefVolume()))
+}
+
+func TestHTTPImageResumeRetried(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"v1"`)
+
+		switch request {
+		case 1:
+			// the download is cut in the middle
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+		case 2:
+			// and the first resume fails
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			var offset int
+			_, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+			require.NoError(t, err)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[offset:])
+		}
+	}))
+	defer server.Close()
+
+	options, err := newHTTPOptions(map[string]interface{}{"retries": 2, "retry_wait": "10ms"})
+	require.NoError(t, err)
+	img, err := newImageWithOptions(server.URL+"/image.raw", options)
+	require.NoError(t, err)
+
+	var imported bytes.Buffer
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume()))
+	assert.Equal(t, content, imported.Bytes())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestHTTPImageResumeWrongRange(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") != "" {
+			// a partial answer not starting where the download was cut
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:len(content)/2])
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	options, err := newHTTPOptions(map[string]interface{}{"retry_wait": "10ms"})
+	require.NoError(t, err)
+	img, err := newImageWithOptions(server.URL+"/image.raw", options)
+	require.NoError(t, err)
+
+	var imported bytes.Buffer
+	err = img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume())
+	assert.ErrorContains(t, err, "with range 'bytes 0-")
+	assert.Equal(t, content[:len(content)/2], imported.Bytes())
+}
+
+func TestContentRangeStart(t *testing.T) {
+	start, ok := contentRangeStart("bytes 100-199/200")
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), start)
+
+	start, ok = contentRangeStart("bytes 42-99/*")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), start)
+
+	_, ok = contentRangeStart("bytes */200")
+	assert.False(t, ok)
+	_, ok = contentRangeStart("")
+	assert.False(t, ok)
+}
@@ -1,13 +1,15 @@
 package libvirt
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -22,13 +24,73 @@ type image interface {
 	Size() (uint64, error)
 	Import(func(io.Reader) error, libvirtxml.StorageVolume) error
 	String() string
-	IsQCOW2() (bool, error)
+	// Format returns the format of the image, as named by qemu-img
+	Format() (string, error)
 }
 
 type localImage struct {
 	path string
 }
 
+// spoolImage writes what write produces to a sparse temporary file, for the images
+// that can only be read as a stream to be read again without fetching them. The
+// returned cleanup function removes the file.
+func spoolImage(name string, write func(io.Writer) error) (*localImage, func(), error) {
+	tmpDir, err := os.MkdirTemp("", "terraform-provider-libvirt-spool")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Printf("[WARN] Could not remove %s: %s", tmpDir, err)
+		}
+	}
+
+	spooled := &localImage{path: filepath.Join(tmpDir, "image")}
+	err = func() error {
+		file, err := os.Create(spooled.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		sparse, err := newSparseFileWriter(file)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriterSize(sparse, copierBufferSize)
+		if err := write(w); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := sparse.Close(); err != nil {
+			return err
+		}
+		return file.Close()
+	}()
+	if err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("error while reading %s: %w", name, err)
+	}
+
+	log.Printf("[DEBUG] Spooled %s to %s", name, spooled.path)
+	return spooled, cleanup, nil
+}
+
+// cleanupImage removes the temporary files of img, if any.
+func cleanupImage(img image) {
+	switch i := img.(type) {
+	case *verifiedImage:
+		cleanupImage(i.image)
+	case *compressedImage:
+		i.cleanup()
+	case *ociImage:
+		i.cleanup()
+	}
+}
+
 func (i *localImage) String() string {
 	return i.path
 }
@@ -47,20 +109,30 @@ func (i *localImage) Size() (uint64, error) {
 	return uint64(fi.Size()), nil
 }
 
-//nolint:mnd
-func (i *localImage) IsQCOW2() (bool, error) {
+func (i *localImage) Format() (string, error) {
 	file, err := os.Open(i.path)
 	if err != nil {
-		return false, fmt.Errorf("error while opening %s: %w", i.path, err)
+		return "", fmt.Errorf("error while opening %s: %w", i.path, err)
 	}
 	defer file.Close()
 
-	buf := make([]byte, 8)
-	_, err = io.ReadAtLeast(file, buf, 8)
+	header, err := readImageHeader(file)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := file.Stat()
 	if err != nil {
-		return false, err
+		return "", err
+	}
+	var footer []byte
+	if fi.Size() >= 2*vhdFooterSize {
+		footer = make([]byte, vhdFooterSize)
+		if _, err := file.ReadAt(footer, fi.Size()-vhdFooterSize); err != nil {
+			return "", err
+		}
 	}
-	return isQCOW2Header(buf)
+	return detectImageFormat(header, footer), nil
 }
 
 func (i *localImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
@@ -87,20 +159,36 @@ func (i *localImage) Import(uploader func(io.Reader) error, vol libvirtxml.Stora
 
 type httpImage struct {
 	url *url.URL
+	// download settings, the default ones when nil
+	options *httpOptions
 }
 
 func (i *httpImage) String() string {
 	return i.url.String()
 }
 
+func (i *httpImage) httpOptions() *httpOptions {
+	if i.options == nil {
+		i.options = defaultHTTPOptions()
+	}
+	return i.options
+}
+
 func (i *httpImage) Size() (uint64, error) {
-	response, err := http.Head(i.url.String())
+	options := i.httpOptions()
+	req, err := options.newRequest("HEAD", i.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := options.client.Do(req)
 	if err != nil {
 		return 0, err
 	}
+	response.Body.Close()
 	if response.StatusCode == http.StatusForbidden {
 		// possibly only the HEAD method is forbidden, try a Body-less GET instead
-		response, err = http.Get(i.url.String())
+		req.Method = "GET"
+		response, err = options.client.Do(req)
 		if err != nil {
 			return 0, err
 		}
@@ -127,60 +215,177 @@ func (i *httpImage) Size() (uint64, error) {
 	return uint64(length), nil
 }
 
-func (i *httpImage) IsQCOW2() (bool, error) {
-	return strings.ToLower(strings.TrimPrefix(path.Ext(i.url.Path), ".")) == "qcow2", nil
-}
+// readRange reads the given range of the image, as in a Range header, returning
+// nil when the server does not support ranges.
+func (i *httpImage) readRange(byteRange string, size int) ([]byte, error) {
+	options := i.httpOptions()
+	header := http.Header{}
+	header.Set("Range", "bytes="+byteRange)
+	req, err := options.newRequest("GET", i.url, header)
+	if err != nil {
+		return nil, err
+	}
+	response, err := options.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 
-func (i *httpImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
-	// number of download retries on non client errors (eg. 5xx)
-	const maxHTTPRetries int = 3
-	// wait time between retries
-	const retryWait time.Duration = 2 * time.Second
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("error accessing remote resource: %s - %s", i.url.String(), response.Status)
+	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", i.url.String(), nil)
+	buf := make([]byte, size)
+	n, err := io.ReadFull(response.Body, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Format reads the start of the image, and its end for VHD images, with range
+// requests, falling back to the start of a full download without range support.
+func (i *httpImage) Format() (string, error) {
+	header, err := i.readRange("0-"+strconv.Itoa(imageFormatHeaderSize-1), imageFormatHeaderSize)
 	if err != nil {
-		log.Printf("[DEBUG:] Error creating new request for source url %s: %s", i.url.String(), err)
-		return fmt.Errorf("error while downloading %s: %w", i.url.String(), err)
+		return "", err
+	}
+	if header == nil {
+		return detectStreamFormat(func(fn func(io.Reader) error) error {
+			return i.Import(fn, newDefVolume())
+		})
 	}
 
-	if vol.Target.Timestamps != nil && vol.Target.Timestamps.Mtime != "" {
-		req.Header.Set("If-Modified-Since", timeFromEpoch(vol.Target.Timestamps.Mtime).UTC().Format(http.TimeFormat))
+	format := detectImageFormat(header, nil)
+	if format == imageFormatRaw && len(header) == imageFormatHeaderSize {
+		footer, err := i.readRange("-"+strconv.Itoa(vhdFooterSize), vhdFooterSize)
+		if err != nil {
+			return "", err
+		}
+		format = detectImageFormat(header, footer)
+	}
+	return format, nil
+}
+
+// get requests the image with the given headers, retrying on network and server
+// errors. Only successful responses, including not modified ones, are returned, and
+// the caller must close their body, which resumes the download if the connection
+// fails while reading it.
+func (i *httpImage) get(header http.Header) (*http.Response, error) {
+	options := i.httpOptions()
+	req, err := options.newRequest("GET", i.url, header)
+	if err != nil {
+		log.Printf("[DEBUG:] Error creating new request for source url %s: %s", i.url.String(), err)
+		return nil, fmt.Errorf("error while downloading %s: %w", i.url.String(), err)
 	}
 
 	var response *http.Response
-	for retryCount := 0; retryCount < maxHTTPRetries; retryCount++ {
-		response, err = client.Do(req)
+	for retryCount := 0; retryCount <= options.retries; retryCount++ {
+		if retryCount > 0 {
+			time.Sleep(options.wait(retryCount - 1))
+		}
+
+		response, err = options.client.Do(req)
 		if err != nil {
-			return fmt.Errorf("error while downloading %s: %w", i.url.String(), err)
+			log.Printf("[DEBUG]: error downloading %s (retry #%d): %s", i.url.String(), retryCount, err)
+			// an untrusted certificate will not be trusted on the next try
+			var certErr *tls.CertificateVerificationError
+			if errors.As(err, &certErr) {
+				break
+			}
+			continue
 		}
-		defer response.Body.Close()
 
 		log.Printf("[DEBUG]: url resp status code %s (retry #%d)\n", response.Status, retryCount)
-		if response.StatusCode == http.StatusNotModified {
-			return nil
-		} else if response.StatusCode == http.StatusOK {
-			return uploader(response.Body)
-		} else if response.StatusCode < http.StatusInternalServerError {
+		if response.StatusCode == http.StatusOK {
+			response.Body = newResumingReader(i, options, response)
+			return response, nil
+		} else if response.StatusCode == http.StatusNotModified {
+			return response, nil
+		}
+		response.Body.Close()
+
+		if response.StatusCode < http.StatusInternalServerError {
+			// The problem is client side, retrying does not help
 			break
-		} else if retryCount < maxHTTPRetries {
-			// The problem is not client but server side
-			// retry a few times after a small wait
-			time.Sleep(retryWait)
 		}
 	}
 
-	return fmt.Errorf("error while downloading %s: %v", i.url.String(), response)
+	if err != nil {
+		return nil, fmt.Errorf("error while downloading %s: %w", i.url.String(), err)
+	}
+	return nil, fmt.Errorf("error while downloading %s: %s", i.url.String(), response.Status)
 }
 
+func (i *httpImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	header := http.Header{}
+	if vol.Target.Timestamps != nil && vol.Target.Timestamps.Mtime != "" {
+		header.Set("If-Modified-Since", timeFromEpoch(vol.Target.Timestamps.Mtime).UTC().Format(http.TimeFormat))
+	}
+
+	response, err := i.get(header)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	return uploader(response.Body)
+}
+
+// newImage returns the image for source. Compressed images, detected by their
+// suffix or, for local files, by their magic number, are decompressed while imported.
 func newImage(source string) (image, error) {
+	return newImageWithOptions(source, nil)
+}
+
+// newImageWithOptions returns the image for source, downloaded with the given
+// options when it is an http one or one in a registry.
+func newImageWithOptions(source string, options *httpOptions) (image, error) {
+	if isOCISource(source) {
+		// the disk image in the registry image is decompressed by ociImage
+		return newOCIImage(source, options)
+	}
+	if isLibvirtSource(source) {
+		return newLibvirtImage(source)
+	}
+
+	img, err := newUncompressedImage(source, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var c compression
+	switch i := img.(type) {
+	case *localImage:
+		if c, err = compressionFromFile(i.path); err != nil {
+			return nil, err
+		}
+	case *httpImage:
+		c = compressionFromName(i.url.Path)
+	}
+
+	if c != compressionNone {
+		log.Printf("[DEBUG] Image %s is compressed with %s", img, c)
+		return &compressedImage{image: img, compression: c}, nil
+	}
+	return img, nil
+}
+
+func newUncompressedImage(source string, options *httpOptions) (image, error) {
 	url, err := url.Parse(source)
 	if err != nil {
 		return nil, fmt.Errorf("can't parse source '%s' as url: %w", source, err)
 	}
 
 	if strings.HasPrefix(url.Scheme, "http") {
-		return &httpImage{url: url}, nil
+		return &httpImage{url: url, options: options}, nil
 	} else if url.Scheme == "file" && runtime.GOOS == "windows" {
 		// workaround #1, file:///C:/foo/bar.iso URL on Windows path has "/" prefix
 		// making it invalid
@@ -198,19 +403,3 @@ func newImage(source string) (image, error) {
 		return nil, fmt.Errorf("don't know how to handle image URI from '%v' (scheme: %s)", url, url.Scheme)
 	}
 }
-
-// isQCOW2Header returns True when the buffer starts with the qcow2 header.
-//
-//nolint:mnd
-func isQCOW2Header(buf []byte) (bool, error) {
-	if len(buf) < 8 {
-		return false, fmt.Errorf("expected header of 8 bytes. Got %d", len(buf))
-	}
-	if buf[0] == 'Q' && buf[1] == 'F' &&
-		buf[2] == 'I' && buf[3] == 0xfb &&
-		buf[4] == 0x00 && buf[5] == 0x00 &&
-		buf[6] == 0x00 && buf[7] == 0x03 {
-		return true, nil
-	}
-	return false, nil
-}
@@ -20,12 +20,12 @@ import (
 
 func TestNewImage(t *testing.T) {
 	fixtures := []struct {
-		Name    string
-		Size    uint64
-		IsQCOW2 bool
+		Name   string
+		Size   uint64
+		Format string
 	}{
-		{"test.qcow2", 196616, true},
-		{"tcl.iso", 16834560, false},
+		{"test.qcow2", 196616, "qcow2"},
+		{"tcl.iso", 16834560, "iso"},
 	}
 
 	testdata, err := filepath.Abs("testdata")
@@ -75,12 +75,12 @@ func TestNewImage(t *testing.T) {
 			}
 			assert.Equal(t, ex.Image, img)
 			assert.Equal(t, ex.AsString, img.String(), ex.Source)
-			isQCOW2, err := img.IsQCOW2()
+			format, err := img.Format()
 			if err != nil {
 				t.Error(err)
 				continue
 			}
-			assert.Equal(t, fixture.IsQCOW2, isQCOW2)
+			assert.Equal(t, fixture.Format, format, ex.Source)
 
 			size, err := img.Size()
 			if err != nil {
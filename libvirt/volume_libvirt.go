@@ -0,0 +1,179 @@
+package libvirt
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+const libvirtSourcePrefix = "libvirt://"
+
+// isLibvirtSource returns whether source is a volume of a libvirt host, as
+// libvirt://<uri>/<pool>/<volume>.
+func isLibvirtSource(source string) bool {
+	return strings.HasPrefix(source, libvirtSourcePrefix)
+}
+
+// parseLibvirtSource splits a libvirt://<uri>/<pool>/<volume> source. The uri is
+// empty for the connection of the provider, like in libvirt:///<pool>/<volume>.
+// The uri can have slashes, so the pool and the volume are the last two parts.
+func parseLibvirtSource(source string) (string, string, string, error) {
+	rest := strings.TrimPrefix(source, libvirtSourcePrefix)
+
+	var pool, name string
+	if i := strings.LastIndex(rest, "/"); i >= 0 {
+		name, rest = rest[i+1:], rest[:i]
+		if i := strings.LastIndex(rest, "/"); i >= 0 {
+			pool, rest = rest[i+1:], rest[:i]
+		}
+	}
+	if pool == "" || name == "" {
+		return "", "", "", fmt.Errorf("invalid volume source '%s', must be libvirt://<uri>/<pool>/<volume>", source)
+	}
+	return rest, pool, name, nil
+}
+
+// libvirtImage is a volume of a libvirt host, possibly another one than the
+// provider's, streamed from its download stream to the upload one of the new volume.
+type libvirtImage struct {
+	source string
+	uri    string
+	pool   string
+	name   string
+	// connection to the host of the volume, set by connect
+	virConn *libvirt.Libvirt
+}
+
+func newLibvirtImage(source string) (*libvirtImage, error) {
+	uri, pool, name, err := parseLibvirtSource(source)
+	if err != nil {
+		return nil, err
+	}
+	return &libvirtImage{source: source, uri: uri, pool: pool, name: name}, nil
+}
+
+func (i *libvirtImage) String() string {
+	return i.source
+}
+
+// connect opens a connection to the host of the volume, to providerURI when the
+// source has no uri. It must be closed with disconnect.
+//
+// Even volumes of the provider's host get their own connection: go-libvirt passes
+// the replies of a connection one at a time, so a download waiting for the upload
+// would hold back the replies to the upload on the same connection.
+func (i *libvirtImage) connect(providerURI string) error {
+	uri := i.uri
+	if uri == "" {
+		uri = providerURI
+	}
+
+	virConn, err := connectURI(uri)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", uri, err)
+	}
+	i.virConn = virConn
+	return nil
+}
+
+func (i *libvirtImage) disconnect() {
+	if i.virConn == nil {
+		return
+	}
+	if err := i.virConn.Disconnect(); err != nil {
+		log.Printf("[WARN] Could not disconnect from the host of %s: %s", i.source, err)
+	}
+	i.virConn = nil
+}
+
+func (i *libvirtImage) lookup() (libvirt.StorageVol, error) {
+	if i.virConn == nil {
+		return libvirt.StorageVol{}, fmt.Errorf("not connected to the host of %s", i.source)
+	}
+
+	pool, err := i.virConn.StoragePoolLookupByName(i.pool)
+	if err != nil {
+		return libvirt.StorageVol{}, fmt.Errorf("can't find storage pool '%s': %w", i.pool, err)
+	}
+	volume, err := i.virConn.StorageVolLookupByName(pool, i.name)
+	if err != nil {
+		return libvirt.StorageVol{}, fmt.Errorf("can't retrieve volume '%s' of pool '%s': %w", i.name, i.pool, err)
+	}
+	return volume, nil
+}
+
+// Size returns the size of the content of the volume, which for images like qcow2
+// ones is the size of the file, not their capacity.
+func (i *libvirtImage) Size() (uint64, error) {
+	volume, err := i.lookup()
+	if err != nil {
+		return 0, err
+	}
+	_, _, physical, err := i.virConn.StorageVolGetInfoFlags(volume, uint32(libvirt.StorageVolGetPhysical))
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving size of %s: %w", i.source, err)
+	}
+	return physical, nil
+}
+
+// definition returns the definition of the volume, failing for volumes with a
+// backing store: only their top layer would be copied, pointing to a backing file
+// that usually does not exist where the copy is.
+func (i *libvirtImage) definition(volume libvirt.StorageVol) (libvirtxml.StorageVolume, error) {
+	volumeDef, err := newDefVolumeFromLibvirt(i.virConn, volume)
+	if err != nil {
+		return volumeDef, err
+	}
+	if volumeDef.BackingStore != nil && volumeDef.BackingStore.Path != "" {
+		return volumeDef, fmt.Errorf("volume %s is backed by %s and can't be copied, only volumes without a backing store can be used as source",
+			i.source, volumeDef.BackingStore.Path)
+	}
+	return volumeDef, nil
+}
+
+func (i *libvirtImage) Format() (string, error) {
+	volume, err := i.lookup()
+	if err != nil {
+		return "", err
+	}
+	volumeDef, err := i.definition(volume)
+	if err != nil {
+		return "", err
+	}
+	if volumeDef.Target == nil || volumeDef.Target.Format == nil || volumeDef.Target.Format.Type == "" {
+		return imageFormatRaw, nil
+	}
+	return volumeDef.Target.Format.Type, nil
+}
+
+// Import streams the volume to uploader. The holes of the volume are downloaded as
+// zeros, which the sparse uploader skips again.
+func (i *libvirtImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	volume, err := i.lookup()
+	if err != nil {
+		return err
+	}
+	if _, err := i.definition(volume); err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := i.virConn.StorageVolDownload(volume, writer, 0, 0, 0)
+		writer.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	err = uploader(reader)
+	// stop the download if the upload ended early
+	reader.CloseWithError(err)
+	if downloadErr := <-downloaded; downloadErr != nil && err == nil {
+		return fmt.Errorf("error while downloading %s: %w", i.source, downloadErr)
+	}
+	return err
+}
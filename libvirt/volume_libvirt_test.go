@@ -0,0 +1,30 @@
+package libvirt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLibvirtSource(t *testing.T) {
+	for source, expected := range map[string][3]string{
+		"libvirt:///default/leap.qcow2":                                   {"", "default", "leap.qcow2"},
+		"libvirt://qemu:///system/images/leap.qcow2":                      {"qemu:///system", "images", "leap.qcow2"},
+		"libvirt://qemu+ssh://root@golden/system/default/leap.qcow2":      {"qemu+ssh://root@golden/system", "default", "leap.qcow2"},
+		"libvirt://qemu+ssh://golden/system?keyfile=/root/.ssh/id/pool/v": {"qemu+ssh://golden/system?keyfile=/root/.ssh/id", "pool", "v"},
+	} {
+		uri, pool, name, err := parseLibvirtSource(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, expected, [3]string{uri, pool, name}, source)
+	}
+
+	for _, source := range []string{"libvirt://", "libvirt:///leap.qcow2", "libvirt:///default/"} {
+		_, _, _, err := parseLibvirtSource(source)
+		assert.Error(t, err, source)
+	}
+
+	img, err := newImage("libvirt:///default/leap.qcow2")
+	require.NoError(t, err)
+	assert.IsType(t, &libvirtImage{}, img)
+}
@@ -0,0 +1,534 @@
+package libvirt
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	ociMediaTypeManifest        = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeIndex           = "application/vnd.oci.image.index.v1+json"
+	dockerMediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerMediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// annotation with the file name of artifact layers
+	ociAnnotationTitle = "org.opencontainers.image.title"
+
+	// the directory with the disk image in containerdisks, as used by KubeVirt
+	containerDiskDir = "disk"
+
+	// manifests are small, don't read more than this from the registry
+	maxOCIManifestSize = 4 * 1024 * 1024
+
+	dockerHubRegistry = "docker.io"
+	dockerHubAPIHost  = "registry-1.docker.io"
+)
+
+// ociReference is a reference to an image in a registry, as in
+// <registry>/<repository>:<tag> or <registry>/<repository>@<digest>.
+type ociReference struct {
+	registry   string
+	repository string
+	// tag or digest
+	reference string
+}
+
+// parseOCIReference parses the oci:// and docker:// sources. Like docker does, the
+// registry defaults to docker.io, where official images are in the library namespace.
+func parseOCIReference(source string) (*ociReference, error) {
+	name := source
+	for _, prefix := range []string{"oci://", "docker://"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+
+	ref := &ociReference{registry: dockerHubRegistry, reference: "latest"}
+	if first, rest, found := strings.Cut(name, "/"); found &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry = first
+		name = rest
+	}
+
+	if repository, digest, found := strings.Cut(name, "@"); found {
+		name = repository
+		ref.reference = digest
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.reference = name[i+1:]
+		name = name[:i]
+	}
+
+	if ref.registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || ref.reference == "" {
+		return nil, fmt.Errorf("invalid image reference '%s'", source)
+	}
+	ref.repository = name
+	return ref, nil
+}
+
+func (r *ociReference) String() string {
+	if r.isDigest() {
+		return r.registry + "/" + r.repository + "@" + r.reference
+	}
+	return r.registry + "/" + r.repository + ":" + r.reference
+}
+
+func (r *ociReference) isDigest() bool {
+	return strings.Contains(r.reference, ":")
+}
+
+// url returns the url of the registry API at the given path, over plain http for
+// registries on the local host, like docker does.
+func (r *ociReference) url(apiPath string) *url.URL {
+	host := r.registry
+	if host == dockerHubRegistry {
+		host = dockerHubAPIHost
+	}
+
+	scheme := "https"
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" {
+		scheme = "http"
+	} else if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+		scheme = "http"
+	}
+
+	return &url.URL{Scheme: scheme, Host: host, Path: path.Join("/v2", apiPath)}
+}
+
+// ociDescriptor describes a manifest or a layer.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+// ociManifest is either an image manifest, with layers, or an index of manifests.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociArchitecture returns the architecture name used in OCI images for a libvirt one.
+func ociArchitecture(arch string) string {
+	switch arch {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	case "i686":
+		return "386"
+	case "armv7l":
+		return "arm"
+	}
+	return arch
+}
+
+func newDigestHash(digest string) (hash.Hash, string, error) {
+	algorithm, checksum, _ := strings.Cut(digest, ":")
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), checksum, nil
+	case "sha512":
+		return sha512.New(), checksum, nil
+	}
+	return nil, "", fmt.Errorf("unsupported digest '%s'", digest)
+}
+
+func verifyDigest(h hash.Hash, expected string, what string) error {
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s", what, expected, actual)
+	}
+	return nil
+}
+
+// ociImage is a disk image stored in a registry, either as a containerdisk, a
+// container image with the disk image in its /disk directory, or as an artifact
+// whose layer is the disk image itself.
+type ociImage struct {
+	source  string
+	ref     *ociReference
+	options *httpOptions
+	// the architecture of the image to use from multi-platform images
+	architecture string
+	// token given by the registry, once authenticated
+	token  string
+	layers []ociDescriptor
+	// the decompressed disk image, once pulled, and how to remove it
+	spooled       *localImage
+	removeSpooled func()
+}
+
+func newOCIImage(source string, options *httpOptions) (*ociImage, error) {
+	ref, err := parseOCIReference(source)
+	if err != nil {
+		return nil, err
+	}
+	if options == nil {
+		options = defaultHTTPOptions()
+	}
+	return &ociImage{source: source, ref: ref, options: options, architecture: runtime.GOARCH}, nil
+}
+
+func (i *ociImage) String() string {
+	return i.source
+}
+
+var authChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate gets a token from the registry when it asks for one, using the
+// credentials of source_http, if any.
+func (i *ociImage) authenticate() error {
+	req, err := i.options.newRequest("GET", i.ref.url("/"), nil)
+	if err != nil {
+		return err
+	}
+	response, err := i.options.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting registry %s: %w", i.ref.registry, err)
+	}
+	response.Body.Close()
+
+	challenge := response.Header.Get("WWW-Authenticate")
+	if response.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return nil
+	}
+
+	params := map[string]string{}
+	for _, match := range authChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid authentication challenge from registry %s: %s", i.ref.registry, challenge)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+i.ref.repository+":pull")
+	realm.RawQuery = query.Encode()
+
+	req, err = i.options.newRequest("GET", realm, nil)
+	if err != nil {
+		return err
+	}
+	response, err = i.options.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error authenticating to registry %s: %w", i.ref.registry, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error authenticating to registry %s: %s", i.ref.registry, response.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return fmt.Errorf("error reading token from registry %s: %w", i.ref.registry, err)
+	}
+	i.token = token.Token
+	if i.token == "" {
+		i.token = token.AccessToken
+	}
+	return nil
+}
+
+// get requests a path of the registry API, with its token.
+func (i *ociImage) get(apiPath string, header http.Header) (*http.Response, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	if i.token != "" {
+		header.Set("Authorization", "Bearer "+i.token)
+	}
+	remote := &httpImage{url: i.ref.url(apiPath), options: i.options}
+	return remote.get(header)
+}
+
+func (i *ociImage) fetchManifest(reference string) (*ociManifest, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join([]string{
+		ociMediaTypeManifest, ociMediaTypeIndex, dockerMediaTypeManifest, dockerMediaTypeManifestList,
+	}, ", "))
+
+	response, err := i.get(path.Join(i.ref.repository, "manifests", reference), header)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxOCIManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest of %s: %w", i.ref, err)
+	}
+
+	// manifests fetched by digest must have it
+	if strings.Contains(reference, ":") {
+		h, expected, err := newDigestHash(reference)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(content)
+		if err := verifyDigest(h, expected, "manifest of "+i.ref.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest of %s: %w", i.ref, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = response.Header.Get("Content-Type")
+	}
+	return &manifest, nil
+}
+
+// resolve finds the layers of the image, choosing the manifest for the architecture
+// of the image in multi-platform ones.
+func (i *ociImage) resolve() ([]ociDescriptor, error) {
+	if i.layers != nil {
+		return i.layers, nil
+	}
+
+	if err := i.authenticate(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := i.fetchManifest(i.ref.reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.MediaType == ociMediaTypeIndex || manifest.MediaType == dockerMediaTypeManifestList || len(manifest.Manifests) > 0 {
+		var platforms []string
+		var chosen *ociDescriptor
+		for n, m := range manifest.Manifests {
+			if m.Platform == nil {
+				continue
+			}
+			platforms = append(platforms, m.Platform.OS+"/"+m.Platform.Architecture)
+			if m.Platform.Architecture == i.architecture && (m.Platform.OS == "linux" || m.Platform.OS == "") {
+				chosen = &manifest.Manifests[n]
+				break
+			}
+		}
+		if chosen == nil && len(manifest.Manifests) == 1 {
+			chosen = &manifest.Manifests[0]
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("no image for architecture %s in %s, found: %s",
+				i.architecture, i.ref, strings.Join(platforms, ", "))
+		}
+		log.Printf("[DEBUG] Using manifest %s of %s for %s", chosen.Digest, i.ref, i.architecture)
+
+		if manifest, err = i.fetchManifest(chosen.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("image %s has no layers", i.ref)
+	}
+	i.layers = manifest.Layers
+	return i.layers, nil
+}
+
+// layerCompression returns the compression of a tar layer from its media type.
+func layerCompression(mediaType string) compression {
+	switch {
+	case strings.HasSuffix(mediaType, "gzip"):
+		return compressionGzip
+	case strings.HasSuffix(mediaType, "zstd"):
+		return compressionZstd
+	}
+	return compressionNone
+}
+
+func isTarLayer(mediaType string) bool {
+	return strings.Contains(mediaType, ".tar") || strings.Contains(mediaType, "rootfs.diff")
+}
+
+// isContainerDisk returns whether the tar entry is the disk image of a containerdisk.
+func isContainerDisk(header *tar.Header) bool {
+	name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+	return (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) &&
+		path.Dir(name) == containerDiskDir
+}
+
+// disk calls fn with the disk image of the image and its name. The digest of the
+// layer with the disk image is verified once fn read it, unless fn fails.
+func (i *ociImage) disk(fn func(name string, r io.Reader) error) error {
+	layers, err := i.resolve()
+	if err != nil {
+		return err
+	}
+
+	// the disk image of the upper layers hides the one of the lower ones
+	for n := len(layers) - 1; n >= 0; n-- {
+		layer := layers[n]
+		found, err := i.layerDisk(layer, fn)
+		if err != nil || found {
+			return err
+		}
+	}
+	return fmt.Errorf("no disk image found in /%s of %s", containerDiskDir, i.ref)
+}
+
+func (i *ociImage) layerDisk(layer ociDescriptor, fn func(name string, r io.Reader) error) (bool, error) {
+	h, expected, err := newDigestHash(layer.Digest)
+	if err != nil {
+		return false, err
+	}
+
+	response, err := i.get(path.Join(i.ref.repository, "blobs", layer.Digest), nil)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	blob := io.TeeReader(response.Body, h)
+
+	if !isTarLayer(layer.MediaType) {
+		name := layer.Annotations[ociAnnotationTitle]
+		if name == "" {
+			name = layer.Digest
+		}
+		if err := fn(name, blob); err != nil {
+			return true, err
+		}
+	} else {
+		layerReader, err := newDecompressingReader(layerCompression(layer.MediaType), blob)
+		if err != nil {
+			return false, fmt.Errorf("error reading layer %s of %s: %w", layer.Digest, i.ref, err)
+		}
+		defer layerReader.Close()
+
+		tr := tar.NewReader(layerReader)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			} else if err != nil {
+				return false, fmt.Errorf("error reading layer %s of %s: %w", layer.Digest, i.ref, err)
+			}
+
+			if isContainerDisk(header) {
+				log.Printf("[DEBUG] Found disk image %s in layer %s of %s", header.Name, layer.Digest, i.ref)
+				if err := fn(path.Base(header.Name), tr); err != nil {
+					return true, err
+				}
+				break
+			}
+		}
+	}
+
+	// hash what is left of the layer, to verify it
+	if _, err := io.Copy(io.Discard, blob); err != nil {
+		return true, fmt.Errorf("error reading layer %s of %s: %w", layer.Digest, i.ref, err)
+	}
+	return true, verifyDigest(h, expected, "layer "+layer.Digest+" of "+i.ref.String())
+}
+
+// decompressedDisk calls fn with the disk image, decompressed when its name has the
+// suffix of a compressed one, as KubeVirt allows for containerdisks.
+func (i *ociImage) decompressedDisk(fn func(r io.Reader) error) error {
+	return i.disk(func(name string, r io.Reader) error {
+		c := compressionFromName(name)
+		if c == compressionNone {
+			return fn(r)
+		}
+
+		dr, err := newDecompressingReader(c, r)
+		if err != nil {
+			return fmt.Errorf("error decompressing %s of %s: %w", name, i.ref, err)
+		}
+		defer dr.Close()
+		return fn(dr)
+	})
+}
+
+// pull pulls the disk image once to a temporary file, decompressed, from which its
+// size, format and content are read.
+func (i *ociImage) pull() (*localImage, error) {
+	if i.spooled != nil {
+		return i.spooled, nil
+	}
+
+	spooled, cleanup, err := spoolImage(i.String(), func(w io.Writer) error {
+		return i.decompressedDisk(func(r io.Reader) error {
+			_, err := io.Copy(w, r)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	i.spooled, i.removeSpooled = spooled, cleanup
+	return spooled, nil
+}
+
+// cleanup removes the pulled disk image.
+func (i *ociImage) cleanup() {
+	if i.removeSpooled != nil {
+		i.removeSpooled()
+	}
+	i.spooled, i.removeSpooled = nil, nil
+}
+
+func (i *ociImage) Size() (uint64, error) {
+	spooled, err := i.pull()
+	if err != nil {
+		return 0, err
+	}
+	return spooled.Size()
+}
+
+func (i *ociImage) Format() (string, error) {
+	spooled, err := i.pull()
+	if err != nil {
+		return "", err
+	}
+	return spooled.Format()
+}
+
+func (i *ociImage) Import(uploader func(io.Reader) error, vol libvirtxml.StorageVolume) error {
+	spooled, err := i.pull()
+	if err != nil {
+		return err
+	}
+	return spooled.Import(uploader, newDefVolume())
+}
+
+// isOCISource returns whether source is an image in a registry.
+func isOCISource(source string) bool {
+	return strings.HasPrefix(source, "oci://") || strings.HasPrefix(source, "docker://")
+}
@@ -0,0 +1,184 @@
+package libvirt
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	for source, expected := range map[string]ociReference{
+		"oci://quay.io/containerdisks/fedora:40": {"quay.io", "containerdisks/fedora", "40"},
+		"docker://localhost:5000/disks/leap":     {"localhost:5000", "disks/leap", "latest"},
+		"docker://alpine":                        {"docker.io", "library/alpine", "latest"},
+		"oci://example/disk:1.0":                 {"docker.io", "example/disk", "1.0"},
+		"oci://127.0.0.1:5000/disk@sha256:abcd":  {"127.0.0.1:5000", "disk", "sha256:abcd"},
+	} {
+		ref, err := parseOCIReference(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, expected, *ref, source)
+	}
+
+	_, err := parseOCIReference("oci://quay.io/disk:")
+	assert.Error(t, err)
+
+	ref, _ := parseOCIReference("oci://127.0.0.1:5000/disk:1")
+	assert.Equal(t, "http://127.0.0.1:5000/v2/disk/manifests/1", ref.url("disk/manifests/1").String())
+	ref, _ = parseOCIReference("docker://alpine")
+	assert.Equal(t, "https://registry-1.docker.io/v2/library/alpine/manifests/latest", ref.url("library/alpine/manifests/latest").String())
+}
+
+// registry is a minimal stand-in of an OCI registry, asking for a token.
+type registry struct {
+	*httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	pulls     map[string]int
+}
+
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newRegistry(t *testing.T) *registry {
+	r := &registry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, pulls: map[string]int{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			assert.Equal(t, "repository:containerdisks/leap:pull", req.URL.Query().Get("scope"))
+			json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="registry.test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/v2/containerdisks/leap/manifests/"):
+			manifest, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/containerdisks/leap/manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(manifest)
+		case strings.HasPrefix(req.URL.Path, "/v2/containerdisks/leap/blobs/"):
+			digest := strings.TrimPrefix(req.URL.Path, "/v2/containerdisks/leap/blobs/")
+			blob, ok := r.blobs[digest]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			r.pulls[digest]++
+			w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return r
+}
+
+// push adds a containerdisk with the given disk image, for amd64 and arm64.
+func (r *registry) push(t *testing.T, tag string, disk []byte) string {
+	var layer bytes.Buffer
+	gz := gzip.NewWriter(&layer)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "disk/", Typeflag: tar.TypeDir, Mode: 0o555}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "disk/leap.qcow2", Typeflag: tar.TypeReg, Mode: 0o444, Size: int64(len(disk))}))
+	_, err := tw.Write(disk)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	layerDigest := sha256Digest(layer.Bytes())
+	r.blobs[layerDigest] = layer.Bytes()
+
+	manifest, err := json.Marshal(ociManifest{
+		MediaType: ociMediaTypeManifest,
+		Layers: []ociDescriptor{{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    layerDigest,
+			Size:      int64(layer.Len()),
+		}},
+	})
+	require.NoError(t, err)
+	manifestDigest := sha256Digest(manifest)
+	r.manifests[manifestDigest] = manifest
+
+	index := map[string]interface{}{
+		"mediaType": ociMediaTypeIndex,
+		"manifests": []map[string]interface{}{
+			{"mediaType": ociMediaTypeManifest, "digest": "sha256:0000", "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+			{"mediaType": ociMediaTypeManifest, "digest": manifestDigest, "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+		},
+	}
+	r.manifests[tag], err = json.Marshal(index)
+	require.NoError(t, err)
+	return layerDigest
+}
+
+func TestOCIImage(t *testing.T) {
+	disk, err := os.ReadFile("testdata/test.qcow2")
+	require.NoError(t, err)
+
+	r := newRegistry(t)
+	defer r.Close()
+	layerDigest := r.push(t, "15.6", disk)
+
+	source := "oci://" + strings.TrimPrefix(r.URL, "http://") + "/containerdisks/leap:15.6"
+	img, err := newImage(source)
+	require.NoError(t, err)
+	require.IsType(t, &ociImage{}, img)
+	defer cleanupImage(img)
+	img.(*ociImage).architecture = ociArchitecture("x86_64")
+
+	format, err := img.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "qcow2", format)
+
+	size, err := img.Size()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(disk)), size)
+
+	var imported bytes.Buffer
+	require.NoError(t, img.Import(func(r io.Reader) error {
+		_, err := io.Copy(&imported, r)
+		return err
+	}, newDefVolume()))
+	assert.Equal(t, disk, imported.Bytes())
+	// the disk image was pulled once
+	assert.Equal(t, 1, r.pulls[layerDigest])
+
+	// no image for the architecture
+	img, err = newImage(source)
+	require.NoError(t, err)
+	defer cleanupImage(img)
+	img.(*ociImage).architecture = "s390x"
+	_, err = img.Size()
+	assert.ErrorContains(t, err, "no image for architecture s390x")
+
+	// layers not matching their digest are rejected
+	r.blobs[layerDigest] = append(r.blobs[layerDigest][:len(r.blobs[layerDigest]):len(r.blobs[layerDigest])], 0)
+	img, err = newImage(source)
+	require.NoError(t, err)
+	defer cleanupImage(img)
+	img.(*ociImage).architecture = "amd64"
+	err = img.Import(func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}, newDefVolume())
+	assert.ErrorContains(t, err, "digest mismatch")
+}